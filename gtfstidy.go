@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"strconv"
@@ -120,7 +121,7 @@ func main() {
 	endDateFilter := flag.StringP("date-end", "", "", "end date filter, as YYYYMMDD")
 
 	fixShortHand := flag.BoolP("fix", "", false, "shorthand for -eDnz -p '-'")
-	compressShortHand := flag.BoolP("compress", "", false, "shorthand for -OSRCcIAP")
+	compressShortHand := flag.BoolP("compress", "", false, "shorthand for -OSRCcIAP --elide-fields=all")
 	minimizeShortHand := flag.BoolP("Compress", "", false, "shorthand for -OSRCcIAPdT --red-stops-fuzzy --red-trips-fuzzy, like --compress, but additionally compress stop times into frequencies, use fuzzy matching for redundant trip and stop removal and use dense character ids. The latter destroys any existing external references (like in GTFS realtime streams)")
 	mergeShortHand := flag.BoolP("merge", "", false, "shorthand for -ARPICO")
 	fuzzyMergeShortHand := flag.BoolP("Merge", "", false, "shorthand for -EARPICO --red-trips-fuzzy --red-stops-fuzzy")
@@ -135,12 +136,25 @@ func main() {
 	dropPlatformCodesForParentless := flag.BoolP("drop-platform-for-parentless", "", false, "drop platform codes for parentless stops")
 
 	nonOverlappingServices := flag.BoolP("non-overlapping-services", "", false, "create non-overlapping services")
+	holidaysFile := flag.StringP("holidays", "", "", "holiday calendar, as a file with one YYYYMMDD date (and optional name) per line or an iCalendar file ending with .ics; holidays are treated as a separate day type by -c and --non-overlapping-services")
+	nameServices := flag.BoolP("name-services", "", false, "replace service IDs by descriptive IDs derived from the calendar, like Mo-Fr_20260101-20261231, Sa+hol or Su_ex3")
+	serviceNameLocale := flag.StringP("service-name-locale", "", "en", "locale used for the day names in --name-services IDs, one of en,de,fr,nl,it,es")
 	groupAdjEquStops := flag.BoolP("group-adj-stop-times", "", false, "group adjacent stop times with eqv. stops")
 	groupAdjEquStopsAggressive := flag.BoolP("group-adj-stop-times-aggressive", "", false, "aggressivly group intra-station stops")
 	removeFillers := flag.BoolP("remove-fillers", "", false, "remove fill values (., .., .., -, ?) from some optional fields")
 
 	idPrefix := flag.StringP("prefix", "", "", "prefix used before all ids")
 
+	geojsonOut := flag.StringP("geojson-out", "", "", "write stops, shapes, trip patterns and route geometries of the output feed to this GeoJSON file")
+	geojsonRoutes := flag.StringSliceP("geojson-routes", "", []string{}, "only export these routes (comma-separated IDs, as in the output feed) in --geojson-out")
+	geojsonAgencies := flag.StringSliceP("geojson-agencies", "", []string{}, "only export routes of these agencies (comma-separated IDs, as in the output feed) in --geojson-out")
+	nameRulesFile := flag.StringP("normalize-names", "", "", "file with name normalization rules (case, keep, expand, contract, regex) for stop names, route long names, trip headsigns and agency names, changes are written to names.csv in --report-dir")
+	nameRulesDryRun := flag.BoolP("normalize-names-dry-run", "", false, "only report the changes of --normalize-names to names.csv in --report-dir, don't apply them")
+	stopOverridesFile := flag.StringP("stop-overrides", "", "", "file with manual stop clustering rules (merge,<ids...> / separate,<ids...> / parent,<parent id>,<ids...>), honored by stop duplicate removal, stop reclustering and parent enforcement")
+	reviewStopMerges := flag.BoolP("review-stop-merges", "", false, "write all stop merges done by -e/-E to stop_merges.geojson and stop_merges.csv in --report-dir, for manual review")
+	stopMergeReviewMargin := flag.Float64P("stop-merge-review-margin", "", 0.1, "flag stop merges for review if their score, name similarity or distance is within this (relative) margin of the threshold")
+	reportDir := flag.StringP("report-dir", "", "", "directory to write CSV reports of processor changes and findings to")
+
 	keepIds := flag.BoolP("keep-ids", "", false, "preserve station, fare, shape, route, trip, level, agency, pathway, and service IDs")
 	keepStationIds := flag.BoolP("keep-station-ids", "", false, "preserve station IDs")
 	keepStationIFTOPTIds := flag.BoolP("keep-station-ifopt-ids", "", false, "don't remove duplicate stops if they have different IFTOP ids")
@@ -155,24 +169,65 @@ func main() {
 	keepServiceIds := flag.BoolP("keep-service-ids", "", false, "preserve service IDs in calendar.txt and calendar_dates.txt")
 	keepAgencyIds := flag.BoolP("keep-agency-ids", "", false, "preserve agency IDs")
 	orphanDeleters := flag.StringSliceP("delete-orphans", "O", []string{}, "remove entities that are not referenced anywhere\ncomma-separated list of supported files:\nall,agency,routes,services,shapes,stops,transfers,trips")
+	elideFields := flag.StringSliceP("elide-fields", "", []string{}, "remove values consumers would inherit anyway\ncomma-separated list of supported fields:\nall,stop-timezone,wheelchair-boarding,continuous,route-long-name\nall does not include route-long-name, which changes the displayed name")
 	flag.Lookup("delete-orphans").NoOptDefVal = "all"
 	useShapeMinimizer := flag.BoolP("min-shapes", "s", false, "minimize shapes (using Douglas-Peucker)")
+	shapeMinimizerAlgo := flag.StringP("min-shapes-algo", "", "dp", "shape minimization algorithm, either dp (Douglas-Peucker) or vw (Visvalingam-Whyatt)")
+	useShapeMinimizerTopo := flag.BoolP("min-shapes-topo", "", false, "minimize shapes (implies -s), but simplify segments shared by several shapes only once, so overlapping shapes stay coincident")
+	shapeMinTopoDist := flag.Float64P("min-shapes-topo-dist", "", 1.0, "max distance (in meters) between points of different shapes to be treated as shared (and moved onto each other) in --min-shapes-topo")
 	useShapeRemeasurer := flag.BoolP("remeasure-shapes", "m", false, "remeasure shapes (filling measurement-holes)")
 	useStopTimeRemeasurer := flag.BoolP("remeasure-stop-times", "r", false, "remeasure stop times")
 	dropSingleStopTrips := flag.BoolP("drop-single-stop-trips", "", false, "drop trips with only 1 stop")
 	useShapeSnapper := flag.BoolP("snap-stops", "", false, "snap stop points to shape if dist > 100 m")
 	useRedShapeRemover := flag.BoolP("remove-red-shapes", "S", false, "remove shape duplicates")
+	osmShapesFile := flag.StringP("generate-shapes-osm", "", "", "generate shapes for trips without shapes by map-matching them onto the network in this OSM file (.osm XML or .pbf)")
+	useStraightShapes := flag.BoolP("generate-shapes-straight", "", false, "generate straight-line shapes through the stops for trips without shapes")
+	straightShapesMaxSegLen := flag.Float64P("straight-shapes-max-seg-len", "", 0, "if > 0, densify straight-line shape legs longer than this (in meters) along the great circle")
+	useShapeDirectionFixer := flag.BoolP("fix-shape-directions", "", false, "reverse shapes digitized against the stop order of their trips, and detach shapes not belonging to their trips")
+	useShapeCleaner := flag.BoolP("clean-shapes", "", false, "remove spikes, zig-zags and implausible detours from shapes")
+	shapeCleanerMaxTurnAngle := flag.Float64P("clean-shapes-max-turn-angle", "", 170, "in --clean-shapes, remove points where the shape turns by more than this angle (in degrees)")
+	shapeCleanerMaxDetour := flag.Float64P("clean-shapes-max-detour", "", 5, "in --clean-shapes, remove points creating a detour this many times longer than the direct connection of their neighbors")
+	shapeCleanerProtectDist := flag.Float64P("clean-shapes-protect-dist", "", 20, "in --clean-shapes, keep points whose removal would move the shape further than this distance (in meters) away from a served stop")
+	useShapeContainmentRemover := flag.BoolP("remove-contained-shapes", "", false, "remove shapes contained in longer shapes, trips are moved to the longer shape")
 	useRedRouteMinimizer := flag.BoolP("remove-red-routes", "R", false, "remove route duplicates")
 	useRedRouteMinimizerSharedStops := flag.BoolP("red-routes-must-share-station", "", false, "two routes are only merge if their trips share a station")
 	useRedServiceMinimizer := flag.BoolP("remove-red-services", "C", false, "remove duplicate services in calendar.txt and calendar_dates.txt")
+	minimizeFareRules := flag.BoolP("minimize-fare-rules", "", false, "merge fare zones with identical rules and replace exhaustive origin/destination enumerations in fare_rules.txt by wildcard rules. Together with -i or -d, zone IDs are minimized unless --keep-fare-ids is set")
 	useIDMinimizerNum := flag.BoolP("minimize-ids-num", "i", false, "minimize IDs using numerical IDs (e.g. 144, 145, 146...)")
 	useIDMinimizerChar := flag.BoolP("minimize-ids-char", "d", false, "minimize IDs using character IDs (e.g. abc, abd, abe, abf...)")
 	useServiceMinimizer := flag.BoolP("minimize-services", "c", false, "minimize services by searching for the optimal exception/range coverage")
 	useFrequencyMinimizer := flag.BoolP("minimize-stoptimes", "T", false, "search for frequency patterns in explicit trips and combine them, using a CAP approach")
 	useCalDatesRemover := flag.BoolP("remove-cal-dates", "", false, "don't use calendar_dates.txt")
 	explicitCals := flag.BoolP("explicit-calendar", "", false, "add calendar.txt entry for every service, even irregular ones")
+	updateFeedInfo := flag.BoolP("update-feed-info", "", false, "recompute feed_start_date and feed_end_date in feed_info.txt from the dates trips are active on, and keep only the first feed info if there are several (e.g. after merging)")
+	feedVersion := flag.StringP("feed-version", "", "", "set feed_version from this template, {hash} is replaced by a hash of the feed contents, {date} by the current date, {start} and {end} by the feed start and end date, {version} by the previous version (implies --update-feed-info)")
+	feedPublisherFile := flag.StringP("feed-publisher", "", "", "file with publisher fields for feed_info.txt, one <field>,<value> per line (implies --update-feed-info)")
+	createFeedInfo := flag.BoolP("create-feed-info", "", false, "create feed_info.txt if missing, with the publisher from --feed-publisher or from the single agency of the feed (implies --update-feed-info)")
 	ensureTripHeadsigns := flag.BoolP("ensure-trip-headsigns", "", false, "write trip headsigns if missing")
+	headsignsVia := flag.BoolP("headsigns-via", "", false, "extend generated trip headsigns to \"Destination via X\", with X the most distinctive intermediate station (implies --ensure-trip-headsigns)")
+	fillStopHeadsigns := flag.BoolP("fill-stop-headsigns", "", false, "fill in stop_headsigns for the outbound and inbound part of loop trips which get a generated headsign (implies --ensure-trip-headsigns), only loop trips without any stop_headsign are changed, destination changes on other trips are not detected")
+	elideStopHeadsigns := flag.BoolP("elide-stop-headsigns", "", false, "remove stop_headsigns equal to the trip headsign (implies --ensure-trip-headsigns)")
+	fixRouteColors := flag.BoolP("fix-route-colors", "", false, "set route_text_color to black or white where its contrast to route_color is too low")
+	routeMinContrast := flag.Float64P("route-min-contrast", "", 4.5, "min WCAG contrast ratio between route_color and route_text_color in --fix-route-colors")
+	assignRouteColors := flag.BoolP("assign-route-colors", "", false, "assign distinct colors to routes without a color, per agency and mode (implies --fix-route-colors)")
+	routePaletteFile := flag.StringP("route-palette", "", "", "file with the colors (one hex color per line) for --assign-route-colors")
+	inferDirections := flag.BoolP("infer-directions", "", false, "infer missing direction_ids of trips from the stop order or terminal bearing of their stop patterns, decisions are written to directions.csv in --report-dir")
+	directionsMinConf := flag.Float64P("directions-min-confidence", "", 0.5, "min confidence (between 0 and 1) for a direction inferred by --infer-directions, less confident trips are left untouched")
 	ensureParents := flag.BoolP("ensure-stop-parents", "", false, "ensure that every stop (location_type=0) has a parent station")
+	generateTransfers := flag.BoolP("generate-transfers", "", false, "generate transfers (transfer_type=2) between served stops sharing a parent station or within walking distance, existing transfers are kept")
+	transfersMaxDist := flag.Float64P("transfers-max-dist", "", 200, "max walking distance (in meters) for --generate-transfers")
+	transfersWalkSpeed := flag.Float64P("transfers-walk-speed", "", 1.0, "walking speed (in m/s) used to compute min_transfer_time in --generate-transfers")
+	transfersMaxPerStop := flag.IntP("transfers-max-per-stop", "", 10, "max number of generated transfers from a single stop in --generate-transfers (0 = unlimited)")
+	transfersSkipSameRoutes := flag.BoolP("transfers-skip-same-routes", "", false, "in --generate-transfers, skip transfers between stops served by exactly the same routes")
+	inferBlocks := flag.BoolP("infer-blocks", "", false, "chain trips without a block_id into vehicle blocks, using the minimum number of vehicles per agency and service (only trips with the same service_id are chained, use -C to merge equivalent services)")
+	blocksMinLayover := flag.IntP("blocks-min-layover", "", 0, "min layover (in seconds) between two trips of a block in --infer-blocks")
+	blocksMaxLayover := flag.IntP("blocks-max-layover", "", 1800, "max layover (in seconds) between two trips of a block in --infer-blocks")
+	blocksMaxDist := flag.Float64P("blocks-max-dist", "", 100, "max distance (in meters) between the last stop of a trip and the first stop of its successor in --infer-blocks")
+	validateBlocks := flag.BoolP("validate-blocks", "", false, "check that trips of a block don't overlap on any date, that deadheads between them are possible, and that they share agency and route type, problems are written to blocks.csv in --report-dir")
+	clearBadBlocks := flag.BoolP("clear-bad-blocks", "", false, "like --validate-blocks, but also remove the block IDs of blocks with problems")
+	blocksMaxDeadheadSpeed := flag.Float64P("blocks-max-deadhead-speed", "", 25, "max straight-line speed (in m/s) of a vehicle between two trips of a block in --validate-blocks")
+	validatePathways := flag.BoolP("validate-pathways", "", false, "check that platforms of stations with pathways are reachable from and to an entrance, and that pathway directions, traversal times and levels are plausible, problems are written to pathways.csv in --report-dir")
+	groupParents := flag.BoolP("group-stop-parents", "", false, "like --ensure-stop-parents, but nearby parentless stops with similar names share a parent (uses --recluster-stops-dist, --recluster-stops-simi and the name similarity settings of -E)")
 	keepColOrder := flag.BoolP("keep-col-order", "", false, "keep the original column ordering of the input feed")
 	keepFields := flag.BoolP("keep-additional-fields", "F", false, "keep all non-GTFS fields from the input")
 	dropTooFast := flag.BoolP("drop-too-fast-trips", "", false, "drop trips that are too fast to realistically occur")
@@ -186,6 +241,10 @@ func main() {
 	useStopReclusterer := flag.BoolP("recluster-stops", "E", false, "recluster stops")
 	stopReclusterDistance := flag.Float64P("recluster-stops-dist", "", 75.0, "distance threshold for stop reclustering with -E")
 	stopReclusterSimiThreshold := flag.Float64P("recluster-stops-simi", "", 0.55, "similarity threshold for stop reclustering with -E")
+	stopReclusterLangs := flag.StringSliceP("recluster-stops-lang", "", []string{}, "comma-separated languages whose abbreviation, synonym and stop word tables are used for the name similarity in -E, supported are de,en,fr")
+	stopReclusterSimiFile := flag.StringP("recluster-stops-simi-file", "", "", "file with additional name similarity rules for -E (synonym,<canonical>,<variants...> / stopword,<words...>)")
+	stopReclusterJaroWinkler := flag.Float64P("recluster-stops-jw", "", 0, "weight (0 to 1) of the Jaro-Winkler similarity blended with the TF-IDF name similarity in -E")
+	stopReclusterFold := flag.BoolP("recluster-stops-fold-diacritics", "", false, "fold letters with diacritics to their base letters (e.g. É to E) for the name similarity in -E")
 	useStopAverager := flag.BoolP("fix-far-away-parents", "", false, "try to fix too far away parent stations by averaging their position to childrens")
 	dropShapes := flag.BoolP("drop-shapes", "", false, "drop shapes")
 	polygonFilterCompleteTrips := flag.BoolP("complete-filtered-trips", "", false, "always include complete data for trips filtered e.g. using a geo filter")
@@ -323,6 +382,9 @@ func main() {
 		if len(*orphanDeleters) == 0 {
 			*orphanDeleters = []string{"all"}
 		}
+		if len(*elideFields) == 0 {
+			*elideFields = []string{"all"}
+		}
 		*useShapeMinimizer = true
 		*useRedShapeRemover = true
 		*useRedRouteMinimizer = true
@@ -339,6 +401,164 @@ func main() {
 		os.Exit(1)
 	}
 
+	fe, err := processors.MakeFieldElider(*elideFields)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing field list: %s\n", err)
+		os.Exit(1)
+	}
+
+	var holidays processors.Holidays
+
+	if len(*holidaysFile) > 0 {
+		holidays, err = processors.ReadHolidays(*holidaysFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse holiday file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	if len(*reportDir) > 0 {
+		if err := os.MkdirAll(*reportDir, os.ModePerm); err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not create report directory: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	// get the path of a report file, empty if no reports were requested
+	reportFile := func(name string) string {
+		if len(*reportDir) == 0 {
+			return ""
+		}
+		return path.Join(*reportDir, name)
+	}
+
+	var stopOverrides *processors.StopOverrides
+	if len(*stopOverridesFile) > 0 {
+		stopOverrides, err = processors.ReadStopOverrides(*stopOverridesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse stop overrides file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	var feedPublisher *gtfs.FeedInfo
+	if len(*feedPublisherFile) > 0 {
+		feedPublisher, err = processors.ReadFeedInfoPublisher(*feedPublisherFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse feed publisher file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+		*updateFeedInfo = true
+	}
+
+	if len(*feedVersion) > 0 || *createFeedInfo {
+		*updateFeedInfo = true
+	}
+
+	if *groupParents {
+		*ensureParents = true
+	}
+
+	if *headsignsVia || *fillStopHeadsigns || *elideStopHeadsigns {
+		*ensureTripHeadsigns = true
+	}
+
+	if *nameRulesDryRun && len(*reportDir) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: --normalize-names-dry-run requires --report-dir\n")
+		os.Exit(1)
+	}
+
+	var nameRules *processors.NameRules
+	if len(*nameRulesFile) > 0 {
+		nameRules, err = processors.ReadNameRules(*nameRulesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse name normalization rules: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	if *assignRouteColors {
+		*fixRouteColors = true
+	}
+
+	var routePalette []string
+	if len(*routePaletteFile) > 0 {
+		routePalette, err = processors.ReadRoutePalette(*routePaletteFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse route palette file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	var nameSimi *processors.NameSimiConfig
+	if len(*stopReclusterLangs) > 0 || len(*stopReclusterSimiFile) > 0 || *stopReclusterJaroWinkler > 0 || *stopReclusterFold {
+		nameSimi, err = processors.MakeNameSimiConfig(*stopReclusterLangs, *stopReclusterFold)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		if len(*stopReclusterSimiFile) > 0 {
+			if err := nameSimi.ReadFile(*stopReclusterSimiFile); err != nil {
+				fmt.Fprintf(os.Stderr, "\nCould not parse name similarity file: ")
+				fmt.Fprintf(os.Stderr, err.Error()+".\n")
+				os.Exit(1)
+			}
+		}
+
+		nameSimi.JaroWinklerWeight = math.Max(0, math.Min(1, *stopReclusterJaroWinkler))
+	}
+
+	var stopMergeReview *processors.StopMergeReview
+	if *reviewStopMerges {
+		if len(*reportDir) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --review-stop-merges requires --report-dir\n")
+			os.Exit(1)
+		}
+		stopMergeReview = processors.NewStopMergeReview(*stopMergeReviewMargin)
+	}
+
+	if len(*osmShapesFile) > 0 {
+		if _, err := os.Stat(*osmShapesFile); err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not read OSM file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
+	if *useShapeMinimizerTopo {
+		*useShapeMinimizer = true
+	}
+
+	shapeMinAlgo := processors.DouglasPeucker
+	switch *shapeMinimizerAlgo {
+	case "dp":
+		shapeMinAlgo = processors.DouglasPeucker
+	case "vw":
+		shapeMinAlgo = processors.VisvalingamWhyatt
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown shape minimization algorithm '%s'\n", *shapeMinimizerAlgo)
+		os.Exit(1)
+	}
+
+	var namer processors.ServiceNamer
+	if *nameServices {
+		namer, err = processors.MakeServiceNamer(*serviceNameLocale, holidays)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		// descriptive service IDs must not be minimized
+		*keepServiceIds = true
+	}
+
 	for _, polyFile := range polygonFiles {
 		if strings.HasSuffix(polyFile, ".json") || strings.HasSuffix(polyFile, ".geojson") {
 			json, err := ioutil.ReadFile(polyFile)
@@ -551,6 +771,13 @@ func main() {
 		fmt.Fprintln(os.Stdout, "\nYou may want to try running gtfstidy with --fix for error fixing / skipping. See --help for details.")
 		os.Exit(1)
 	} else {
+		if stopOverrides != nil {
+			// the rules refer to the stop IDs without the parse prefixes
+			for prefix := range prefixes {
+				stopOverrides.Prefixes = append(stopOverrides.Prefixes, prefix)
+			}
+		}
+
 		minzers := make([]processors.Processor, 0)
 
 		if *dropTooFast {
@@ -565,6 +792,10 @@ func main() {
 			minzers = append(minzers, or)
 		}
 
+		if nameRules != nil {
+			minzers = append(minzers, processors.NameNormalizer{Rules: nameRules, DryRun: *nameRulesDryRun, ReportFile: reportFile("names.csv")})
+		}
+
 		if *useRedAgencyMinimizer {
 			minzers = append(minzers, processors.AgencyDuplicateRemover{})
 		}
@@ -581,6 +812,8 @@ func main() {
 				DistThresholdStation: 50,
 				Fuzzy:                *useRedStopsMinimizerFuzzy,
 				KeepIFOPT:            *keepStationIFTOPTIds,
+				Review:               stopMergeReview,
+				Overrides:            stopOverrides,
 			})
 		}
 
@@ -589,6 +822,9 @@ func main() {
 				DistThreshold:     *stopReclusterDistance,
 				NameSimiThreshold: *stopReclusterSimiThreshold,
 				GridCellSize:      10000,
+				Review:            stopMergeReview,
+				Overrides:         stopOverrides,
+				NameSimi:          nameSimi,
 			})
 		}
 
@@ -601,15 +837,33 @@ func main() {
 				DistThresholdStation: 50,
 				Fuzzy:                *useRedStopsMinimizerFuzzy,
 				KeepIFOPT:            *keepStationIFTOPTIds,
+				Review:               stopMergeReview,
+				Overrides:            stopOverrides,
 			})
 		}
 
-		if *useShapeRemeasurer || *useShapeMinimizer || *useRedShapeRemover || *useStopTimeRemeasurer {
+		if len(*osmShapesFile) > 0 {
+			minzers = append(minzers, processors.ShapeGenerator{OsmFile: *osmShapesFile, MaxSnapDist: 100})
+		}
+
+		if *useStraightShapes {
+			minzers = append(minzers, processors.StraightShapeGenerator{MaxSegLength: *straightShapesMaxSegLen})
+		}
+
+		if *useShapeDirectionFixer {
+			minzers = append(minzers, processors.ShapeDirectionFixer{MaxDist: 100, ReportFile: reportFile("shape_directions.csv")})
+		}
+
+		if *useShapeRemeasurer || *useShapeMinimizer || *useRedShapeRemover || *useStopTimeRemeasurer || *useShapeContainmentRemover || *useShapeCleaner {
 			minzers = append(minzers, processors.ShapeRemeasurer{Force: *useStopTimeRemeasurer})
 		}
 
+		if *useShapeCleaner {
+			minzers = append(minzers, processors.ShapeCleaner{MaxTurnAngle: *shapeCleanerMaxTurnAngle, MaxDetourFactor: *shapeCleanerMaxDetour, ProtectDist: *shapeCleanerProtectDist, ReportFile: reportFile("cleaned_shapes.csv")})
+		}
+
 		if *useShapeMinimizer {
-			minzers = append(minzers, processors.ShapeMinimizer{Epsilon: 1.0})
+			minzers = append(minzers, processors.ShapeMinimizer{Epsilon: 1.0, Algorithm: shapeMinAlgo, PreserveTopology: *useShapeMinimizerTopo, MaxSnapDist: *shapeMinTopoDist})
 		}
 
 		if *useStopTimeRemeasurer {
@@ -624,6 +878,8 @@ func main() {
 					DistThresholdStation: 50,
 					Fuzzy:                *useRedStopsMinimizerFuzzy,
 					KeepIFOPT:            *keepStationIFTOPTIds,
+					Review:               stopMergeReview,
+					Overrides:            stopOverrides,
 				})
 			}
 
@@ -637,6 +893,10 @@ func main() {
 			minzers = append(minzers, processors.ShapeDuplicateRemover{MaxEqDist: 1.0})
 		}
 
+		if *useShapeContainmentRemover {
+			minzers = append(minzers, processors.ShapeContainmentRemover{MaxEqDist: 1.0})
+		}
+
 		if *useRedRouteMinimizer {
 			minzers = append(minzers, processors.RouteDuplicateRemover{OnlyMergeRoutesSharingStop: *useRedRouteMinimizerSharedStops})
 		}
@@ -650,14 +910,18 @@ func main() {
 		}
 
 		if *ensureTripHeadsigns {
-			minzers = append(minzers, processors.TripHeadsigner{})
+			minzers = append(minzers, processors.TripHeadsigner{Via: *headsignsVia, StopHeadsigns: *fillStopHeadsigns, ElideStopHeadsigns: *elideStopHeadsigns})
+		}
+
+		if *inferDirections {
+			minzers = append(minzers, processors.DirectionInferrer{MinConfidence: *directionsMinConf, ReportFile: reportFile("directions.csv")})
 		}
 
 		if *useRedTripMinimizer {
 			// to convert calendar_dates based services into regular calendar.txt services
 			// before concatenating equivalent trips
 			if *useServiceMinimizer {
-				minzers = append(minzers, processors.ServiceMinimizer{})
+				minzers = append(minzers, processors.ServiceMinimizer{Holidays: holidays})
 			}
 
 			minzers = append(minzers, processors.TripDuplicateRemover{Fuzzy: *useRedTripMinimizerFuzzyRoute, Aggressive: *redTripMinimizerAggressive, MaxDayDist: 7})
@@ -674,11 +938,11 @@ func main() {
 		}
 
 		if *nonOverlappingServices {
-			minzers = append(minzers, processors.ServiceNonOverlapper{DayNames: []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}, YearWeekName: "WW"})
+			minzers = append(minzers, processors.ServiceNonOverlapper{DayNames: []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}, YearWeekName: "WW", Holidays: holidays, HolidayName: "Holiday"})
 		}
 
 		if *useServiceMinimizer {
-			minzers = append(minzers, processors.ServiceMinimizer{})
+			minzers = append(minzers, processors.ServiceMinimizer{Holidays: holidays})
 		}
 
 		if *useFrequencyMinimizer {
@@ -689,8 +953,51 @@ func main() {
 			minzers = append(minzers, processors.ServiceCalDatesRem{})
 		}
 
+		if *inferBlocks {
+			minzers = append(minzers, processors.BlockInferrer{MinLayover: *blocksMinLayover, MaxLayover: *blocksMaxLayover, MaxDist: *blocksMaxDist})
+		}
+
+		if *validateBlocks || *clearBadBlocks {
+			minzers = append(minzers, processors.BlockValidator{MaxDeadheadSpeed: *blocksMaxDeadheadSpeed, Clear: *clearBadBlocks, ReportFile: reportFile("blocks.csv")})
+		}
+
 		if *ensureParents {
-			minzers = append(minzers, processors.StopParentEnforcer{})
+			minzers = append(minzers, processors.StopParentEnforcer{
+				Overrides:         stopOverrides,
+				Group:             *groupParents,
+				DistThreshold:     *stopReclusterDistance,
+				NameSimiThreshold: *stopReclusterSimiThreshold,
+				NameSimi:          nameSimi,
+			})
+		}
+
+		if *generateTransfers {
+			minzers = append(minzers, processors.TransferGenerator{MaxWalkDist: *transfersMaxDist, WalkSpeed: *transfersWalkSpeed, MaxPerStop: *transfersMaxPerStop, SkipSameRoutes: *transfersSkipSameRoutes})
+		}
+
+		if *fixRouteColors {
+			minzers = append(minzers, processors.RouteColorFixer{MinContrast: *routeMinContrast, AssignColors: *assignRouteColors, Palette: routePalette})
+		}
+
+		if *validatePathways {
+			minzers = append(minzers, processors.PathwayValidator{MinSpeed: 0.1, MaxSpeed: 5, ReportFile: reportFile("pathways.csv")})
+		}
+
+		if fe.Enabled {
+			minzers = append(minzers, fe)
+		}
+
+		if *nameServices {
+			minzers = append(minzers, namer)
+		}
+
+		if *minimizeFareRules {
+			base := 10
+			if !*useIDMinimizerNum && *useIDMinimizerChar {
+				base = 36
+			}
+			minIds := (*useIDMinimizerNum || *useIDMinimizerChar) && !*keepFareIds
+			minzers = append(minzers, processors.FareRuleMinimizer{MinimizeZoneIds: minIds, Prefix: *idPrefix, Base: base})
 		}
 
 		if *useIDMinimizerNum {
@@ -704,6 +1011,17 @@ func main() {
 			m.Run(feed)
 		}
 
+		if stopMergeReview != nil {
+			fmt.Fprintf(os.Stdout, "Writing stop merge review... ")
+			err := stopMergeReview.Write(reportFile("stop_merges.geojson"), reportFile("stop_merges.csv"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "\nCould not write stop merge review: ")
+				fmt.Fprintf(os.Stderr, err.Error()+".\n")
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stdout, "done. (%d merges, %d flagged for review)\n", stopMergeReview.Len(), stopMergeReview.NumFlagged())
+		}
+
 		// restore stop IDs, if requested
 		if *keepStationIds && len(prefixes) > 0 {
 			for id, s := range feed.Stops {
@@ -963,6 +1281,32 @@ func main() {
 			}
 		}
 
+		// update the feed info last, so that the version hash covers the
+		// restored IDs
+		if *updateFeedInfo {
+			processors.FeedInfoUpdater{Publisher: feedPublisher, Version: *feedVersion, Create: *createFeedInfo}.Run(feed)
+		}
+
+		if len(*geojsonOut) > 0 {
+			fmt.Fprintf(os.Stdout, "Outputting GeoJSON to '%s'...", *geojsonOut)
+
+			exp := processors.GeoJSONExporter{Routes: make(map[string]bool), Agencies: make(map[string]bool)}
+			for _, id := range *geojsonRoutes {
+				exp.Routes[id] = true
+			}
+			for _, id := range *geojsonAgencies {
+				exp.Agencies[id] = true
+			}
+
+			if err := exp.Write(feed, *geojsonOut); err != nil {
+				fmt.Fprintf(os.Stderr, "\nError while writing GeoJSON to '%s':\n ", *geojsonOut)
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Fprintf(os.Stdout, " done.\n")
+		}
+
 		fmt.Fprintf(os.Stdout, "Outputting GTFS feed to '%s'...", *outputPath)
 
 		if _, err := os.Stat(*outputPath); os.IsNotExist(err) {
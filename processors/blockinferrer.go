@@ -0,0 +1,270 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// BlockInferrer chains trips into vehicle blocks. A trip may be followed by
+// another trip of the same agency and service if it starts at the same stop,
+// a stop with the same parent, or a stop at most MaxDist meters away from
+// where the first trip ends, between MinLayover and MaxLayover seconds after
+// its arrival. Among all possible chainings, one with the minimum number of
+// vehicles is chosen. Trips which already have a block_id, frequency-based
+// trips and trips whose chain would consist only of themselves are left
+// untouched. Trips are only chained if they use the very same service, not
+// just an equivalent one, so duplicate services should be removed before.
+type BlockInferrer struct {
+	MinLayover int
+	MaxLayover int
+	MaxDist    float64
+}
+
+type blockTrip struct {
+	trip       *gtfs.Trip
+	start, end int
+}
+
+// Run this BlockInferrer on some feed
+func (bi BlockInferrer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Inferring vehicle blocks... ")
+
+	type groupKey struct {
+		agency  *gtfs.Agency
+		service *gtfs.Service
+	}
+
+	groups := make(map[groupKey][]blockTrip)
+	existing := make(map[string]bool)
+
+	for _, t := range feed.Trips {
+		if t.Block_id != nil && len(*t.Block_id) > 0 {
+			existing[*t.Block_id] = true
+			continue
+		}
+
+		if (t.Frequencies != nil && len(*t.Frequencies) > 0) || len(t.StopTimes) < 2 {
+			continue
+		}
+
+		dep := t.StopTimes[0].Departure_time()
+		arr := t.StopTimes[len(t.StopTimes)-1].Arrival_time()
+
+		if dep.Empty() || arr.Empty() {
+			continue
+		}
+
+		k := groupKey{t.Route.Agency, t.Service}
+		groups[k] = append(groups[k], blockTrip{t, dep.SecondsSinceMidnight(), arr.SecondsSinceMidnight()})
+	}
+
+	// process the groups in a stable order, for deterministic block IDs
+	keys := make([]groupKey, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].agency != keys[j].agency {
+			if keys[i].agency == nil || keys[j].agency == nil {
+				return keys[i].agency == nil
+			}
+			return keys[i].agency.Id < keys[j].agency.Id
+		}
+		return keys[i].service.Id() < keys[j].service.Id()
+	})
+
+	nBlocks := 0
+	nTrips := 0
+
+	for _, k := range keys {
+		for _, chain := range bi.chainTrips(groups[k]) {
+			if len(chain) < 2 {
+				continue
+			}
+
+			id := freeBlockId(chain[0].Id, existing)
+			existing[id] = true
+
+			for _, t := range chain {
+				bid := id
+				t.Block_id = &bid
+			}
+
+			nBlocks++
+			nTrips += len(chain)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d blocks covering %d trips)\n", nBlocks, nTrips)
+}
+
+// Get a block ID based on id which is not in existing
+func freeBlockId(id string, existing map[string]bool) string {
+	for try := 0; ; try++ {
+		ret := "blk::" + id
+		if try > 0 {
+			ret = "blk" + strconv.Itoa(try) + "::" + id
+		}
+		if !existing[ret] {
+			return ret
+		}
+	}
+}
+
+// Chain the trips of a single agency and service into a minimum number of
+// vehicle chains. This is a minimum path cover in the (acyclic) graph of
+// possible connections, which is given by a maximum bipartite matching.
+func (bi BlockInferrer) chainTrips(trips []blockTrip) [][]*gtfs.Trip {
+	sort.Slice(trips, func(i, j int) bool {
+		if trips[i].start != trips[j].start {
+			return trips[i].start < trips[j].start
+		}
+		if trips[i].end != trips[j].end {
+			return trips[i].end < trips[j].end
+		}
+		return trips[i].trip.Id < trips[j].trip.Id
+	})
+
+	// possible successors of each trip, shortest layover first. Successors
+	// always come later in the order above, otherwise zero-duration trips
+	// at the same time could follow each other in a cycle.
+	adj := make([][]int, len(trips))
+
+	for i, a := range trips {
+		first := sort.Search(len(trips), func(j int) bool {
+			return trips[j].start >= a.end+bi.MinLayover
+		})
+
+		if first <= i {
+			first = i + 1
+		}
+
+		for j := first; j < len(trips) && trips[j].start <= a.end+bi.MaxLayover; j++ {
+			if bi.connects(a.trip, trips[j].trip) {
+				adj[i] = append(adj[i], j)
+			}
+		}
+	}
+
+	next := hopcroftKarp(adj, len(trips))
+
+	hasPrev := make([]bool, len(trips))
+	for _, j := range next {
+		if j > -1 {
+			hasPrev[j] = true
+		}
+	}
+
+	ret := make([][]*gtfs.Trip, 0)
+
+	for i := range trips {
+		if hasPrev[i] {
+			continue
+		}
+
+		chain := make([]*gtfs.Trip, 0, 1)
+		for j := i; j > -1; j = next[j] {
+			chain = append(chain, trips[j].trip)
+		}
+
+		ret = append(ret, chain)
+	}
+
+	return ret
+}
+
+// True if a vehicle ending trip a can start trip b, regarding the location
+func (bi BlockInferrer) connects(a, b *gtfs.Trip) bool {
+	end := a.StopTimes[len(a.StopTimes)-1].Stop()
+	start := b.StopTimes[0].Stop()
+
+	if end == start {
+		return true
+	}
+
+	if end.Parent_station != nil && end.Parent_station == start.Parent_station {
+		return true
+	}
+
+	return stopDist(end, start) <= bi.MaxDist
+}
+
+// Maximum bipartite matching between n left and n right nodes, with edges
+// given by adj. Returns the matched right node for each left node, or -1.
+func hopcroftKarp(adj [][]int, n int) []int {
+	matchL := make([]int, n)
+	matchR := make([]int, n)
+	dist := make([]int, n)
+
+	for i := 0; i < n; i++ {
+		matchL[i] = -1
+		matchR[i] = -1
+	}
+
+	const inf = int(^uint(0) >> 1)
+
+	bfs := func() bool {
+		queue := make([]int, 0, n)
+		for u := 0; u < n; u++ {
+			if matchL[u] == -1 {
+				dist[u] = 0
+				queue = append(queue, u)
+			} else {
+				dist[u] = inf
+			}
+		}
+
+		found := false
+
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			for _, v := range adj[u] {
+				w := matchR[v]
+				if w == -1 {
+					found = true
+				} else if dist[w] == inf {
+					dist[w] = dist[u] + 1
+					queue = append(queue, w)
+				}
+			}
+		}
+
+		return found
+	}
+
+	var dfs func(u int) bool
+	dfs = func(u int) bool {
+		for _, v := range adj[u] {
+			w := matchR[v]
+			if w == -1 || (dist[w] == dist[u]+1 && dfs(w)) {
+				matchL[u] = v
+				matchR[v] = u
+				return true
+			}
+		}
+		dist[u] = inf
+		return false
+	}
+
+	for bfs() {
+		for u := 0; u < n; u++ {
+			if matchL[u] == -1 {
+				dfs(u)
+			}
+		}
+	}
+
+	return matchL
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestBlockInferrer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	x := &gtfs.Stop{Id: "x", Lat: 48, Lon: 7.8}
+	y := &gtfs.Stop{Id: "y", Lat: 48.1, Lon: 7.8}
+	y2 := &gtfs.Stop{Id: "y2", Lat: 48.1, Lon: 7.8005} // ~37 m from y
+	for _, s := range []*gtfs.Stop{x, y, y2} {
+		feed.Stops[s.Id] = s
+	}
+
+	ag := &gtfs.Agency{Id: "A"}
+	r := &gtfs.Route{Id: "r", Agency: ag}
+
+	s1 := gtfs.EmptyService()
+	s1.SetId("s1")
+	s2 := gtfs.EmptyService()
+	s2.SetId("s2")
+
+	addTrip := func(id string, s *gtfs.Service, from *gtfs.Stop, dep int, to *gtfs.Stop, arr int) *gtfs.Trip {
+		trip := addTestTimedTrip(feed, id, from, dep, to, arr)
+		trip.Route = r
+		trip.Service = s
+		return trip
+	}
+
+	// times in minutes since midnight
+	t1 := addTrip("t1", s1, x, 8*60, y, 8*60+30)
+	t2 := addTrip("t2", s1, y2, 8*60+40, x, 9*60+10)
+	t3 := addTrip("t3", s1, x, 9*60+20, y, 9*60+50)
+
+	// may follow t1, but then t2 and t3 would need a second vehicle
+	t4 := addTrip("t4", s1, y, 8*60+35, x, 9*60+15)
+
+	// different service
+	t5 := addTrip("t5", s2, y, 8*60+45, x, 9*60)
+
+	// existing block
+	t6 := addTrip("t6", s1, y, 8*60+31, x, 9*60)
+	blk := "B"
+	t6.Block_id = &blk
+
+	// layover too long
+	t7 := addTrip("t7", s1, y, 12*60, x, 12*60+30)
+
+	BlockInferrer{MinLayover: 0, MaxLayover: 1800, MaxDist: 100}.Run(feed)
+
+	if t6.Block_id == nil || *t6.Block_id != "B" {
+		t.Error("existing block was changed")
+	}
+
+	for _, trip := range []*gtfs.Trip{t5, t7} {
+		if trip.Block_id != nil {
+			t.Errorf("expected no block for %s, got %s", trip.Id, *trip.Block_id)
+		}
+	}
+
+	blocks := make(map[string][]string)
+	for _, trip := range []*gtfs.Trip{t1, t3, t2, t4} {
+		if trip.Block_id == nil {
+			continue
+		}
+		blocks[*trip.Block_id] = append(blocks[*trip.Block_id], trip.Id)
+	}
+
+	// t1 -> t2 -> t3 leaves t4 alone, t1 -> t4 -> t3 leaves t2 alone,
+	// both need 2 vehicles, but only one chain has more than one trip
+	if len(blocks) != 1 {
+		t.Errorf("expected a single block, got %v", blocks)
+	}
+
+	for _, trips := range blocks {
+		if len(trips) != 3 || trips[0] != "t1" || trips[1] != "t3" {
+			t.Errorf("expected block of t1, t3 and either t2 or t4, got %v", trips)
+		}
+	}
+}
+
+func TestBlockInferrerZeroDuration(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	x := &gtfs.Stop{Id: "x", Lat: 48, Lon: 7.8}
+	feed.Stops["x"] = x
+
+	r := &gtfs.Route{Id: "r", Agency: &gtfs.Agency{Id: "A"}}
+
+	s := gtfs.EmptyService()
+	s.SetId("s")
+
+	// two trips at the same stop and time, which could follow each other
+	// in both directions
+	for _, id := range []string{"z1", "z2"} {
+		trip := addTestTimedTrip(feed, id, x, 10*60, x, 10*60)
+		trip.Route = r
+		trip.Service = s
+	}
+
+	BlockInferrer{MinLayover: 0, MaxLayover: 1800, MaxDist: 100}.Run(feed)
+
+	z1, z2 := feed.Trips["z1"], feed.Trips["z2"]
+
+	if z1.Block_id == nil || z2.Block_id == nil || *z1.Block_id != *z2.Block_id {
+		t.Error("expected z1 and z2 in the same block")
+	}
+}
+
+func TestHopcroftKarp(t *testing.T) {
+	// a greedy matching of 0-0 would prevent the perfect matching
+	adj := [][]int{{0, 1}, {0}, {2}}
+	m := hopcroftKarp(adj, 3)
+
+	if m[0] != 1 || m[1] != 0 || m[2] != 2 {
+		t.Errorf("expected perfect matching, got %v", m)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockValidator checks the trips of each block on every date they are
+// active. Trips of a block must not overlap in time, the vehicle must be
+// able to get from the end of a trip to the start of the next one at no more
+// than MaxDeadheadSpeed m/s (straight-line), and all trips of a block must
+// belong to the same agency and use the same route type. Problems are written
+// to ReportFile. If Clear is set, the block IDs of blocks with problems are
+// removed.
+type BlockValidator struct {
+	MaxDeadheadSpeed float64
+	Clear            bool
+	ReportFile       string
+}
+
+// a trip of a block on a single day, with times in seconds since the
+// Unix epoch (ignoring time zones)
+type blockInstance struct {
+	trip       *gtfs.Trip
+	day        int64
+	start, end int64
+}
+
+type blockProblem struct {
+	problem     string
+	trip, other string
+	firstDay    int64
+	numDays     int
+	details     string
+}
+
+// Run this BlockValidator on some feed
+func (bv BlockValidator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Validating blocks... ")
+
+	blocks := make(map[string][]*gtfs.Trip)
+	for _, t := range feed.Trips {
+		if t.Block_id != nil && len(*t.Block_id) > 0 {
+			blocks[*t.Block_id] = append(blocks[*t.Block_id], t)
+		}
+	}
+
+	ids := make([]string, 0, len(blocks))
+	for id := range blocks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	amaps := ServiceDuplicateRemover{}.getActiveMaps(feed)
+
+	report := NewReport("block_id", "problem", "trip_id", "other_trip_id", "first_date", "num_dates", "details")
+	bad := make([]string, 0)
+	cleared := 0
+
+	for _, id := range ids {
+		problems := bv.checkBlock(blocks[id], amaps)
+
+		for _, p := range problems {
+			first := ""
+			num := ""
+			if p.numDays > 0 {
+				first = dateStr(gtfs.GetGtfsDateFromTime(time.Unix(p.firstDay*86400, 0).UTC()))
+				num = strconv.Itoa(p.numDays)
+			}
+			report.Add(id, p.problem, p.trip, p.other, first, num, p.details)
+		}
+
+		if len(problems) == 0 {
+			continue
+		}
+
+		bad = append(bad, id)
+
+		if bv.Clear {
+			for _, t := range blocks[id] {
+				t.Block_id = nil
+				cleared++
+			}
+		}
+	}
+
+	clearedStr := ""
+	if bv.Clear {
+		clearedStr = fmt.Sprintf(", cleared block IDs of %d trips", cleared)
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d of %d blocks with problems%s%s)\n", len(bad), len(blocks), clearedStr, report.writeIfRequested(bv.ReportFile))
+
+	if len(bv.ReportFile) == 0 && len(bad) > 0 {
+		fmt.Fprintf(os.Stdout, "  Blocks with problems: %s\n", strings.Join(bad, ", "))
+	}
+}
+
+// Check a single block, return the problems found
+func (bv BlockValidator) checkBlock(trips []*gtfs.Trip, amaps map[*gtfs.Service]ServiceCompressed) []*blockProblem {
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].Id < trips[j].Id
+	})
+
+	ret := make([]*blockProblem, 0)
+
+	// mixed agencies and route types
+	for _, t := range trips[1:] {
+		if t.Route.Agency != trips[0].Route.Agency {
+			ret = append(ret, &blockProblem{problem: "mixed agencies", trip: trips[0].Id, other: t.Id, details: agencyId(trips[0].Route.Agency) + " vs. " + agencyId(t.Route.Agency)})
+		}
+		if t.Route.Type != trips[0].Route.Type {
+			ret = append(ret, &blockProblem{problem: "mixed route types", trip: trips[0].Id, other: t.Id, details: fmt.Sprintf("%d vs. %d", trips[0].Route.Type, t.Route.Type)})
+		}
+	}
+
+	// expand the trips to all dates they are active
+	insts := make([]blockInstance, 0)
+
+	for _, t := range trips {
+		if len(t.StopTimes) < 2 || (t.Frequencies != nil && len(*t.Frequencies) > 0) {
+			continue
+		}
+
+		dep := t.StopTimes[0].Departure_time()
+		arr := t.StopTimes[len(t.StopTimes)-1].Arrival_time()
+
+		if dep.Empty() || arr.Empty() {
+			continue
+		}
+
+		amap, ok := amaps[t.Service]
+		if !ok {
+			continue
+		}
+
+		startDay := amap.start.GetTime().Unix() / 86400
+
+		for i, active := range amap.activeMap {
+			if !active {
+				continue
+			}
+			day := startDay + int64(i)
+			insts = append(insts, blockInstance{t, day, day*86400 + int64(dep.SecondsSinceMidnight()), day*86400 + int64(arr.SecondsSinceMidnight())})
+		}
+	}
+
+	sort.Slice(insts, func(i, j int) bool {
+		if insts[i].start != insts[j].start {
+			return insts[i].start < insts[j].start
+		}
+		return insts[i].trip.Id < insts[j].trip.Id
+	})
+
+	// problems between trip pairs, aggregated over all dates
+	pairs := make(map[[3]string]*blockProblem)
+
+	add := func(problem string, a, b blockInstance, details string) {
+		k := [3]string{problem, a.trip.Id, b.trip.Id}
+		if p, ok := pairs[k]; ok {
+			p.numDays++
+			return
+		}
+		p := &blockProblem{problem: problem, trip: a.trip.Id, other: b.trip.Id, firstDay: a.day, numDays: 1, details: details}
+		pairs[k] = p
+		ret = append(ret, p)
+	}
+
+	// the instance with the latest end so far, to also catch trips
+	// overlapping a trip which is not their direct predecessor
+	last := -1
+
+	for i := range insts {
+		if last == -1 {
+			last = i
+			continue
+		}
+
+		a, b := insts[last], insts[i]
+
+		if b.start < a.end {
+			add("overlapping trips", a, b, fmt.Sprintf("overlap of %d s", a.end-b.start))
+		} else if !bv.canDeadhead(a.trip, b.trip, b.start-a.end) {
+			d := stopDist(a.trip.StopTimes[len(a.trip.StopTimes)-1].Stop(), b.trip.StopTimes[0].Stop())
+			add("impossible deadhead", a, b, fmt.Sprintf("%.0f m in %d s", d, b.start-a.end))
+		}
+
+		if b.end > a.end {
+			last = i
+		}
+	}
+
+	return ret
+}
+
+// True if a vehicle can get from the end of trip a to the start of trip b
+// in layover seconds
+func (bv BlockValidator) canDeadhead(a, b *gtfs.Trip, layover int64) bool {
+	end := a.StopTimes[len(a.StopTimes)-1].Stop()
+	start := b.StopTimes[0].Stop()
+
+	if end == start || (end.Parent_station != nil && end.Parent_station == start.Parent_station) {
+		return true
+	}
+
+	return stopDist(end, start) <= bv.MaxDeadheadSpeed*float64(layover)
+}
+
+func agencyId(a *gtfs.Agency) string {
+	if a == nil {
+		return ""
+	}
+	return a.Id
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBlockValidator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	x := &gtfs.Stop{Id: "x", Lat: 48, Lon: 7.8}
+	y := &gtfs.Stop{Id: "y", Lat: 48.1, Lon: 7.8}
+	z := &gtfs.Stop{Id: "z", Lat: 49, Lon: 7.8} // ~110 km from x
+	for _, s := range []*gtfs.Stop{x, y, z} {
+		feed.Stops[s.Id] = s
+	}
+
+	ag := &gtfs.Agency{Id: "A"}
+	bus := &gtfs.Route{Id: "bus", Agency: ag, Type: 3}
+	tram := &gtfs.Route{Id: "tram", Agency: ag, Type: 0}
+
+	// weekdays of a single week
+	wd := gtfs.EmptyService()
+	wd.SetId("wd")
+	wd.SetRawDaymap(0x3E)
+	wd.SetStart_date(gtfs.NewDate(5, 1, 2026))
+	wd.SetEnd_date(gtfs.NewDate(11, 1, 2026))
+	feed.Services["wd"] = wd
+
+	// sundays before and after
+	su := gtfs.EmptyService()
+	su.SetId("su")
+	su.SetRawDaymap(0x01)
+	su.SetStart_date(gtfs.NewDate(4, 1, 2026))
+	su.SetEnd_date(gtfs.NewDate(11, 1, 2026))
+	feed.Services["su"] = su
+
+	addTrip := func(id, block string, r *gtfs.Route, s *gtfs.Service, from *gtfs.Stop, dep int, to *gtfs.Stop, arr int) *gtfs.Trip {
+		trip := addTestTimedTrip(feed, id, from, dep, to, arr)
+		trip.Route = r
+		trip.Service = s
+		trip.Block_id = &block
+		return trip
+	}
+
+	// overlapping, then a deadhead of 110 km in 5 minutes
+	b1 := addTrip("b1", "B", bus, wd, x, 8*60, y, 9*60)
+	addTrip("b2", "B", bus, wd, y, 8*60+50, x, 9*60+30)
+	addTrip("b3", "B", bus, wd, z, 9*60+35, x, 11*60)
+
+	// fine, the overlapping trips run on different days
+	c1 := addTrip("c1", "C", bus, wd, x, 8*60, y, 9*60)
+	addTrip("c2", "C", bus, su, y, 8*60+30, x, 9*60)
+	addTrip("c3", "C", bus, wd, y, 9*60+10, x, 10*60)
+
+	// a trip after midnight overlapping a trip on the next morning
+	addTrip("n1", "N", bus, su, x, 23*60, y, 25*60)
+	addTrip("n2", "N", bus, wd, y, 0*60+30, x, 1*60+30)
+
+	// mixed route types
+	d1 := addTrip("d1", "D", bus, wd, x, 8*60, y, 9*60)
+	addTrip("d2", "D", tram, wd, y, 9*60+10, x, 10*60)
+
+	dir, err := os.MkdirTemp("", "blockvalidator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bv := BlockValidator{MaxDeadheadSpeed: 25, Clear: true, ReportFile: path.Join(dir, "blocks.csv")}
+	bv.Run(feed)
+
+	content, err := os.ReadFile(bv.ReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := string(content)
+
+	expected := []string{
+		"B,overlapping trips,b1,b2,20260105,5,overlap of 600 s",
+		"B,impossible deadhead,b2,b3,20260105,5",
+		"D,mixed route types,d1,d2",
+		"N,overlapping trips,n1,n2,20260104,1,overlap of 1800 s",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(report, e) {
+			t.Errorf("expected '%s' in report:\n%s", e, report)
+		}
+	}
+
+	if strings.Contains(report, "\nC,") {
+		t.Errorf("did not expect problems for block C:\n%s", report)
+	}
+
+	if b1.Block_id != nil || d1.Block_id != nil {
+		t.Error("expected block IDs of bad blocks to be cleared")
+	}
+
+	if c1.Block_id == nil || *c1.Block_id != "C" {
+		t.Error("expected block C to be kept")
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// DirectionInferrer sets the direction_id of trips which don't have one.
+// Per route, the stop patterns of the trips are compared to the patterns
+// with a known direction, or, if there are none, to the most frequent
+// pattern, which gets direction 0. Patterns are compared by the order of
+// their shared stops, else by the bearing between their terminals. Loops
+// are compared by their orientation (clockwise or counter-clockwise).
+// Patterns for which the confidence of the decision is below MinConfidence
+// are left untouched. The decisions are written to ReportFile.
+type DirectionInferrer struct {
+	MinConfidence float64
+	ReportFile    string
+}
+
+type dirPattern struct {
+	trips   []*gtfs.Trip
+	missing []*gtfs.Trip
+	stops   []*gtfs.Stop
+	dir     int8
+}
+
+// Run this DirectionInferrer on some feed
+func (di DirectionInferrer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Inferring trip directions... ")
+
+	report := NewReport("route_id", "trip_id", "num_trips", "from_stop_id", "to_stop_id", "direction_id", "method", "confidence")
+
+	routeTrips := make(map[*gtfs.Route][]*gtfs.Trip)
+	for _, t := range feed.Trips {
+		if len(t.StopTimes) > 1 {
+			routeTrips[t.Route] = append(routeTrips[t.Route], t)
+		}
+	}
+
+	set := 0
+	skipped := 0
+
+	for r, trips := range routeTrips {
+		s, sk := di.inferRoute(r, trips, report)
+		set += s
+		skipped += sk
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d trips got a direction, %d ambiguous trips skipped%s)\n", set, skipped, report.writeIfRequested(di.ReportFile))
+}
+
+// Infer the missing directions of the trips of a single route
+func (di DirectionInferrer) inferRoute(r *gtfs.Route, trips []*gtfs.Trip, report *Report) (int, int) {
+	patterns := make(map[string]*dirPattern)
+	keys := make([]string, 0)
+	missing := 0
+
+	for _, t := range trips {
+		key := stopPatternKey(t)
+		p, ok := patterns[key]
+		if !ok {
+			p = &dirPattern{dir: -1}
+			for i := range t.StopTimes {
+				p.stops = append(p.stops, t.StopTimes[i].Stop())
+			}
+			patterns[key] = p
+			keys = append(keys, key)
+		}
+		p.trips = append(p.trips, t)
+		if t.Direction_id == -1 {
+			p.missing = append(p.missing, t)
+			missing++
+		}
+	}
+
+	if missing == 0 {
+		return 0, 0
+	}
+
+	// most frequent patterns first
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := patterns[keys[i]], patterns[keys[j]]
+		if len(pi.trips) != len(pj.trips) {
+			return len(pi.trips) > len(pj.trips)
+		}
+		return keys[i] < keys[j]
+	})
+
+	// patterns with a known direction are the anchors
+	anchors := make([]*dirPattern, 0)
+
+	for _, k := range keys {
+		p := patterns[k]
+		cnt := [2]int{}
+		for _, t := range p.trips {
+			if t.Direction_id == 0 || t.Direction_id == 1 {
+				cnt[t.Direction_id]++
+			}
+		}
+		if cnt[0]+cnt[1] == 0 {
+			continue
+		}
+		p.dir = 0
+		if cnt[1] > cnt[0] {
+			p.dir = 1
+		}
+		anchors = append(anchors, p)
+	}
+
+	// without known directions, the most frequent pattern is the reference
+	noAnchors := len(anchors) == 0
+	if noAnchors {
+		patterns[keys[0]].dir = 0
+		anchors = append(anchors, patterns[keys[0]])
+	}
+
+	set := 0
+	skipped := 0
+
+	for _, k := range keys {
+		p := patterns[k]
+		if len(p.missing) == 0 {
+			continue
+		}
+
+		if p.dir != -1 {
+			if noAnchors {
+				di.assign(r, p, 1, "reference", report)
+			} else {
+				di.assign(r, p, 1, "same pattern", report)
+			}
+			set += len(p.missing)
+			continue
+		}
+
+		score := 0.0
+		weight := 0.0
+		method := ""
+
+		for _, a := range anchors {
+			s, m := di.compare(p, a)
+			if len(method) == 0 && len(m) > 0 {
+				method = m
+			}
+			if a.dir == 1 {
+				s = -s
+			}
+			w := float64(len(a.trips))
+			score += s * w
+			weight += w
+		}
+
+		conf := math.Abs(score) / weight
+
+		if conf < di.MinConfidence || conf == 0 {
+			if len(method) == 0 {
+				method = "ambiguous"
+			}
+			report.Add(r.Id, p.missing[0].Id, strconv.Itoa(len(p.missing)), p.stops[0].Id, p.stops[len(p.stops)-1].Id, "", method, strconv.FormatFloat(conf, 'f', 2, 64))
+			skipped += len(p.missing)
+			continue
+		}
+
+		p.dir = 0
+		if score < 0 {
+			p.dir = 1
+		}
+
+		di.assign(r, p, conf, method, report)
+		set += len(p.missing)
+	}
+
+	return set, skipped
+}
+
+// Assign the direction of pattern p to its trips without a direction
+func (di DirectionInferrer) assign(r *gtfs.Route, p *dirPattern, conf float64, method string, report *Report) {
+	sort.Slice(p.missing, func(i, j int) bool {
+		return p.missing[i].Id < p.missing[j].Id
+	})
+
+	for _, t := range p.missing {
+		t.Direction_id = p.dir
+	}
+
+	report.Add(r.Id, p.missing[0].Id, strconv.Itoa(len(p.missing)), p.stops[0].Id, p.stops[len(p.stops)-1].Id, strconv.Itoa(int(p.dir)), method, strconv.FormatFloat(conf, 'f', 2, 64))
+}
+
+// Compare pattern p to pattern a. Returns a score in [-1, 1], positive if
+// both run in the same direction, and the method used.
+func (di DirectionInferrer) compare(p, a *dirPattern) (float64, string) {
+	// order of shared stops
+	pos := make(map[*gtfs.Stop]int)
+	for i, s := range a.stops {
+		if _, ok := pos[s]; !ok {
+			pos[s] = i
+		}
+	}
+
+	inc, dec := 0, 0
+	last := -1
+	for _, s := range p.stops {
+		i, ok := pos[s]
+		if !ok || i == last {
+			continue
+		}
+		if last > -1 {
+			if i > last {
+				inc++
+			} else {
+				dec++
+			}
+		}
+		last = i
+	}
+
+	loopP, loopA := isLoopPattern(p.stops), isLoopPattern(a.stops)
+
+	if loopP && loopA && inc+dec > 1 {
+		// the wrap-around of a loop always counts against its direction
+		if inc > dec && dec > 0 {
+			dec--
+		} else if dec > inc && inc > 0 {
+			inc--
+		}
+	}
+
+	if inc+dec > 0 {
+		return float64(inc-dec) / float64(inc+dec), "stop order"
+	}
+
+	if loopP || loopA {
+		if !loopP || !loopA {
+			return 0, "loop"
+		}
+		oa, op := patternOrientation(a.stops), patternOrientation(p.stops)
+		if oa == 0 || op == 0 {
+			return 0, "loop"
+		}
+		return float64(oa * op), "loop orientation"
+	}
+
+	// bearing between the terminals
+	ax, ay := patternVec(a.stops)
+	px, py := patternVec(p.stops)
+
+	la, lp := math.Hypot(ax, ay), math.Hypot(px, py)
+	if la == 0 || lp == 0 {
+		return 0, ""
+	}
+
+	return (ax*px + ay*py) / (la * lp), "bearing"
+}
+
+// True if a pattern ends (almost) where it starts
+func isLoopPattern(stops []*gtfs.Stop) bool {
+	first, last := stops[0], stops[len(stops)-1]
+	if first == last || (first.Parent_station != nil && first.Parent_station == last.Parent_station) {
+		return true
+	}
+
+	// the terminals are close compared to the extent of the pattern
+	extent := 0.0
+	for _, s := range stops {
+		extent = math.Max(extent, stopDist(first, s))
+	}
+
+	return stopDist(first, last) < 0.2*extent
+}
+
+// Vector from the first to the last stop of a pattern, in web mercator
+func patternVec(stops []*gtfs.Stop) (float64, float64) {
+	latA, lonA := getStopLatLon(stops[0])
+	latB, lonB := getStopLatLon(stops[len(stops)-1])
+	ax, ay := latLngToWebMerc(latA, lonA)
+	bx, by := latLngToWebMerc(latB, lonB)
+	return bx - ax, by - ay
+}
+
+// Orientation of a loop pattern, 1 for counter-clockwise, -1 for clockwise,
+// 0 if undefined
+func patternOrientation(stops []*gtfs.Stop) int {
+	area := 0.0
+	for i := range stops {
+		latA, lonA := getStopLatLon(stops[i])
+		latB, lonB := getStopLatLon(stops[(i+1)%len(stops)])
+		ax, ay := latLngToWebMerc(latA, lonA)
+		bx, by := latLngToWebMerc(latB, lonB)
+		area += ax*by - bx*ay
+	}
+
+	if area > 0 {
+		return 1
+	} else if area < 0 {
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestDirectionInferrer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	stops := map[string]*gtfs.Stop{
+		// a line from west to east
+		"a": {Id: "a", Lat: 48, Lon: 7.80},
+		"b": {Id: "b", Lat: 48, Lon: 7.81},
+		"c": {Id: "c", Lat: 48, Lon: 7.82},
+		"d": {Id: "d", Lat: 48, Lon: 7.83},
+		"e": {Id: "e", Lat: 48.001, Lon: 7.83},
+		"f": {Id: "f", Lat: 48.001, Lon: 7.80},
+		// a square
+		"q1": {Id: "q1", Lat: 49, Lon: 7.80},
+		"q2": {Id: "q2", Lat: 49, Lon: 7.81},
+		"q3": {Id: "q3", Lat: 49.01, Lon: 7.81},
+		"q4": {Id: "q4", Lat: 49.01, Lon: 7.80},
+		// north to south
+		"n": {Id: "n", Lat: 48.01, Lon: 7.9},
+		"s": {Id: "s", Lat: 48.00, Lon: 7.9},
+	}
+	for id, s := range stops {
+		feed.Stops[id] = s
+	}
+
+	addTrip := func(id string, r *gtfs.Route, dir int8, ids ...string) *gtfs.Trip {
+		trip := addTestTrip(feed, id, testStops(feed, ids...)...)
+		trip.Route = r
+		trip.Direction_id = dir
+		return trip
+	}
+
+	// no known directions, the most frequent pattern is the reference
+	r1 := &gtfs.Route{Id: "r1"}
+	t1 := addTrip("t1", r1, -1, "a", "b", "c", "d")
+	t2 := addTrip("t2", r1, -1, "a", "b", "c", "d")
+	t3 := addTrip("t3", r1, -1, "d", "c", "b", "a")
+	t4 := addTrip("t4", r1, -1, "b", "c")
+	t5 := addTrip("t5", r1, -1, "e", "f") // no shared stops, east to west
+
+	// known directions
+	r2 := &gtfs.Route{Id: "r2"}
+	k1 := addTrip("k1", r2, 1, "a", "b", "c", "d")
+	m1 := addTrip("m1", r2, -1, "d", "c", "b")
+
+	// loops
+	r3 := &gtfs.Route{Id: "r3"}
+	l1 := addTrip("l1", r3, -1, "q1", "q2", "q3", "q4", "q1")
+	l2 := addTrip("l2", r3, -1, "q1", "q2", "q3", "q4", "q1")
+	l3 := addTrip("l3", r3, -1, "q3", "q2", "q1", "q4", "q3")
+
+	// perpendicular patterns without shared stops
+	r4 := &gtfs.Route{Id: "r4"}
+	addTrip("p1", r4, -1, "a", "d")
+	addTrip("p2", r4, -1, "a", "d")
+	p3 := addTrip("p3", r4, -1, "n", "s")
+
+	DirectionInferrer{MinConfidence: 0.5}.Run(feed)
+
+	check := func(trip *gtfs.Trip, dir int8) {
+		if trip.Direction_id != dir {
+			t.Errorf("expected direction %d for trip %s, got %d", dir, trip.Id, trip.Direction_id)
+		}
+	}
+
+	check(t1, 0)
+	check(t2, 0)
+	check(t3, 1)
+	check(t4, 0)
+	check(t5, 1)
+
+	check(k1, 1)
+	check(m1, 0)
+
+	check(l1, 0)
+	check(l2, 0)
+	check(l3, 1)
+
+	check(p3, -1)
+}
@@ -0,0 +1,421 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FareRuleMinimizer reduces the number of fare rules by merging zones
+// with identical rules and by replacing exhaustive origin/destination
+// enumerations with wildcard rules. If MinimizeZoneIds is set, zone IDs
+// are replaced by continuous IDs in base Base.
+type FareRuleMinimizer struct {
+	MinimizeZoneIds bool
+	Prefix          string
+	Base            int
+}
+
+// a fare rule with zone classes instead of zone IDs
+type fareRuleClassKey struct {
+	fare  *gtfs.FareAttribute
+	route *gtfs.Route
+	o     int
+	d     int
+	c     int
+}
+
+// Run this FareRuleMinimizer on some feed
+func (frm FareRuleMinimizer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Minimizing fare rules... ")
+
+	bef := frm.numRules(feed)
+	befZones := len(frm.zones(feed))
+
+	frm.mergeZones(feed)
+	frm.collapseEnumerations(feed)
+
+	// collapsing may leave zones without rules
+	frm.mergeZones(feed)
+
+	if frm.MinimizeZoneIds {
+		frm.minimizeZoneIds(feed)
+	}
+
+	aft := frm.numRules(feed)
+	perc := 0.0
+	if bef > 0 {
+		perc = 100.0 * float64(bef-aft) / float64(bef)
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (-%d fare rules [-%.2f%%], -%d zones)\n",
+		bef-aft, perc, befZones-len(frm.zones(feed)))
+}
+
+// Merge zones which can be exchanged for each other in every fare rule.
+// Zones used in contains_id are never merged, as the rules of a fare
+// referencing them are evaluated as a set.
+func (frm FareRuleMinimizer) mergeZones(feed *gtfsparser.Feed) {
+	zones := frm.zones(feed)
+
+	fixed := make(map[string]bool)
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			if len(r.Contains_id) > 0 {
+				fixed[r.Contains_id] = true
+			}
+		}
+	}
+
+	// refine a partition of the zones until the zones in a class have the
+	// same rules, with every zone replaced by its class
+	cls := make(map[string]int)
+	numCls := 1
+	for _, z := range zones {
+		if fixed[z] {
+			cls[z] = numCls
+			numCls++
+		} else {
+			cls[z] = 0
+		}
+	}
+
+	for {
+		sigs := make(map[string][]string)
+		for _, fa := range feed.FareAttributes {
+			for _, r := range fa.Rules {
+				k := frm.classKey(fa, r, cls)
+				sig := fmt.Sprintf("%p\t%p\t%d\t%d\t%d", k.fare, k.route, k.o, k.d, k.c)
+				for _, z := range []string{r.Origin_id, r.Destination_id, r.Contains_id} {
+					if len(z) > 0 {
+						sigs[z] = append(sigs[z], sig)
+					}
+				}
+			}
+		}
+
+		newCls := make(map[string]int)
+		ids := make(map[string]int)
+		for _, z := range zones {
+			sig := sigs[z]
+			sort.Strings(sig)
+			key := strconv.Itoa(cls[z]) + "\n" + strings.Join(sig, "\n")
+			if _, ok := ids[key]; !ok {
+				ids[key] = len(ids)
+			}
+			newCls[z] = ids[key]
+		}
+
+		cls = newCls
+
+		if len(ids) == numCls {
+			break
+		}
+
+		numCls = len(ids)
+	}
+
+	// merging is only allowed if every combination of the zones of a class
+	// was present before, otherwise split the classes involved
+	for {
+		size := make(map[int]int)
+		for _, c := range cls {
+			size[c]++
+		}
+
+		combs := make(map[fareRuleClassKey]map[[3]string]bool)
+		for _, fa := range feed.FareAttributes {
+			for _, r := range fa.Rules {
+				k := frm.classKey(fa, r, cls)
+				if _, ok := combs[k]; !ok {
+					combs[k] = make(map[[3]string]bool)
+				}
+				combs[k][[3]string{r.Origin_id, r.Destination_id, r.Contains_id}] = true
+			}
+		}
+
+		split := false
+		for k, comb := range combs {
+			req := 1
+			for _, c := range []int{k.o, k.d, k.c} {
+				if c > -1 {
+					req *= size[c]
+				}
+			}
+
+			if len(comb) == req {
+				continue
+			}
+
+			for _, z := range zones {
+				if (cls[z] == k.o || cls[z] == k.d) && size[cls[z]] > 1 {
+					cls[z] = numCls
+					numCls++
+					split = true
+				}
+			}
+		}
+
+		if !split {
+			break
+		}
+	}
+
+	// the smallest ID of a class becomes the ID of the merged zone
+	repr := make(map[int]string)
+	for _, z := range zones {
+		if _, ok := repr[cls[z]]; !ok {
+			repr[cls[z]] = z
+		}
+	}
+
+	rename := make(map[string]string)
+	for _, z := range zones {
+		if repr[cls[z]] != z {
+			rename[z] = repr[cls[z]]
+		}
+	}
+
+	frm.renameZones(feed, rename)
+}
+
+// Replace the rules of a fare which enumerate every origin/destination
+// pair of the zones served by a route by a single rule without zones, and
+// complete rows or columns of the enumeration by rules with only an origin
+// or a destination
+func (frm FareRuleMinimizer) collapseEnumerations(feed *gtfsparser.Feed) {
+	// zones served by each route, nil for all routes. If a route serves a
+	// stop without zone, its enumerations can never be complete.
+	served := make(map[*gtfs.Route]map[string]bool)
+	served[nil] = make(map[string]bool)
+	noZone := make(map[*gtfs.Route]bool)
+
+	for _, t := range feed.Trips {
+		if _, ok := served[t.Route]; !ok {
+			served[t.Route] = make(map[string]bool)
+		}
+		for i := range t.StopTimes {
+			z := t.StopTimes[i].Stop().Zone_id
+			if len(z) == 0 {
+				noZone[t.Route] = true
+				noZone[nil] = true
+				continue
+			}
+			served[t.Route][z] = true
+			served[nil][z] = true
+		}
+	}
+
+	for _, fa := range feed.FareAttributes {
+		if frm.hasAddFlds(feed, fa) {
+			continue
+		}
+
+		pairs := make(map[*gtfs.Route]map[[2]string]bool)
+		for _, r := range fa.Rules {
+			if len(r.Origin_id) == 0 || len(r.Destination_id) == 0 || len(r.Contains_id) > 0 {
+				continue
+			}
+			if _, ok := pairs[r.Route]; !ok {
+				pairs[r.Route] = make(map[[2]string]bool)
+			}
+			pairs[r.Route][[2]string{r.Origin_id, r.Destination_id}] = true
+		}
+
+		for route, p := range pairs {
+			zones := sortedKeys(served[route])
+			if noZone[route] || len(zones) == 0 {
+				continue
+			}
+
+			rows := make(map[string]bool)
+			cols := make(map[string]bool)
+
+			for _, z := range zones {
+				rows[z] = true
+				cols[z] = true
+				for _, y := range zones {
+					if !p[[2]string{z, y}] {
+						rows[z] = false
+					}
+					if !p[[2]string{y, z}] {
+						cols[z] = false
+					}
+				}
+			}
+
+			full := true
+			for _, z := range zones {
+				full = full && rows[z]
+			}
+
+			newRules := make([]*gtfs.FareAttributeRule, 0, len(fa.Rules))
+			added := make(map[[2]string]bool)
+			changed := false
+
+			for _, r := range fa.Rules {
+				if r.Route != route || len(r.Origin_id) == 0 || len(r.Destination_id) == 0 || len(r.Contains_id) > 0 {
+					newRules = append(newRules, r)
+					continue
+				}
+
+				// pairs outside the served zones never match on this route,
+				// and are thus covered by a rule without zones
+				var repl [2]string
+				if full {
+					repl = [2]string{"", ""}
+				} else if rows[r.Origin_id] {
+					repl = [2]string{r.Origin_id, ""}
+				} else if cols[r.Destination_id] {
+					repl = [2]string{"", r.Destination_id}
+				} else {
+					newRules = append(newRules, r)
+					continue
+				}
+
+				changed = true
+
+				if added[repl] {
+					continue
+				}
+
+				added[repl] = true
+				newRules = append(newRules, &gtfs.FareAttributeRule{Route: route, Origin_id: repl[0], Destination_id: repl[1]})
+			}
+
+			if changed {
+				fa.Rules = frm.uniqueRules(newRules)
+			}
+		}
+	}
+}
+
+// Replace zone IDs by continuous IDs
+func (frm FareRuleMinimizer) minimizeZoneIds(feed *gtfsparser.Feed) {
+	var idCount int64 = 1
+
+	rename := make(map[string]string)
+	for _, z := range frm.zones(feed) {
+		rename[z] = frm.Prefix + strconv.FormatInt(idCount, frm.Base)
+		idCount = idCount + 1
+	}
+
+	frm.renameZones(feed, rename)
+}
+
+// Rename zones in stops and fare rules, rules which become equal are
+// removed
+func (frm FareRuleMinimizer) renameZones(feed *gtfsparser.Feed, rename map[string]string) {
+	if len(rename) == 0 {
+		return
+	}
+
+	get := func(z string) string {
+		if n, ok := rename[z]; ok {
+			return n
+		}
+		return z
+	}
+
+	for _, s := range feed.Stops {
+		s.Zone_id = get(s.Zone_id)
+	}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			r.Origin_id = get(r.Origin_id)
+			r.Destination_id = get(r.Destination_id)
+			r.Contains_id = get(r.Contains_id)
+		}
+
+		if !frm.hasAddFlds(feed, fa) {
+			fa.Rules = frm.uniqueRules(fa.Rules)
+		}
+	}
+
+	zoneIds := make(map[string]bool)
+	for z := range feed.ZoneIds {
+		zoneIds[get(z)] = true
+	}
+	feed.ZoneIds = zoneIds
+}
+
+// Remove duplicates from a list of fare rules
+func (frm FareRuleMinimizer) uniqueRules(rules []*gtfs.FareAttributeRule) []*gtfs.FareAttributeRule {
+	seen := make(map[gtfs.FareAttributeRule]bool)
+	ret := make([]*gtfs.FareAttributeRule, 0, len(rules))
+
+	for _, r := range rules {
+		if seen[*r] {
+			continue
+		}
+		seen[*r] = true
+		ret = append(ret, r)
+	}
+
+	return ret
+}
+
+// Get the rule of a fare with every zone replaced by its class, -1 for
+// no zone
+func (frm FareRuleMinimizer) classKey(fa *gtfs.FareAttribute, r *gtfs.FareAttributeRule, cls map[string]int) fareRuleClassKey {
+	get := func(z string) int {
+		if len(z) == 0 {
+			return -1
+		}
+		return cls[z]
+	}
+	return fareRuleClassKey{fa, r.Route, get(r.Origin_id), get(r.Destination_id), get(r.Contains_id)}
+}
+
+// Check if some rule of a fare has additional fields
+func (frm FareRuleMinimizer) hasAddFlds(feed *gtfsparser.Feed, fa *gtfs.FareAttribute) bool {
+	for _, flds := range feed.FareRulesAddFlds {
+		if len(flds[fa.Id]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the sorted IDs of all zones used by stops or fare rules
+func (frm FareRuleMinimizer) zones(feed *gtfsparser.Feed) []string {
+	zones := make(map[string]bool)
+
+	for _, s := range feed.Stops {
+		if len(s.Zone_id) > 0 {
+			zones[s.Zone_id] = true
+		}
+	}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			for _, z := range []string{r.Origin_id, r.Destination_id, r.Contains_id} {
+				if len(z) > 0 {
+					zones[z] = true
+				}
+			}
+		}
+	}
+
+	return sortedKeys(zones)
+}
+
+// Get the total number of fare rules
+func (frm FareRuleMinimizer) numRules(feed *gtfsparser.Feed) int {
+	n := 0
+	for _, fa := range feed.FareAttributes {
+		n += len(fa.Rules)
+	}
+	return n
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestFareRuleMinimizer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	// route id -> zones of its stops
+	routes := map[string][]string{
+		"r1": {"A", "B", "C"},
+		"r2": {"D", "E", "F"},
+		"r3": {"G", "H"},
+		"r4": {"I", "J"},
+	}
+
+	for rid, zones := range routes {
+		r := &gtfs.Route{Id: rid}
+		feed.Routes[rid] = r
+		trip := &gtfs.Trip{Id: rid, Route: r}
+		for _, z := range zones {
+			s := &gtfs.Stop{Id: "s" + z, Zone_id: z}
+			feed.Stops[s.Id] = s
+			feed.ZoneIds[z] = true
+			st := gtfs.StopTime{}
+			st.SetStop(s)
+			trip.StopTimes = append(trip.StopTimes, st)
+		}
+		feed.Trips[rid] = trip
+	}
+
+	addFare := func(id string, rid string, pairs ...string) {
+		fa := &gtfs.FareAttribute{Id: id}
+		for _, p := range pairs {
+			fa.Rules = append(fa.Rules, &gtfs.FareAttributeRule{Route: feed.Routes[rid], Origin_id: p[:1], Destination_id: p[1:]})
+		}
+		feed.FareAttributes[id] = fa
+	}
+
+	// exhaustive enumeration
+	addFare("all", "r1", "AA", "AB", "AC", "BA", "BB", "BC", "CA", "CB", "CC")
+
+	// D and E are equivalent
+	addFare("de", "r2", "DD", "DE", "ED", "EE")
+	addFare("x", "r2", "DF", "EF")
+
+	// G and H have the same rules, but G to G is not covered
+	addFare("gh", "r3", "GH", "HG")
+
+	// complete row and column for I
+	addFare("row", "r4", "II", "IJ", "JI")
+
+	FareRuleMinimizer{}.Run(feed)
+
+	exp := map[string][]string{
+		"all": {"-"},
+		"de":  {"D-D"},
+		"x":   {"D-F"},
+		"gh":  {"G-H", "H-G"},
+		"row": {"I-", "-I"},
+	}
+
+	for id, rules := range exp {
+		fa := feed.FareAttributes[id]
+		if len(fa.Rules) != len(rules) {
+			t.Errorf("expected %d rules for fare %s, got %d", len(rules), id, len(fa.Rules))
+			continue
+		}
+		for i, r := range fa.Rules {
+			if r.Origin_id+"-"+r.Destination_id != rules[i] {
+				t.Errorf("expected rule %s for fare %s, got %s-%s", rules[i], id, r.Origin_id, r.Destination_id)
+			}
+		}
+	}
+
+	expZones := map[string]string{"sA": "A", "sB": "A", "sC": "A", "sD": "D", "sE": "D", "sF": "F", "sG": "G", "sH": "H", "sI": "I", "sJ": "A"}
+	for sid, z := range expZones {
+		if feed.Stops[sid].Zone_id != z {
+			t.Errorf("expected zone %s for stop %s, got %s", z, sid, feed.Stops[sid].Zone_id)
+		}
+	}
+
+	if feed.ZoneIds["B"] || !feed.ZoneIds["A"] {
+		t.Error("expected zone IDs to be updated")
+	}
+
+	FareRuleMinimizer{MinimizeZoneIds: true, Prefix: "z", Base: 10}.Run(feed)
+
+	// A, D, F, G, H, I
+	if feed.Stops["sA"].Zone_id != "z1" || feed.Stops["sE"].Zone_id != "z2" || feed.Stops["sI"].Zone_id != "z6" {
+		t.Errorf("unexpected minimized zone IDs %s, %s, %s", feed.Stops["sA"].Zone_id, feed.Stops["sE"].Zone_id, feed.Stops["sI"].Zone_id)
+	}
+
+	if r := feed.FareAttributes["x"].Rules[0]; r.Origin_id != "z2" || r.Destination_id != "z3" {
+		t.Errorf("expected rule z2-z3, got %s-%s", r.Origin_id, r.Destination_id)
+	}
+}
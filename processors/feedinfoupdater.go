@@ -0,0 +1,519 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FeedInfoUpdater keeps feed_info.txt in line with the feed contents. The
+// validity range is recomputed from the dates services of trips are
+// active on, and cleared if no trip is active at all. Multiple feed infos
+// (e.g. after merging feeds) are reduced to the first one. Non-empty
+// fields of Publisher overwrite the publisher fields. If Version is set, it is used as a template for feed_version,
+// see versionString(). If Create is set and the feed has no feed info, one
+// is created from Publisher, or from the agencies of the feed.
+type FeedInfoUpdater struct {
+	Publisher *gtfs.FeedInfo
+	Version   string
+	Create    bool
+}
+
+// ReadFeedInfoPublisher reads publisher fields from a file. Each line holds
+// one field, as <field>,<value>, with <field> one of feed_publisher_name,
+// feed_publisher_url, feed_lang, feed_contact_email, feed_contact_url.
+// Values containing commas must be quoted. Empty lines and lines starting
+// with # are ignored.
+func ReadFeedInfoPublisher(path string) (*gtfs.FeedInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := &gtfs.FeedInfo{}
+	ret.Lang, _ = gtfs.NewLanguageISO6391("")
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if len(rec) == 1 && len(strings.TrimSpace(rec[0])) == 0 {
+			continue
+		}
+
+		if len(rec) != 2 {
+			return nil, fmt.Errorf("line %d: expected <field>,<value>", line)
+		}
+
+		val := strings.TrimSpace(rec[1])
+
+		switch strings.TrimSpace(rec[0]) {
+		case "feed_publisher_name":
+			ret.Publisher_name = val
+		case "feed_publisher_url":
+			ret.Publisher_url, err = url.ParseRequestURI(val)
+		case "feed_lang":
+			ret.Lang, err = gtfs.NewLanguageISO6391(strings.ToLower(val))
+		case "feed_contact_email":
+			ret.Contact_email, err = mail.ParseAddress(val)
+		case "feed_contact_url":
+			ret.Contact_url, err = url.ParseRequestURI(val)
+		default:
+			err = fmt.Errorf("unknown field '%s'", rec[0])
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	return ret, nil
+}
+
+// Run this FeedInfoUpdater on some feed
+func (fiu FeedInfoUpdater) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Updating feed info... ")
+
+	if len(feed.FeedInfos) == 0 {
+		if !fiu.Create {
+			fmt.Fprintf(os.Stdout, "done. (no feed info)\n")
+			return
+		}
+
+		fi := fiu.publisherFromAgencies(feed)
+		fiu.applyPublisher(fi)
+
+		if len(fi.Publisher_name) == 0 || fi.Publisher_url == nil || len(fi.Lang.GetLangString()) == 0 {
+			fmt.Fprintf(os.Stdout, "done. (no feed info created, publisher name, URL or language unknown)\n")
+			return
+		}
+
+		feed.FeedInfos = append(feed.FeedInfos, fi)
+	}
+
+	merged := len(feed.FeedInfos) - 1
+
+	// only the first feed info survives
+	for _, fi := range feed.FeedInfos[1:] {
+		for k := range feed.FeedInfosAddFlds {
+			delete(feed.FeedInfosAddFlds[k], fi)
+		}
+	}
+	feed.FeedInfos = feed.FeedInfos[:1]
+
+	fi := feed.FeedInfos[0]
+
+	fiu.applyPublisher(fi)
+
+	// without any active trip, a previous validity range would be wrong
+	cleared := !fi.Start_date.IsEmpty() || !fi.End_date.IsEmpty()
+
+	fi.Start_date, fi.End_date = fiu.activeRange(feed)
+
+	if len(fiu.Version) > 0 {
+		fi.Version = fiu.versionString(feed, fi, time.Now())
+	}
+
+	rng := "no active service"
+	if !fi.Start_date.IsEmpty() {
+		rng = "valid from " + dateStr(fi.Start_date) + " to " + dateStr(fi.End_date)
+	} else if cleared {
+		rng = "no active service, validity range cleared"
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%s, version '%s', %d feed infos merged)\n", rng, fi.Version, merged)
+}
+
+// Overwrite the publisher fields of fi with the non-empty fields of the
+// configured publisher
+func (fiu FeedInfoUpdater) applyPublisher(fi *gtfs.FeedInfo) {
+	if fiu.Publisher == nil {
+		return
+	}
+
+	if len(fiu.Publisher.Publisher_name) > 0 {
+		fi.Publisher_name = fiu.Publisher.Publisher_name
+	}
+	if fiu.Publisher.Publisher_url != nil {
+		fi.Publisher_url = fiu.Publisher.Publisher_url
+	}
+	if len(fiu.Publisher.Lang.GetLangString()) > 0 {
+		fi.Lang = fiu.Publisher.Lang
+	}
+	if fiu.Publisher.Contact_email != nil {
+		fi.Contact_email = fiu.Publisher.Contact_email
+	}
+	if fiu.Publisher.Contact_url != nil {
+		fi.Contact_url = fiu.Publisher.Contact_url
+	}
+}
+
+// Build a feed info from the agencies of a feed. The publisher is only
+// taken from an agency if there is exactly one, the language if all
+// agencies share it.
+func (fiu FeedInfoUpdater) publisherFromAgencies(feed *gtfsparser.Feed) *gtfs.FeedInfo {
+	fi := &gtfs.FeedInfo{}
+	fi.Lang, _ = gtfs.NewLanguageISO6391("")
+
+	if len(feed.Agencies) == 1 {
+		for _, a := range feed.Agencies {
+			fi.Publisher_name = a.Name
+			fi.Publisher_url = a.Url
+		}
+	}
+
+	first := true
+	for _, a := range feed.Agencies {
+		if first {
+			fi.Lang = a.Lang
+			first = false
+		} else if a.Lang != fi.Lang {
+			fi.Lang, _ = gtfs.NewLanguageISO6391("")
+			break
+		}
+	}
+
+	return fi
+}
+
+// Get the first and the last date any trip is active on, empty dates if no
+// trip is active at all
+func (fiu FeedInfoUpdater) activeRange(feed *gtfsparser.Feed) (gtfs.Date, gtfs.Date) {
+	var start, end gtfs.Date
+
+	proced := make(map[*gtfs.Service]bool)
+
+	for _, t := range feed.Trips {
+		if proced[t.Service] {
+			continue
+		}
+		proced[t.Service] = true
+
+		first := t.Service.GetFirstActiveDate()
+		if first.IsEmpty() {
+			continue
+		}
+		last := t.Service.GetLastActiveDate()
+
+		if start.IsEmpty() || first.GetTime().Before(start.GetTime()) {
+			start = first
+		}
+		if end.IsEmpty() || last.GetTime().After(end.GetTime()) {
+			end = last
+		}
+	}
+
+	return start, end
+}
+
+// Build the feed version from the template. The following placeholders
+// are replaced:
+//
+//	{hash}    a hash of the feed contents
+//	{date}    the current date, as YYYYMMDD
+//	{start}   the feed start date, as YYYYMMDD
+//	{end}     the feed end date, as YYYYMMDD
+//	{version} the previous feed version
+func (fiu FeedInfoUpdater) versionString(feed *gtfsparser.Feed, fi *gtfs.FeedInfo, now time.Time) string {
+	hash := ""
+	if strings.Contains(fiu.Version, "{hash}") {
+		hash = feedHash(feed)
+	}
+
+	start, end := "", ""
+	if !fi.Start_date.IsEmpty() {
+		start = dateStr(fi.Start_date)
+		end = dateStr(fi.End_date)
+	}
+
+	r := strings.NewReplacer(
+		"{hash}", hash,
+		"{date}", now.Format("20060102"),
+		"{start}", start,
+		"{end}", end,
+		"{version}", fi.Version,
+	)
+
+	return r.Replace(fiu.Version)
+}
+
+// Get a hash of the contents of a feed, independent of the order of
+// entities. Feed infos are not part of the hash, as they hold the
+// version itself and are derived from the rest of the feed. IDs are
+// hashed as they are, so this should run after any ID changes.
+func feedHash(feed *gtfsparser.Feed) string {
+	h := sha1.New()
+
+	lines := make([]string, 0)
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+
+	// additional fields of an entity, in a fixed order
+	flds := func(m map[string]map[string]string, id string) string {
+		ret := make([]string, 0)
+		for name, vals := range m {
+			if v, ok := vals[id]; ok {
+				ret = append(ret, name+"="+v)
+			}
+		}
+		sort.Strings(ret)
+		return strings.Join(ret, ",")
+	}
+
+	for _, a := range feed.Agencies {
+		add("a\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", a.Id, a.Name, urlStr(a.Url), a.Timezone.GetTzString(), a.Lang.GetLangString(), a.Phone, urlStr(a.Fare_url), mailStr(a.Email), attributionsStr(a.Attributions), translationsStr(a.Translations), flds(feed.AgenciesAddFlds, a.Id))
+	}
+
+	for _, s := range feed.Stops {
+		parent := ""
+		if s.Parent_station != nil {
+			parent = s.Parent_station.Id
+		}
+		level := ""
+		if s.Level != nil {
+			level = s.Level.Id
+		}
+		add("s\t%s\t%s\t%s\t%s\t%f\t%f\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", s.Id, s.Code, s.Name, s.Desc, s.Lat, s.Lon, s.Location_type, s.Wheelchair_boarding, s.Zone_id, urlStr(s.Url), parent, level, s.Platform_code, s.Timezone.GetTzString(), translationsStr(s.Translations), flds(feed.StopsAddFlds, s.Id))
+	}
+
+	for _, r := range feed.Routes {
+		agency := ""
+		if r.Agency != nil {
+			agency = r.Agency.Id
+		}
+		add("r\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s", r.Id, agency, r.Short_name, r.Long_name, r.Desc, r.Type, urlStr(r.Url), r.Color, r.Text_color, r.Sort_order, r.Continuous_pickup, r.Continuous_drop_off, attributionsStr(r.Attributions), flds(feed.RoutesAddFlds, r.Id))
+	}
+
+	for _, s := range feed.Services {
+		exc := make([]string, 0, len(s.Exceptions()))
+		for d, t := range s.Exceptions() {
+			exc = append(exc, fmt.Sprintf("%s:%t", dateStr(d), t))
+		}
+		sort.Strings(exc)
+		add("c\t%s\t%d\t%s\t%s\t%s", s.Id(), s.RawDaymap(), dateStr(s.Start_date()), dateStr(s.End_date()), strings.Join(exc, ","))
+	}
+
+	for _, sh := range feed.Shapes {
+		var b strings.Builder
+		for i, p := range sh.Points {
+			fmt.Fprintf(&b, "%f,%f,%f", p.Lat, p.Lon, p.Dist_traveled)
+			addFlds := make([]string, 0)
+			for name, vals := range feed.ShapesAddFlds {
+				if v, ok := vals[sh.Id][i]; ok {
+					addFlds = append(addFlds, ","+name+"="+v)
+				}
+			}
+			sort.Strings(addFlds)
+			b.WriteString(strings.Join(addFlds, ""))
+			b.WriteString(";")
+		}
+		add("p\t%s\t%s", sh.Id, b.String())
+	}
+
+	for _, t := range feed.Trips {
+		var b strings.Builder
+		for i := range t.StopTimes {
+			st := &t.StopTimes[i]
+			fmt.Fprintf(&b, "%s,%d,%d,%d,%s,%d,%d,%d,%d,%f,%t", st.Stop().Id, st.Sequence(), st.Arrival_time().SecondsSinceMidnight(), st.Departure_time().SecondsSinceMidnight(), strPtr(st.Headsign()), st.Pickup_type(), st.Drop_off_type(), st.Continuous_pickup(), st.Continuous_drop_off(), st.Shape_dist_traveled(), st.Timepoint())
+			addFlds := make([]string, 0)
+			for name, vals := range feed.StopTimesAddFlds {
+				if v, ok := vals[t.Id][st.Sequence()]; ok {
+					addFlds = append(addFlds, ","+name+"="+v)
+				}
+			}
+			sort.Strings(addFlds)
+			b.WriteString(strings.Join(addFlds, ""))
+			b.WriteString(";")
+		}
+		if t.Frequencies != nil {
+			freqs := make([]string, 0, len(*t.Frequencies))
+			for _, f := range *t.Frequencies {
+				fs := fmt.Sprintf("f%d,%d,%d,%t", f.Start_time.SecondsSinceMidnight(), f.End_time.SecondsSinceMidnight(), f.Headway_secs, f.Exact_times)
+				addFlds := make([]string, 0)
+				for name, vals := range feed.FrequenciesAddFlds {
+					if v, ok := vals[t.Id][f]; ok {
+						addFlds = append(addFlds, ","+name+"="+v)
+					}
+				}
+				sort.Strings(addFlds)
+				fs += strings.Join(addFlds, "")
+				freqs = append(freqs, fs)
+			}
+			sort.Strings(freqs)
+			b.WriteString(strings.Join(freqs, ";"))
+		}
+		shape := ""
+		if t.Shape != nil {
+			shape = t.Shape.Id
+		}
+		attrs := ""
+		if t.Attributions != nil {
+			attrs = attributionsStr(*t.Attributions)
+		}
+		trans := ""
+		if t.Translations != nil {
+			trans = translationsStr(*t.Translations)
+		}
+		add("t\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s", t.Id, t.Route.Id, t.Service.Id(), strPtr(t.Headsign), strPtr(t.Short_name), strPtr(t.Block_id), shape, t.Direction_id, t.Wheelchair_accessible, t.Bikes_allowed, attrs, trans, flds(feed.TripsAddFlds, t.Id), b.String())
+	}
+
+	for _, fa := range feed.FareAttributes {
+		rules := make([]string, 0, len(fa.Rules))
+		for _, r := range fa.Rules {
+			route := ""
+			if r.Route != nil {
+				route = r.Route.Id
+			}
+			rule := route + "," + r.Origin_id + "," + r.Destination_id + "," + r.Contains_id
+			addFlds := make([]string, 0)
+			for name, vals := range feed.FareRulesAddFlds {
+				if v, ok := vals[fa.Id][r]; ok {
+					addFlds = append(addFlds, ","+name+"="+v)
+				}
+			}
+			sort.Strings(addFlds)
+			rule += strings.Join(addFlds, "")
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		agency := ""
+		if fa.Agency != nil {
+			agency = fa.Agency.Id
+		}
+		add("f\t%s\t%s\t%s\t%d\t%d\t%s\t%d\t%s\t%s", fa.Id, fa.Price, fa.Currency_type, fa.Payment_method, fa.Transfers, agency, fa.Transfer_duration, flds(feed.FareAttributesAddFlds, fa.Id), strings.Join(rules, ";"))
+	}
+
+	for k, v := range feed.Transfers {
+		ids := make([]string, 0, 6)
+		for _, s := range []*gtfs.Stop{k.From_stop, k.To_stop} {
+			if s != nil {
+				ids = append(ids, s.Id)
+			} else {
+				ids = append(ids, "")
+			}
+		}
+		for _, r := range []*gtfs.Route{k.From_route, k.To_route} {
+			if r != nil {
+				ids = append(ids, r.Id)
+			} else {
+				ids = append(ids, "")
+			}
+		}
+		for _, t := range []*gtfs.Trip{k.From_trip, k.To_trip} {
+			if t != nil {
+				ids = append(ids, t.Id)
+			} else {
+				ids = append(ids, "")
+			}
+		}
+		addFlds := make([]string, 0)
+		for name, vals := range feed.TransfersAddFlds {
+			if fv, ok := vals[k]; ok {
+				addFlds = append(addFlds, name+"="+fv)
+			}
+		}
+		sort.Strings(addFlds)
+		add("x\t%s\t%d\t%d\t%s", strings.Join(ids, ","), v.Transfer_type, v.Min_transfer_time, strings.Join(addFlds, ","))
+	}
+
+	for _, pw := range feed.Pathways {
+		add("w\t%s\t%s\t%s\t%d\t%t\t%f\t%d\t%d\t%f\t%f\t%s\t%s\t%s\t%s", pw.Id, pw.From_stop.Id, pw.To_stop.Id, pw.Mode, pw.Is_bidirectional, pw.Length, pw.Traversal_time, pw.Stair_count, pw.Max_slope, pw.Min_width, pw.Signposted_as, pw.Reversed_signposted_as, translationsStr(pw.Translations), flds(feed.PathwaysAddFlds, pw.Id))
+	}
+
+	for _, l := range feed.Levels {
+		add("l\t%s\t%f\t%s\t%s\t%s", l.Id, l.Index, l.Name, translationsStr(l.Translations), flds(feed.LevelsAddFlds, l.Id))
+	}
+
+	for _, a := range feed.Attributions {
+		addFlds := make([]string, 0)
+		for name, vals := range feed.AttributionsAddFlds {
+			if v, ok := vals[a]; ok {
+				addFlds = append(addFlds, name+"="+v)
+			}
+		}
+		sort.Strings(addFlds)
+		add("b\t%s\t%s", attributionsStr([]*gtfs.Attribution{a}), strings.Join(addFlds, ","))
+	}
+
+	sort.Strings(lines)
+
+	for _, l := range lines {
+		io.WriteString(h, l)
+		io.WriteString(h, "\n")
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Get a string of a list of attributions for hashing, independent of
+// their order
+func attributionsStr(attrs []*gtfs.Attribution) string {
+	ret := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		ret = append(ret, fmt.Sprintf("%s,%s,%t,%t,%t,%s,%s,%s", a.Id, a.Organization_name, a.Is_producer, a.Is_operator, a.Is_authority, mailStr(a.Email), urlStr(a.Url), a.Phone))
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ";")
+}
+
+// Get a string of a list of translations for hashing, independent of
+// their order
+func translationsStr(trans []*gtfs.Translation) string {
+	ret := make([]string, 0, len(trans))
+	for _, t := range trans {
+		ret = append(ret, fmt.Sprintf("%s,%s,%s,%s", t.FieldName, t.Language.GetLangString(), t.Translation, t.FieldValue))
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ";")
+}
+
+func urlStr(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+func mailStr(m *mail.Address) string {
+	if m == nil {
+		return ""
+	}
+	return m.String()
+}
+
+func strPtr(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
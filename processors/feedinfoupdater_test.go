@@ -0,0 +1,221 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func feedInfoTestFeed() *gtfsparser.Feed {
+	feed := gtfsparser.NewFeed()
+
+	de, _ := gtfs.NewLanguageISO6391("de")
+	u, _ := url.Parse("https://example.com")
+	ag := &gtfs.Agency{Id: "a", Name: "Agency", Url: u, Lang: de}
+	feed.Agencies["a"] = ag
+
+	r := &gtfs.Route{Id: "r", Agency: ag}
+	feed.Routes["r"] = r
+
+	// weekdays in the first week of March 2026, plus a single Sunday
+	s1 := gtfs.EmptyService()
+	s1.SetId("s1")
+	s1.SetRawDaymap(0x3E)
+	s1.SetStart_date(gtfs.NewDate(1, 3, 2026))
+	s1.SetEnd_date(gtfs.NewDate(8, 3, 2026))
+	s1.SetExceptionTypeOn(gtfs.NewDate(15, 3, 2026), 1)
+	feed.Services["s1"] = s1
+
+	// not used by any trip
+	s2 := gtfs.EmptyService()
+	s2.SetId("s2")
+	s2.SetRawDaymap(0x7F)
+	s2.SetStart_date(gtfs.NewDate(1, 1, 2026))
+	s2.SetEnd_date(gtfs.NewDate(31, 12, 2026))
+	feed.Services["s2"] = s2
+
+	feed.Trips["t"] = &gtfs.Trip{Id: "t", Route: r, Service: s1}
+
+	return feed
+}
+
+func TestFeedInfoUpdater(t *testing.T) {
+	feed := feedInfoTestFeed()
+
+	en, _ := gtfs.NewLanguageISO6391("en")
+	u, _ := url.Parse("https://one.example.com")
+	one := &gtfs.FeedInfo{Publisher_name: "One", Publisher_url: u, Lang: en, Start_date: gtfs.NewDate(1, 1, 2026), End_date: gtfs.NewDate(31, 12, 2026), Version: "1"}
+	two := &gtfs.FeedInfo{Publisher_name: "Two", Publisher_url: u, Lang: en, Version: "2"}
+	feed.FeedInfos = []*gtfs.FeedInfo{one, two}
+
+	pu, _ := url.Parse("https://publisher.example.com")
+	noLang, _ := gtfs.NewLanguageISO6391("")
+	FeedInfoUpdater{Publisher: &gtfs.FeedInfo{Publisher_name: "Publisher", Publisher_url: pu, Lang: noLang}, Version: "{start}-{version}"}.Run(feed)
+
+	if len(feed.FeedInfos) != 1 || feed.FeedInfos[0] != one {
+		t.Error("expected only the first feed info to survive")
+		return
+	}
+
+	if one.Publisher_name != "Publisher" || one.Publisher_url != pu || one.Lang != en {
+		t.Errorf("unexpected publisher %s, %s, %s", one.Publisher_name, one.Publisher_url, one.Lang.GetLangString())
+	}
+
+	if dateStr(one.Start_date) != "20260302" || dateStr(one.End_date) != "20260315" {
+		t.Errorf("expected range 20260302 - 20260315, got %s - %s", dateStr(one.Start_date), dateStr(one.End_date))
+	}
+
+	if one.Version != "20260302-1" {
+		t.Errorf("expected version 20260302-1, got %s", one.Version)
+	}
+
+	// no active trip
+	feed = feedInfoTestFeed()
+	feed.Trips = make(map[string]*gtfs.Trip)
+	fi := &gtfs.FeedInfo{Publisher_name: "One", Publisher_url: u, Lang: en, Start_date: gtfs.NewDate(1, 1, 2026), End_date: gtfs.NewDate(31, 12, 2026)}
+	feed.FeedInfos = []*gtfs.FeedInfo{fi}
+	FeedInfoUpdater{}.Run(feed)
+
+	if !fi.Start_date.IsEmpty() || !fi.End_date.IsEmpty() {
+		t.Errorf("expected validity range to be cleared, got %s - %s", dateStr(fi.Start_date), dateStr(fi.End_date))
+	}
+
+	// creation from the agency
+	feed = feedInfoTestFeed()
+	FeedInfoUpdater{Create: true}.Run(feed)
+
+	if len(feed.FeedInfos) != 1 {
+		t.Error("expected feed info to be created")
+		return
+	}
+
+	if fi := feed.FeedInfos[0]; fi.Publisher_name != "Agency" || fi.Lang.GetLangString() != "de" || dateStr(fi.End_date) != "20260315" {
+		t.Errorf("unexpected created feed info %s, %s, %s", fi.Publisher_name, fi.Lang.GetLangString(), dateStr(fi.End_date))
+	}
+
+	// no creation without a publisher
+	feed = feedInfoTestFeed()
+	feed.Agencies["b"] = &gtfs.Agency{Id: "b", Name: "Other"}
+	FeedInfoUpdater{Create: true}.Run(feed)
+
+	if len(feed.FeedInfos) != 0 {
+		t.Error("expected no feed info without a publisher")
+	}
+}
+
+func TestFeedInfoVersionHash(t *testing.T) {
+	feed := feedInfoTestFeed()
+	fi := &gtfs.FeedInfo{}
+	fiu := FeedInfoUpdater{Version: "v{hash}-{date}"}
+
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	a := fiu.versionString(feed, fi, now)
+
+	if len(a) != 1+12+1+8 || a[len(a)-8:] != "20261018" {
+		t.Errorf("unexpected version %s", a)
+	}
+
+	if b := fiu.versionString(feedInfoTestFeed(), fi, now); a != b {
+		t.Errorf("expected equal hashes for equal feeds, got %s and %s", a, b)
+	}
+
+	feed.Trips["t"].Id = "t2"
+
+	if b := fiu.versionString(feed, fi, now); a == b {
+		t.Error("expected different hashes for different feeds")
+	}
+
+	// changes to any entity must change the hash
+	stop := &gtfs.Stop{Id: "s", Lat: 48, Lon: 7.8}
+	block := "b"
+	headsign := "h"
+
+	changes := map[string]func(feed *gtfsparser.Feed){
+		"pickup type":   func(feed *gtfsparser.Feed) { feed.Trips["t"].StopTimes[0].SetPickup_type(1) },
+		"stop headsign": func(feed *gtfsparser.Feed) { feed.Trips["t"].StopTimes[0].SetHeadsign(&headsign) },
+		"measure":       func(feed *gtfsparser.Feed) { feed.Trips["t"].StopTimes[0].SetShape_dist_traveled(10) },
+		"block":         func(feed *gtfsparser.Feed) { feed.Trips["t"].Block_id = &block },
+		"direction":     func(feed *gtfsparser.Feed) { feed.Trips["t"].Direction_id = 1 },
+		"transfer": func(feed *gtfsparser.Feed) {
+			feed.Transfers[gtfs.TransferKey{From_stop: stop, To_stop: stop}] = gtfs.TransferVal{Transfer_type: 2}
+		},
+		"pathway": func(feed *gtfsparser.Feed) {
+			feed.Pathways["p"] = &gtfs.Pathway{Id: "p", From_stop: stop, To_stop: stop, Mode: 1}
+		},
+		"level": func(feed *gtfsparser.Feed) { feed.Levels["l"] = &gtfs.Level{Id: "l"} },
+		"attribution": func(feed *gtfsparser.Feed) {
+			feed.Attributions = append(feed.Attributions, &gtfs.Attribution{Organization_name: "o", Is_producer: true})
+		},
+		"additional field": func(feed *gtfsparser.Feed) {
+			feed.StopsAddFlds["x"] = map[string]string{"s": "1"}
+		},
+	}
+
+	withStop := func() *gtfsparser.Feed {
+		feed := feedInfoTestFeed()
+		feed.Stops["s"] = stop
+		st := gtfs.StopTime{}
+		st.SetStop(stop)
+		feed.Trips["t"].StopTimes = append(feed.Trips["t"].StopTimes, st)
+		return feed
+	}
+
+	base := fiu.versionString(withStop(), fi, now)
+
+	// additional fields must be hashed in a fixed order
+	feed = withStop()
+	for _, name := range []string{"x", "y", "z"} {
+		feed.StopTimesAddFlds[name] = map[string]map[int]string{"t": {feed.Trips["t"].StopTimes[0].Sequence(): name}}
+	}
+	first := feedHash(feed)
+	for i := 0; i < 20; i++ {
+		if h := feedHash(feed); h != first {
+			t.Fatalf("expected stable hash, got %s and %s", first, h)
+		}
+	}
+
+	for name, change := range changes {
+		feed := withStop()
+		change(feed)
+		if b := fiu.versionString(feed, fi, now); b == base {
+			t.Errorf("expected %s to change the hash", name)
+		}
+	}
+}
+
+func TestReadFeedInfoPublisher(t *testing.T) {
+	dir, err := os.MkdirTemp("", "feedinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "publisher.txt")
+	os.WriteFile(p, []byte("# publisher\nfeed_publisher_name,\"Verkehrsverbund, GmbH\"\nfeed_publisher_url,https://example.com\nfeed_lang,DE\n\nfeed_contact_email,info@example.com\n"), 0644)
+
+	fi, err := ReadFeedInfoPublisher(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Publisher_name != "Verkehrsverbund, GmbH" || fi.Publisher_url.String() != "https://example.com" || fi.Lang.GetLangString() != "de" || fi.Contact_email.Address != "info@example.com" || fi.Contact_url != nil {
+		t.Errorf("unexpected publisher %v", fi)
+	}
+
+	for _, c := range []string{"feed_version,1\n", "feed_lang,xx\n", "feed_publisher_url,example\n", "feed_publisher_name\n"} {
+		os.WriteFile(p, []byte(c), 0644)
+		if _, err := ReadFeedInfoPublisher(p); err == nil {
+			t.Errorf("expected error for '%s'", c)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"strings"
+)
+
+// FieldElider removes field values which consumers would inherit anyway,
+// without changing the meaning of the feed
+type FieldElider struct {
+	// stop_timezone equal to the inherited timezone (of the parent station,
+	// or the agency)
+	StopTimezones bool
+
+	// wheelchair_boarding of child stops equal to the one of their parent
+	WheelchairBoarding bool
+
+	// continuous_pickup and continuous_drop_off of stop times equal to the
+	// ones of their route
+	Continuous bool
+
+	// route_short_name repeated at the start of route_long_name. Consumers
+	// do not inherit this, so the displayed name changes, which is why it
+	// is not part of "all"
+	RouteLongNames bool
+
+	Enabled bool
+}
+
+// MakeFieldElider creates a FieldElider from a list of field types
+func MakeFieldElider(args []string) (FieldElider, error) {
+	fe := FieldElider{}
+	fe.Enabled = len(args) > 0
+	for _, arg := range args {
+		switch arg {
+		case "all":
+			fe.StopTimezones = true
+			fe.WheelchairBoarding = true
+			fe.Continuous = true
+		case "stop-timezone":
+			fe.StopTimezones = true
+		case "wheelchair-boarding":
+			fe.WheelchairBoarding = true
+		case "continuous":
+			fe.Continuous = true
+		case "route-long-name":
+			fe.RouteLongNames = true
+		default:
+			return FieldElider{}, errors.New("Unsupported field '" + arg + "'")
+		}
+	}
+	return fe, nil
+}
+
+// Run this FieldElider on some feed
+func (fe FieldElider) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Removing redundant field values... ")
+
+	tzs, wbs, conts, names := 0, 0, 0, 0
+
+	if fe.StopTimezones {
+		tzs = fe.elideStopTimezones(feed)
+	}
+
+	if fe.WheelchairBoarding {
+		for _, s := range feed.Stops {
+			if s.Parent_station != nil && s.Wheelchair_boarding != 0 && s.Wheelchair_boarding == s.Parent_station.Wheelchair_boarding {
+				s.Wheelchair_boarding = 0
+				wbs++
+			}
+		}
+	}
+
+	if fe.Continuous {
+		for _, t := range feed.Trips {
+			for i := range t.StopTimes {
+				st := &t.StopTimes[i]
+
+				// 1 (no continuous stopping) is written as an empty field,
+				// which inherits the route value
+				if st.Continuous_pickup() != 1 && int8(st.Continuous_pickup()) == t.Route.Continuous_pickup {
+					st.SetContinuous_pickup(1)
+					conts++
+				}
+				if st.Continuous_drop_off() != 1 && int8(st.Continuous_drop_off()) == t.Route.Continuous_drop_off {
+					st.SetContinuous_drop_off(1)
+					conts++
+				}
+			}
+		}
+	}
+
+	if fe.RouteLongNames {
+		for _, r := range feed.Routes {
+			if ln := elideShortName(r.Short_name, r.Long_name); ln != r.Long_name {
+				r.Long_name = ln
+				names++
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (-%d stop timezones, -%d wheelchair_boarding values, -%d continuous stopping values, -%d short names in long names)\n", tzs, wbs, conts, names)
+}
+
+// Remove stop timezones equal to the timezone the stop would inherit,
+// returns the number of timezones removed
+func (fe FieldElider) elideStopTimezones(feed *gtfsparser.Feed) int {
+	// all agencies must have the same timezone
+	var agencyTz *gtfs.Timezone
+	for _, a := range feed.Agencies {
+		if agencyTz == nil {
+			tz := a.Timezone
+			agencyTz = &tz
+		} else if !agencyTz.Equals(a.Timezone) {
+			return 0
+		}
+	}
+
+	if agencyTz == nil {
+		return 0
+	}
+
+	emptyTz, _ := gtfs.NewTimezone("")
+
+	// the timezone a stop would inherit
+	inherited := func(s *gtfs.Stop) gtfs.Timezone {
+		if s.Parent_station != nil && s.Parent_station.Timezone.GetTzString() != "" {
+			return s.Parent_station.Timezone
+		}
+		return *agencyTz
+	}
+
+	n := 0
+
+	// child stops first, as they inherit from their (unchanged) parents
+	for _, s := range feed.Stops {
+		if s.Parent_station != nil && s.Timezone.GetTzString() != "" && s.Timezone.Equals(inherited(s)) {
+			s.Timezone = emptyTz
+			n++
+		}
+	}
+
+	for _, s := range feed.Stops {
+		if s.Parent_station == nil && s.Timezone.GetTzString() != "" && s.Timezone.Equals(*agencyTz) {
+			s.Timezone = emptyTz
+			n++
+		}
+	}
+
+	return n
+}
+
+// Remove the short name from the start of a long name, returns the new
+// long name. The short name must be followed by whitespace or by a
+// separator token (-, :, |) which is itself followed by whitespace, so
+// that e.g. "S-Bahn" is kept for the short name "S".
+func elideShortName(short, long string) string {
+	if len(short) == 0 || len(long) <= len(short) || !strings.HasPrefix(long, short) {
+		return long
+	}
+
+	rest := long[len(short):]
+
+	trimmed := strings.TrimLeft(rest, " \t")
+	if len(trimmed) > 0 && strings.ContainsRune("-:|", rune(trimmed[0])) {
+		after := strings.TrimLeft(trimmed[1:], " \t")
+		if len(after) == len(trimmed)-1 {
+			// separator attached to the next word
+			return long
+		}
+		trimmed = after
+	} else if len(trimmed) == len(rest) {
+		// no whitespace after the short name
+		return long
+	}
+
+	if len(trimmed) == 0 {
+		return long
+	}
+
+	return trimmed
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestFieldElider(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	berlin, _ := gtfs.NewTimezone("Europe/Berlin")
+	paris, _ := gtfs.NewTimezone("Europe/Paris")
+
+	feed.Agencies["a"] = &gtfs.Agency{Id: "a", Timezone: berlin}
+
+	st := &gtfs.Stop{Id: "st", Location_type: 1, Wheelchair_boarding: 1, Timezone: paris}
+	stops := []*gtfs.Stop{
+		st,
+		{Id: "c1", Parent_station: st, Wheelchair_boarding: 1, Timezone: paris},
+		{Id: "c2", Parent_station: st, Wheelchair_boarding: 2, Timezone: berlin},
+		{Id: "s1", Wheelchair_boarding: 1, Timezone: berlin},
+		{Id: "s2", Timezone: paris},
+	}
+	for _, s := range stops {
+		feed.Stops[s.Id] = s
+	}
+
+	r := &gtfs.Route{Id: "r", Short_name: "S1", Long_name: "S1 - Airport", Continuous_pickup: 0, Continuous_drop_off: 1}
+	feed.Routes["r"] = r
+	feed.Routes["r2"] = &gtfs.Route{Id: "r2", Short_name: "S", Long_name: "Stadtmitte"}
+	feed.Routes["r3"] = &gtfs.Route{Id: "r3", Short_name: "S", Long_name: "S-Bahn Freiburg"}
+	feed.Routes["r4"] = &gtfs.Route{Id: "r4", Short_name: "3", Long_name: "3 Haid"}
+
+	trip := &gtfs.Trip{Id: "t", Route: r}
+	for i, cp := range []uint8{0, 1, 2} {
+		stt := gtfs.StopTime{}
+		stt.SetStop(stops[i+1])
+		stt.SetContinuous_pickup(cp)
+		stt.SetContinuous_drop_off(2)
+		trip.StopTimes = append(trip.StopTimes, stt)
+	}
+	feed.Trips["t"] = trip
+
+	fe, err := MakeFieldElider([]string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fe.Run(feed)
+
+	expTz := map[string]string{"st": "Europe/Paris", "c1": "", "c2": "Europe/Berlin", "s1": "", "s2": "Europe/Paris"}
+	for id, tz := range expTz {
+		if feed.Stops[id].Timezone.GetTzString() != tz {
+			t.Errorf("expected timezone '%s' for %s, got '%s'", tz, id, feed.Stops[id].Timezone.GetTzString())
+		}
+	}
+
+	expWb := map[string]int8{"st": 1, "c1": 0, "c2": 2, "s1": 1}
+	for id, wb := range expWb {
+		if feed.Stops[id].Wheelchair_boarding != wb {
+			t.Errorf("expected wheelchair_boarding %d for %s, got %d", wb, id, feed.Stops[id].Wheelchair_boarding)
+		}
+	}
+
+	expCp := []uint8{1, 1, 2}
+	for i, cp := range expCp {
+		if trip.StopTimes[i].Continuous_pickup() != cp {
+			t.Errorf("expected continuous_pickup %d for stop time %d, got %d", cp, i, trip.StopTimes[i].Continuous_pickup())
+		}
+		if trip.StopTimes[i].Continuous_drop_off() != 2 {
+			t.Errorf("expected continuous_drop_off 2 for stop time %d", i)
+		}
+	}
+
+	// long names are not part of all
+	if r.Long_name != "S1 - Airport" {
+		t.Errorf("expected long name 'S1 - Airport', got '%s'", r.Long_name)
+	}
+
+	fe, _ = MakeFieldElider([]string{"route-long-name"})
+	fe.Run(feed)
+
+	expLn := map[string]string{"r": "Airport", "r2": "Stadtmitte", "r3": "S-Bahn Freiburg", "r4": "Haid"}
+	for id, ln := range expLn {
+		if feed.Routes[id].Long_name != ln {
+			t.Errorf("expected long name '%s' for %s, got '%s'", ln, id, feed.Routes[id].Long_name)
+		}
+	}
+
+	if elideShortName("S1", "S1: Airport") != "Airport" || elideShortName("S1", "S1 -Airport") != "S1 -Airport" {
+		t.Error("unexpected elision of separators")
+	}
+
+	if _, err := MakeFieldElider([]string{"foo"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	geojson "github.com/paulmach/go.geojson"
+	"math"
+	"os"
+	"sort"
+)
+
+// GeoJSONExporter writes the geometry of a feed to a GeoJSON file. The
+// output contains the stops, the shapes (with the routes and trips using
+// them), the stop patterns of the trips and per route the line geometry of
+// its patterns, with shared segments only included once. Each feature has a
+// "type" property which is one of "stop", "shape", "pattern" or "route". If
+// Routes or Agencies are not empty, only trips of the given routes or
+// agencies (and the stops they serve) are exported. Routes without an
+// agency belong to the only agency of the feed.
+type GeoJSONExporter struct {
+	Routes   map[string]bool
+	Agencies map[string]bool
+}
+
+type geoJSONPattern struct {
+	route *gtfs.Route
+	shape *gtfs.Shape
+	stops []*gtfs.Stop
+	trips []string
+}
+
+// Write the GeoJSON export of feed to path
+func (gje GeoJSONExporter) Write(feed *gtfsparser.Feed, path string) error {
+	fc := geojson.NewFeatureCollection()
+
+	// the agency of routes without one, if it is unique
+	var defAgency *gtfs.Agency
+	if len(feed.Agencies) == 1 {
+		for _, a := range feed.Agencies {
+			defAgency = a
+		}
+	}
+
+	trips := make([]*gtfs.Trip, 0)
+	for _, t := range feed.Trips {
+		if gje.usesTrip(t, defAgency) {
+			trips = append(trips, t)
+		}
+	}
+
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].Id < trips[j].Id
+	})
+
+	gje.addStops(fc, feed, trips)
+	gje.addShapes(fc, trips)
+
+	patterns := gje.getPatterns(trips)
+	gje.addPatterns(fc, patterns)
+	gje.addRoutes(fc, patterns)
+
+	json, err := fc.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, json, 0644)
+}
+
+// True if trip t should be exported
+func (gje *GeoJSONExporter) usesTrip(t *gtfs.Trip, defAgency *gtfs.Agency) bool {
+	if len(gje.Routes) > 0 && !gje.Routes[t.Route.Id] {
+		return false
+	}
+
+	agency := t.Route.Agency
+	if agency == nil {
+		agency = defAgency
+	}
+
+	if len(gje.Agencies) > 0 && (agency == nil || !gje.Agencies[agency.Id]) {
+		return false
+	}
+
+	return true
+}
+
+// Add the stops served by trips (and their parents) to fc. If no filter is
+// set, all stops are added.
+func (gje *GeoJSONExporter) addStops(fc *geojson.FeatureCollection, feed *gtfsparser.Feed, trips []*gtfs.Trip) {
+	stops := make(map[*gtfs.Stop]bool)
+
+	if len(gje.Routes) == 0 && len(gje.Agencies) == 0 {
+		for _, s := range feed.Stops {
+			stops[s] = true
+		}
+	} else {
+		for _, t := range trips {
+			for i := range t.StopTimes {
+				for s := t.StopTimes[i].Stop(); s != nil; s = s.Parent_station {
+					stops[s] = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]*gtfs.Stop, 0, len(stops))
+	for s := range stops {
+		if !math.IsNaN(float64(s.Lat)) && !math.IsNaN(float64(s.Lon)) {
+			sorted = append(sorted, s)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	for _, s := range sorted {
+		f := geojson.NewPointFeature([]float64{float64(s.Lon), float64(s.Lat)})
+		f.SetProperty("type", "stop")
+		f.SetProperty("stop_id", s.Id)
+		f.SetProperty("stop_name", s.Name)
+		f.SetProperty("stop_code", s.Code)
+		f.SetProperty("platform_code", s.Platform_code)
+		f.SetProperty("location_type", s.Location_type)
+		f.SetProperty("wheelchair_boarding", s.Wheelchair_boarding)
+		if s.Parent_station != nil {
+			f.SetProperty("parent_station", s.Parent_station.Id)
+		} else {
+			f.SetProperty("parent_station", "")
+		}
+		fc.AddFeature(f)
+	}
+}
+
+// Add the shapes used by trips to fc
+func (gje *GeoJSONExporter) addShapes(fc *geojson.FeatureCollection, trips []*gtfs.Trip) {
+	shpTrips := make(map[*gtfs.Shape][]string)
+	shpRoutes := make(map[*gtfs.Shape]map[string]bool)
+	shapes := make([]*gtfs.Shape, 0)
+
+	for _, t := range trips {
+		if t.Shape == nil || len(t.Shape.Points) < 2 {
+			continue
+		}
+		if _, ok := shpTrips[t.Shape]; !ok {
+			shapes = append(shapes, t.Shape)
+			shpRoutes[t.Shape] = make(map[string]bool)
+		}
+		shpTrips[t.Shape] = append(shpTrips[t.Shape], t.Id)
+		shpRoutes[t.Shape][t.Route.Id] = true
+	}
+
+	sort.Slice(shapes, func(i, j int) bool {
+		return shapes[i].Id < shapes[j].Id
+	})
+
+	for _, shp := range shapes {
+		f := geojson.NewLineStringFeature(shapeCoords(shp))
+		f.SetProperty("type", "shape")
+		f.SetProperty("shape_id", shp.Id)
+		f.SetProperty("route_ids", sortedKeys(shpRoutes[shp]))
+		f.SetProperty("trip_ids", shpTrips[shp])
+		f.SetProperty("num_trips", len(shpTrips[shp]))
+		fc.AddFeature(f)
+	}
+}
+
+// Get the distinct stop patterns of trips, per route and shape
+func (gje *GeoJSONExporter) getPatterns(trips []*gtfs.Trip) []*geoJSONPattern {
+	ret := make([]*geoJSONPattern, 0)
+	patterns := make(map[string]*geoJSONPattern)
+
+	for _, t := range trips {
+		if len(t.StopTimes) < 2 {
+			continue
+		}
+
+		shpId := ""
+		if t.Shape != nil {
+			shpId = t.Shape.Id
+		}
+
+		key := t.Route.Id + "\x00" + shpId + "\x00" + stopPatternKey(t)
+
+		p, ok := patterns[key]
+		if !ok {
+			p = &geoJSONPattern{route: t.Route, shape: t.Shape}
+			for i := range t.StopTimes {
+				p.stops = append(p.stops, t.StopTimes[i].Stop())
+			}
+			patterns[key] = p
+			ret = append(ret, p)
+		}
+
+		p.trips = append(p.trips, t.Id)
+	}
+
+	return ret
+}
+
+// Add the stop patterns to fc, as lines through the stops
+func (gje *GeoJSONExporter) addPatterns(fc *geojson.FeatureCollection, patterns []*geoJSONPattern) {
+	for _, p := range patterns {
+		stopIds := make([]string, len(p.stops))
+		for i, s := range p.stops {
+			stopIds[i] = s.Id
+		}
+
+		f := geojson.NewLineStringFeature(stopCoords(p.stops))
+		f.SetProperty("type", "pattern")
+		f.SetProperty("route_id", p.route.Id)
+		if p.shape != nil {
+			f.SetProperty("shape_id", p.shape.Id)
+		} else {
+			f.SetProperty("shape_id", "")
+		}
+		f.SetProperty("stop_ids", stopIds)
+		f.SetProperty("trip_ids", p.trips)
+		f.SetProperty("num_trips", len(p.trips))
+		fc.AddFeature(f)
+	}
+}
+
+// Add the line geometry of each route to fc. Shapes are used where
+// available, otherwise the stop patterns. Segments shared by several
+// patterns of a route (in either direction) are only added once, so the
+// geometry is split into the parts not covered by earlier patterns.
+func (gje *GeoJSONExporter) addRoutes(fc *geojson.FeatureCollection, patterns []*geoJSONPattern) {
+	routes := make([]*gtfs.Route, 0)
+	lines := make(map[*gtfs.Route][][][]float64)
+	numTrips := make(map[*gtfs.Route]int)
+
+	// segments already added, per route
+	seen := make(map[*gtfs.Route]map[string]bool)
+
+	for _, p := range patterns {
+		if _, ok := seen[p.route]; !ok {
+			routes = append(routes, p.route)
+			seen[p.route] = make(map[string]bool)
+		}
+
+		numTrips[p.route] += len(p.trips)
+
+		var line [][]float64
+		if p.shape != nil && len(p.shape.Points) > 1 {
+			line = shapeCoords(p.shape)
+		} else {
+			line = stopCoords(p.stops)
+		}
+
+		var cur [][]float64
+		for i := 1; i < len(line); i++ {
+			a, b := line[i-1], line[i]
+			if a[0] == b[0] && a[1] == b[1] {
+				continue
+			}
+
+			key := geoJSONSegKey(a, b)
+			if seen[p.route][key] {
+				if len(cur) > 1 {
+					lines[p.route] = append(lines[p.route], cur)
+				}
+				cur = nil
+				continue
+			}
+			seen[p.route][key] = true
+
+			if cur == nil {
+				cur = [][]float64{a}
+			}
+			cur = append(cur, b)
+		}
+
+		if len(cur) > 1 {
+			lines[p.route] = append(lines[p.route], cur)
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Id < routes[j].Id
+	})
+
+	for _, r := range routes {
+		f := geojson.NewMultiLineStringFeature(lines[r]...)
+		f.SetProperty("type", "route")
+		f.SetProperty("route_id", r.Id)
+		f.SetProperty("route_short_name", r.Short_name)
+		f.SetProperty("route_long_name", r.Long_name)
+		f.SetProperty("route_type", r.Type)
+		if r.Agency != nil {
+			f.SetProperty("agency_id", r.Agency.Id)
+		} else {
+			f.SetProperty("agency_id", "")
+		}
+		if len(r.Color) > 0 {
+			f.SetProperty("route_color", "#"+r.Color)
+		}
+		if len(r.Text_color) > 0 {
+			f.SetProperty("route_text_color", "#"+r.Text_color)
+		}
+		f.SetProperty("num_trips", numTrips[r])
+		fc.AddFeature(f)
+	}
+}
+
+// Get a key for the segment between a and b, independent of its direction
+func geoJSONSegKey(a, b []float64) string {
+	if a[0] > b[0] || (a[0] == b[0] && a[1] > b[1]) {
+		a, b = b, a
+	}
+	return geomKey([][2]float64{{a[0], a[1]}, {b[0], b[1]}})
+}
+
+// Get the GeoJSON coordinates (lon, lat) of a shape
+func shapeCoords(shp *gtfs.Shape) [][]float64 {
+	ret := make([][]float64, len(shp.Points))
+	for i, p := range shp.Points {
+		ret[i] = []float64{float64(p.Lon), float64(p.Lat)}
+	}
+	return ret
+}
+
+// Get the GeoJSON coordinates (lon, lat) of a sequence of stops
+func stopCoords(stops []*gtfs.Stop) [][]float64 {
+	ret := make([][]float64, len(stops))
+	for i, s := range stops {
+		lat, lon := getStopLatLon(s)
+		ret[i] = []float64{float64(lon), float64(lat)}
+	}
+	return ret
+}
+
+// Get the keys of a string set, sorted
+func sortedKeys(set map[string]bool) []string {
+	ret := make([]string, 0, len(set))
+	for k := range set {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
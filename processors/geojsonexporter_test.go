@@ -0,0 +1,94 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	geojson "github.com/paulmach/go.geojson"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoJSONExporter(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	if e := feed.Parse("./testfeed"); e != nil {
+		t.Error(e)
+		return
+	}
+
+	feed.Routes["AAMV"].Color = ""
+
+	path := filepath.Join(t.TempDir(), "out.geojson")
+
+	exp := GeoJSONExporter{Routes: map[string]bool{"AAMV": true}}
+	if err := exp.Write(feed, path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, f := range fc.Features {
+		typ := f.PropertyMustString("type")
+		counts[typ]++
+
+		if typ == "route" {
+			if f.PropertyMustString("route_id") != "AAMV" {
+				t.Error(f.Properties)
+			}
+			// both directions run along the same segment
+			if !f.Geometry.IsMultiLineString() || len(f.Geometry.MultiLineString) != 1 {
+				t.Error(f.Geometry)
+			}
+			if _, ok := f.Properties["route_color"]; ok {
+				t.Error("empty route color should be omitted")
+			}
+		}
+
+		if typ == "stop" {
+			id := f.PropertyMustString("stop_id")
+			if id != "BEATTY_AIRPORT" && id != "AMV" {
+				t.Errorf("stop %s not served by AAMV", id)
+			}
+		}
+	}
+
+	if counts["route"] != 1 || counts["stop"] != 2 || counts["pattern"] != 2 {
+		t.Error(counts)
+	}
+
+	// routes without an agency belong to the only agency of the feed
+	feed.Routes["AAMV"].Agency = nil
+
+	exp = GeoJSONExporter{Routes: map[string]bool{"AAMV": true}, Agencies: map[string]bool{"DTA": true}}
+	if err := exp.Write(feed, path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, _ = os.ReadFile(path)
+	fc, _ = geojson.UnmarshalFeatureCollection(data)
+
+	if len(fc.Features) == 0 {
+		t.Error("trips of AAMV should be exported")
+	}
+}
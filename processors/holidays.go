@@ -0,0 +1,179 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Holidays maps holiday dates to their (possibly empty) names
+type Holidays map[gtfs.Date]string
+
+// ReadHolidays reads a holiday calendar from a file. Files ending with .ics
+// are parsed as iCalendar files, where every VEVENT is a (possibly multi-day)
+// holiday. All other files are read as date lists with one YYYYMMDD (or
+// YYYY-MM-DD) date per line, optionally followed by a comma and a name.
+// Empty lines and lines starting with # are ignored.
+func ReadHolidays(path string) (Holidays, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".ics") {
+		return parseICalHolidays(lines)
+	}
+
+	return parseHolidayList(lines)
+}
+
+// IsHoliday returns true if d is a holiday
+func (h Holidays) IsHoliday(d gtfs.Date) bool {
+	_, ok := h[d]
+	return ok
+}
+
+// Parse a list of holiday dates
+func parseHolidayList(lines []string) (Holidays, error) {
+	ret := make(Holidays)
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		name := ""
+		parts := strings.SplitN(line, ",", 2)
+		if len(parts) > 1 {
+			name = strings.TrimSpace(parts[1])
+		}
+
+		d, err := parseHolidayDate(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+
+		ret[d] = name
+	}
+
+	return ret, nil
+}
+
+// Parse the VEVENTs of an iCalendar file as holidays. Recurrence rules
+// are not expanded, each occurrence has to be given explicitly.
+func parseICalHolidays(lines []string) (Holidays, error) {
+	ret := make(Holidays)
+
+	// unfold continuation lines
+	unfolded := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(unfolded) > 0 {
+			unfolded[len(unfolded)-1] += line[1:]
+			continue
+		}
+		unfolded = append(unfolded, line)
+	}
+
+	inEvent := false
+	var start, end gtfs.Date
+	name := ""
+
+	for i, line := range unfolded {
+		key, val, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		// strip parameters like DTSTART;VALUE=DATE
+		key = strings.ToUpper(strings.SplitN(key, ";", 2)[0])
+
+		switch key {
+		case "BEGIN":
+			if strings.ToUpper(val) == "VEVENT" {
+				inEvent = true
+				start = gtfs.Date{}
+				end = gtfs.Date{}
+				name = ""
+			}
+		case "DTSTART", "DTEND":
+			if !inEvent {
+				continue
+			}
+			if len(val) < 8 {
+				return nil, fmt.Errorf("line %d: invalid date '%s'", i+1, val)
+			}
+			d, err := parseHolidayDate(val[:8])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+			}
+			if key == "DTSTART" {
+				start = d
+			} else {
+				end = d
+			}
+		case "SUMMARY":
+			if inEvent {
+				name = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(val)
+			}
+		case "END":
+			if strings.ToUpper(val) != "VEVENT" || !inEvent {
+				continue
+			}
+			inEvent = false
+
+			if start.IsEmpty() {
+				return nil, fmt.Errorf("line %d: event without DTSTART", i+1)
+			}
+
+			ret[start] = name
+
+			// DTEND is exclusive for all-day events
+			for d := start.GetOffsettedDate(1); !end.IsEmpty() && d.GetTime().Before(end.GetTime()); d = d.GetOffsettedDate(1) {
+				ret[d] = name
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// Parse a YYYYMMDD or YYYY-MM-DD date
+func parseHolidayDate(str string) (gtfs.Date, error) {
+	str = strings.Replace(str, "-", "", -1)
+
+	if len(str) != 8 {
+		return gtfs.Date{}, fmt.Errorf("expected YYYYMMDD date, found '%s'", str)
+	}
+
+	year, e1 := strconv.Atoi(str[0:4])
+	month, e2 := strconv.Atoi(str[4:6])
+	day, e3 := strconv.Atoi(str[6:8])
+
+	if e1 != nil || e2 != nil || e3 != nil || day < 1 || day > 31 || month < 1 || month > 12 || year < 1900 || year > 1900+255 {
+		return gtfs.Date{}, fmt.Errorf("expected YYYYMMDD date, found '%s'", str)
+	}
+
+	return gtfs.NewDate(uint8(day), uint8(month), uint16(year)), nil
+}
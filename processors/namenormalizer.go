@@ -0,0 +1,294 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// the fields NameNormalizer works on
+var nameFields = []string{"stop_name", "route_long_name", "trip_headsign", "agency_name"}
+
+// NameRules hold the rules of a NameNormalizer, per field
+type NameRules struct {
+	// case normalization, "title", "upper" or "lower"
+	Case map[string]string
+
+	// words which keep the given spelling on case normalization, by their
+	// upper-case form
+	Keep map[string]string
+
+	// whole-word replacements (abbreviation expansion or contraction)
+	Words map[string][]nameRule
+
+	// regular expression substitutions
+	Regexes map[string][]nameRule
+}
+
+type nameRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// ReadNameRules reads normalization rules from a CSV file, one rule per
+// line. <field> is one of stop_name, route_long_name, trip_headsign,
+// agency_name, or * for all of them.
+//
+//	case,<field>,title|upper|lower
+//	keep,<word>,<word>,...
+//	expand,<field>,<abbreviation>,<expansion>
+//	contract,<field>,<expansion>,<abbreviation>
+//	regex,<field>,<pattern>,<replacement>
+//
+// Case normalization only applies to names which are entirely upper- or
+// lower-case, words given by keep rules retain their spelling. Expansions
+// and contractions match whole words, case-insensitive, with an optional
+// trailing dot. Regular expressions use the Go syntax, replacements may
+// refer to groups by $1, $2, ... Per name, case normalization is applied
+// first, then expansions and contractions, then regular expressions, in
+// the order of the file. Fields containing commas must be quoted. Lines
+// starting with # are ignored.
+func ReadNameRules(path string) (*NameRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := &NameRules{Case: make(map[string]string), Keep: make(map[string]string), Words: make(map[string][]nameRule), Regexes: make(map[string][]nameRule)}
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if len(rec) == 0 || (len(rec) == 1 && len(strings.TrimSpace(rec[0])) == 0) {
+			continue
+		}
+
+		rule := strings.ToLower(strings.TrimSpace(rec[0]))
+
+		if rule == "keep" {
+			for _, w := range rec[1:] {
+				if w = strings.TrimSpace(w); len(w) > 0 {
+					ret.Keep[strings.ToUpper(w)] = w
+				}
+			}
+			continue
+		}
+
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("line %d: %s rule needs a field and arguments", line, rule)
+		}
+
+		fields, err := nameRuleFields(strings.TrimSpace(rec[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		switch rule {
+		case "case":
+			mode := strings.ToLower(strings.TrimSpace(rec[2]))
+			if mode != "title" && mode != "upper" && mode != "lower" {
+				return nil, fmt.Errorf("line %d: unknown case '%s'", line, rec[2])
+			}
+			for _, f := range fields {
+				ret.Case[f] = mode
+			}
+		case "expand", "contract":
+			if len(rec) != 4 {
+				return nil, fmt.Errorf("line %d: %s rule needs a field, a word and its replacement", line, rule)
+			}
+			from := strings.TrimSuffix(strings.TrimSpace(rec[2]), ".")
+			re, err := regexp.Compile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(from) + `\.?($|[^\pL\pN])`)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			for _, f := range fields {
+				ret.Words[f] = append(ret.Words[f], nameRule{re, strings.TrimSpace(rec[3])})
+			}
+		case "regex":
+			if len(rec) != 4 {
+				return nil, fmt.Errorf("line %d: regex rule needs a field, a pattern and a replacement", line)
+			}
+			re, err := regexp.Compile(rec[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			for _, f := range fields {
+				ret.Regexes[f] = append(ret.Regexes[f], nameRule{re, rec[3]})
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule '%s'", line, rec[0])
+		}
+	}
+
+	return ret, nil
+}
+
+// Get the fields a rule applies to
+func nameRuleFields(f string) ([]string, error) {
+	if f == "*" {
+		return nameFields, nil
+	}
+	for _, nf := range nameFields {
+		if nf == f {
+			return []string{f}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown field '%s', supported are %s", f, strings.Join(nameFields, ","))
+}
+
+// NameNormalizer normalizes names according to a set of rules. If DryRun
+// is set, the feed is not changed. Every change is written to ReportFile.
+type NameNormalizer struct {
+	Rules      *NameRules
+	DryRun     bool
+	ReportFile string
+}
+
+// Run this NameNormalizer on some feed
+func (nn NameNormalizer) Run(feed *gtfsparser.Feed) {
+	if nn.DryRun {
+		fmt.Fprintf(os.Stdout, "Normalizing names (dry run)... ")
+	} else {
+		fmt.Fprintf(os.Stdout, "Normalizing names... ")
+	}
+
+	report := NewReport("field", "id", "old", "new")
+	n := 0
+
+	// normalize a name, returns the new name
+	norm := func(field string, id string, name string) string {
+		ret := nn.Rules.apply(field, name)
+		if ret == name {
+			return name
+		}
+		report.Add(field, id, name, ret)
+		n++
+		if nn.DryRun {
+			return name
+		}
+		return ret
+	}
+
+	for _, s := range feed.Stops {
+		s.Name = norm("stop_name", s.Id, s.Name)
+	}
+
+	for _, r := range feed.Routes {
+		r.Long_name = norm("route_long_name", r.Id, r.Long_name)
+	}
+
+	for _, a := range feed.Agencies {
+		a.Name = norm("agency_name", a.Id, a.Name)
+	}
+
+	for _, t := range feed.Trips {
+		if t.Headsign == nil {
+			continue
+		}
+		// headsigns may point to strings shared with other entities
+		if hs := norm("trip_headsign", t.Id, *t.Headsign); hs != *t.Headsign {
+			t.Headsign = &hs
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d names changed%s)\n", n, report.writeIfRequested(nn.ReportFile))
+}
+
+// Apply the rules for field to name. If a rule changed the name,
+// whitespace is collapsed, as removals may leave duplicate spaces.
+func (r *NameRules) apply(field string, name string) string {
+	if len(name) == 0 {
+		return name
+	}
+
+	orig := name
+
+	if mode, ok := r.Case[field]; ok {
+		name = r.normCase(name, mode)
+	}
+
+	for _, w := range r.Words[field] {
+		name = w.re.ReplaceAllString(name, "${1}"+strings.ReplaceAll(w.repl, "$", "$$")+"${2}")
+	}
+
+	for _, re := range r.Regexes[field] {
+		name = re.re.ReplaceAllString(name, re.repl)
+	}
+
+	if name == orig {
+		return orig
+	}
+
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// Normalize the case of name, if it is entirely upper- or lower-case
+func (r *NameRules) normCase(name string, mode string) string {
+	hasUpper := strings.IndexFunc(name, unicode.IsUpper) > -1
+	hasLower := strings.IndexFunc(name, unicode.IsLower) > -1
+
+	if hasUpper && hasLower {
+		return name
+	}
+
+	var b strings.Builder
+	word := make([]rune, 0)
+
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := string(word)
+		if keep, ok := r.Keep[strings.ToUpper(w)]; ok {
+			b.WriteString(keep)
+		} else {
+			switch mode {
+			case "upper":
+				b.WriteString(strings.ToUpper(w))
+			case "lower":
+				b.WriteString(strings.ToLower(w))
+			default:
+				b.WriteString(strings.ToUpper(string(word[0])) + strings.ToLower(string(word[1:])))
+			}
+		}
+		word = word[:0]
+	}
+
+	for _, c := range name {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			word = append(word, c)
+		} else {
+			flush()
+			b.WriteRune(c)
+		}
+	}
+
+	flush()
+
+	return b.String()
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestNameNormalizer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "namenormalizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := `# test rules
+case,*,title
+keep,DB,ZOB,am
+expand,stop_name,Hbf,Hauptbahnhof
+expand,*,Str.,Straße
+contract,route_long_name,Hauptbahnhof,Hbf
+regex,stop_name,"\s*\(Gleis \d+\)$",""
+regex,trip_headsign,"^Freiburg, ",""
+`
+
+	rulesFile := path.Join(dir, "rules.csv")
+	os.WriteFile(rulesFile, []byte(rules), 0644)
+
+	nr, err := ReadNameRules(rulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newFeed := func() *gtfsparser.Feed {
+		feed := gtfsparser.NewFeed()
+		feed.Stops["a"] = &gtfs.Stop{Id: "a", Name: "FREIBURG HBF (GLEIS 3)"}
+		feed.Stops["b"] = &gtfs.Stop{Id: "b", Name: "ZOB AM BERLINER STR."}
+		feed.Stops["c"] = &gtfs.Stop{Id: "c", Name: "Hauptstr."}
+		feed.Stops["d"] = &gtfs.Stop{Id: "d", Name: "Mixed Case Hbf"}
+		feed.Stops["e"] = &gtfs.Stop{Id: "e", Name: "Mixed  Case"}
+		feed.Routes["r"] = &gtfs.Route{Id: "r", Long_name: "Hauptbahnhof - Messe"}
+		feed.Agencies["ag"] = &gtfs.Agency{Id: "ag", Name: "DB REGIO"}
+		hs := "FREIBURG, PAULUS STR."
+		feed.Trips["t"] = &gtfs.Trip{Id: "t", Headsign: &hs}
+		return feed
+	}
+
+	feed := newFeed()
+	NameNormalizer{Rules: nr, ReportFile: path.Join(dir, "names.csv")}.Run(feed)
+
+	exp := map[string]string{
+		"a": "Freiburg Hauptbahnhof",
+		"b": "ZOB am Berliner Straße",
+		"c": "Hauptstr.",
+		"d": "Mixed Case Hauptbahnhof",
+		"e": "Mixed  Case", // no rule applies, whitespace is kept
+	}
+
+	for id, name := range exp {
+		if feed.Stops[id].Name != name {
+			t.Errorf("expected '%s', got '%s'", name, feed.Stops[id].Name)
+		}
+	}
+
+	if feed.Routes["r"].Long_name != "Hbf - Messe" {
+		t.Errorf("expected 'Hbf - Messe', got '%s'", feed.Routes["r"].Long_name)
+	}
+
+	if feed.Agencies["ag"].Name != "DB Regio" {
+		t.Errorf("expected 'DB Regio', got '%s'", feed.Agencies["ag"].Name)
+	}
+
+	if *feed.Trips["t"].Headsign != "Paulus Straße" {
+		t.Errorf("expected 'Paulus Straße', got '%s'", *feed.Trips["t"].Headsign)
+	}
+
+	content, err := os.ReadFile(path.Join(dir, "names.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), "stop_name,a,FREIBURG HBF (GLEIS 3),Freiburg Hauptbahnhof") {
+		t.Errorf("expected change of stop a in report:\n%s", content)
+	}
+
+	// dry run
+	feed = newFeed()
+	NameNormalizer{Rules: nr, DryRun: true}.Run(feed)
+
+	if feed.Stops["a"].Name != "FREIBURG HBF (GLEIS 3)" {
+		t.Error("dry run changed the feed")
+	}
+}
+
+func TestReadNameRulesErrors(t *testing.T) {
+	dir, err := os.MkdirTemp("", "namerules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, rules := range []string{"case,stop_desc,title\n", "case,*,sentence\n", "regex,*,\"(\",x\n", "foo,*,x\n"} {
+		p := path.Join(dir, "rules.csv")
+		os.WriteFile(p, []byte(rules), 0644)
+		if _, err := ReadNameRules(p); err == nil {
+			t.Errorf("expected error for rules '%s'", strings.TrimSpace(rules))
+		}
+	}
+}
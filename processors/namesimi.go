@@ -0,0 +1,330 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// NameSimiConfig configures the name similarity used by StopReclusterer.
+// Names are upper-cased, optionally folded to their base letters, split into
+// tokens, stop words are dropped and abbreviations and synonyms are replaced
+// by their canonical form. If JaroWinklerWeight is > 0, the TF-IDF cosine
+// similarity is blended with the Jaro-Winkler similarity of the normalized
+// names (with sorted tokens, to be independent of the word order).
+type NameSimiConfig struct {
+	Synonyms          map[string]string
+	StopWords         map[string]bool
+	FoldDiacritics    bool
+	JaroWinklerWeight float64
+}
+
+type nameSimiLang struct {
+	synonyms  map[string][]string // canonical form to variants
+	stopWords []string
+}
+
+// built-in abbreviation, synonym and stop word tables, all upper-cased and
+// folded
+var nameSimiLangs = map[string]nameSimiLang{
+	"de": {
+		synonyms: map[string][]string{
+			"HAUPTBAHNHOF": {"HBF", "HBHF"},
+			"BAHNHOF":      {"BF", "BHF"},
+			"BUSBAHNHOF":   {"BUSBF", "ZOB", "ZOH"},
+			"STRASSE":      {"STR"},
+			"PLATZ":        {"PL", "PLZ"},
+			"ST":           {"SANKT"},
+			"BERG":         {"BG"},
+			"FRIEDHOF":     {"FRIEDH"},
+			"KRANKENHAUS":  {"KH"},
+			"SCHULE":       {"SCH"},
+			"BRUECKE":      {"BRUCKE", "BR"},
+			"MARKT":        {"MKT"},
+		},
+		stopWords: []string{"AM", "AN", "BEI", "DER", "DIE", "DAS", "DEN", "DEM", "IM", "IN", "VON", "VOM", "ZUM", "ZUR", "UND", "U", "A", "D", "I"},
+	},
+	"fr": {
+		synonyms: map[string][]string{
+			"ST":        {"SAINT"},
+			"STE":       {"SAINTE"},
+			"AVENUE":    {"AV", "AVE"},
+			"BOULEVARD": {"BD", "BLVD"},
+			"PLACE":     {"PL"},
+			"GARE":      {"GARE SNCF"},
+			"RUE":       {"R"},
+			"EGLISE":    {"EGL"},
+			"HOPITAL":   {"HOP", "CHU"},
+			"CENTRE":    {"CTRE", "CENTRE VILLE"},
+			"ROUTE":     {"RTE"},
+			"CHEMIN":    {"CH", "CHE"},
+			"MAIRIE":    {"HOTEL DE VILLE"},
+		},
+		stopWords: []string{"DE", "DU", "DES", "LA", "LE", "LES", "L", "D", "ET", "A", "AU", "AUX", "EN", "SUR"},
+	},
+	"en": {
+		// ST is left out, it abbreviates both SAINT and STREET
+		synonyms: map[string][]string{
+			"AVENUE":   {"AVE", "AV"},
+			"ROAD":     {"RD"},
+			"STATION":  {"STN", "STA"},
+			"SQUARE":   {"SQ"},
+			"CENTER":   {"CTR", "CENTRE"},
+			"HOSPITAL": {"HOSP"},
+			"NORTH":    {"N"},
+			"SOUTH":    {"S"},
+			"EAST":     {"E"},
+			"WEST":     {"W"},
+			"DRIVE":    {"DR"},
+			"LANE":     {"LN"},
+			"PLACE":    {"PL"},
+			"MOUNT":    {"MT"},
+		},
+		stopWords: []string{"THE", "OF", "AT", "AND", "ON"},
+	},
+}
+
+// NameSimiLanguages returns the languages with built-in tables
+func NameSimiLanguages() []string {
+	ret := make([]string, 0, len(nameSimiLangs))
+	for l := range nameSimiLangs {
+		ret = append(ret, l)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// MakeNameSimiConfig creates a NameSimiConfig with the built-in tables of the
+// given languages. Later languages take precedence.
+func MakeNameSimiConfig(langs []string, fold bool) (*NameSimiConfig, error) {
+	ret := &NameSimiConfig{Synonyms: make(map[string]string), StopWords: make(map[string]bool), FoldDiacritics: fold}
+
+	for _, l := range langs {
+		lang, ok := nameSimiLangs[strings.ToLower(l)]
+		if !ok {
+			return nil, fmt.Errorf("unknown name similarity language '%s', supported are %s", l, strings.Join(NameSimiLanguages(), ","))
+		}
+
+		for canonical, variants := range lang.synonyms {
+			ret.addSynonyms(canonical, variants)
+		}
+
+		for _, w := range lang.stopWords {
+			ret.StopWords[w] = true
+		}
+	}
+
+	return ret, nil
+}
+
+// ReadFile adds the rules from a file to this config. Each line holds a
+// comma-separated rule, either
+//
+//	synonym,<canonical>,<variant>,<variant>,...
+//	stopword,<word>,<word>,...
+//
+// Empty lines and lines starting with # are ignored.
+func (c *NameSimiConfig) ReadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "synonym":
+			if len(fields) < 3 {
+				return fmt.Errorf("line %d: synonym rule needs a canonical form and at least one variant", i)
+			}
+			c.addSynonyms(fields[1], fields[2:])
+		case "stopword":
+			for _, w := range fields[1:] {
+				c.StopWords[c.normalizeWord(w)] = true
+			}
+		default:
+			return fmt.Errorf("line %d: unknown rule '%s'", i, fields[0])
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Add variants of a canonical form. Multi-word variants are joined, as
+// tokens are matched after splitting.
+func (c *NameSimiConfig) addSynonyms(canonical string, variants []string) {
+	can := c.normalizeWord(canonical)
+	c.Synonyms[can] = can
+	for _, v := range variants {
+		c.Synonyms[c.normalizeWord(v)] = can
+	}
+}
+
+// Normalize a single word or phrase of a rule
+func (c *NameSimiConfig) normalizeWord(w string) string {
+	w = strings.ToUpper(w)
+	if c.FoldDiacritics {
+		w = foldDiacritics(w)
+	}
+	return strings.Join(strings.Fields(w), " ")
+}
+
+// Apply the config to the raw tokens of a name
+func (c *NameSimiConfig) apply(tokens []string) []string {
+	ret := make([]string, 0, len(tokens))
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if c.FoldDiacritics {
+			tok = foldDiacritics(tok)
+		}
+
+		// phrases of up to three words like "HOTEL DE VILLE" are matched first
+		if i+2 < len(tokens) {
+			if can, ok := c.Synonyms[tok+" "+c.normalizeWord(tokens[i+1])+" "+c.normalizeWord(tokens[i+2])]; ok {
+				ret = append(ret, can)
+				i += 2
+				continue
+			}
+		}
+
+		if i+1 < len(tokens) {
+			if can, ok := c.Synonyms[tok+" "+c.normalizeWord(tokens[i+1])]; ok {
+				ret = append(ret, can)
+				i++
+				continue
+			}
+		}
+
+		if can, ok := c.Synonyms[tok]; ok {
+			tok = can
+		}
+
+		if c.StopWords[tok] {
+			continue
+		}
+
+		ret = append(ret, tok)
+	}
+
+	return ret
+}
+
+var diacriticFolds = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Ā': "A", 'Ă': "A", 'Ą': "A",
+	'Æ': "AE", 'Ç': "C", 'Ć': "C", 'Č': "C", 'Ď': "D", 'Đ': "D",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ē': "E", 'Ė': "E", 'Ę': "E", 'Ě': "E",
+	'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I", 'Ī': "I", 'Į': "I", 'İ': "I",
+	'Ł': "L", 'Ñ': "N", 'Ń': "N", 'Ň': "N",
+	'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O", 'Ō': "O", 'Ő': "O", 'Œ': "OE",
+	'Ř': "R", 'Ś': "S", 'Š': "S", 'Ş': "S", 'ß': "SS", 'ẞ': "SS", 'Ť': "T", 'Ţ': "T",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ū': "U", 'Ů': "U", 'Ű': "U", 'Ų': "U",
+	'Ý': "Y", 'Ÿ': "Y", 'Ź': "Z", 'Ż': "Z", 'Ž': "Z",
+}
+
+// Fold the (upper-case) letters with diacritics in s to their base letters
+func foldDiacritics(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if f, ok := diacriticFolds[r]; ok {
+			b.WriteString(f)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Jaro-Winkler similarity of two strings, in [0, 1]
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	window := len(ra)
+	if len(rb) > window {
+		window = len(rb)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matchA := make([]bool, len(ra))
+	matchB := make([]bool, len(rb))
+	matches := 0
+
+	for i := range ra {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(rb) {
+			hi = len(rb)
+		}
+		for j := lo; j < hi; j++ {
+			if !matchB[j] && ra[i] == rb[j] {
+				matchA[i] = true
+				matchB[j] = true
+				matches++
+				break
+			}
+		}
+	}
+
+	if matches == 0 {
+		return 0
+	}
+
+	// count transpositions
+	trans := 0
+	j := 0
+	for i := range ra {
+		if !matchA[i] {
+			continue
+		}
+		for !matchB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			trans++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(trans)/2)/m) / 3
+
+	// common prefix of up to 4 characters
+	prefix := 0
+	for prefix < 4 && prefix < len(ra) && prefix < len(rb) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNameSimiConfigApply(t *testing.T) {
+	c, err := MakeNameSimiConfig([]string{"de", "fr"}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := map[string]string{
+		"FREIBURG HBF":               "FREIBURG HAUPTBAHNHOF",
+		"MÜNCHEN BF":                 "MUNCHEN BAHNHOF",
+		"ST ÉTIENNE HÔTEL DE VILLE":  "ST ETIENNE MAIRIE",
+		"SAINT ETIENNE GARE SNCF":    "ST ETIENNE GARE",
+		"PLACE DE LA RÉPUBLIQUE":     "PLACE REPUBLIQUE",
+		"HAUPTSTRASSE AN DER BRÜCKE": "HAUPTSTRASSE BRUECKE",
+		"GROSSE STRASSE":             "GROSSE STRASSE",
+		"GROßE STR":                  "GROSSE STRASSE",
+	}
+
+	for in, exp := range tests {
+		got := strings.Join(c.apply(strings.Fields(in)), " ")
+		if got != exp {
+			t.Errorf("%s: expected '%s', got '%s'", in, exp, got)
+		}
+	}
+
+	if _, err := MakeNameSimiConfig([]string{"xx"}, true); err == nil {
+		t.Error("expected error for unknown language")
+	}
+
+	// ST may be SAINT or STREET, and folding is optional
+	c, _ = MakeNameSimiConfig([]string{"en"}, false)
+
+	tests = map[string]string{
+		"MAIN ST":          "MAIN ST",
+		"ST PANCRAS STN":   "ST PANCRAS STATION",
+		"PLACE RÉPUBLIQUE": "PLACE RÉPUBLIQUE",
+	}
+
+	for in, exp := range tests {
+		got := strings.Join(c.apply(strings.Fields(in)), " ")
+		if got != exp {
+			t.Errorf("%s: expected '%s', got '%s'", in, exp, got)
+		}
+	}
+}
+
+func TestNameSimiConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "simi.txt")
+	os.WriteFile(path, []byte("# custom rules\nsynonym,Flughafen,FH,Airport\nstopword,Terminal\n"), 0644)
+
+	c, _ := MakeNameSimiConfig(nil, true)
+	if err := c.ReadFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := strings.Join(c.apply([]string{"AIRPORT", "TERMINAL", "FH"}), " "); got != "FLUGHAFEN FLUGHAFEN" {
+		t.Error(got)
+	}
+
+	os.WriteFile(path, []byte("replace,a,b\n"), 0644)
+	if err := c.ReadFile(path); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	if s := jaroWinkler("MARTHA", "MARHTA"); math.Abs(s-0.9611) > 0.001 {
+		t.Error(s)
+	}
+
+	if s := jaroWinkler("DIXON", "DICKSONX"); math.Abs(s-0.8133) > 0.001 {
+		t.Error(s)
+	}
+
+	if jaroWinkler("ABC", "ABC") != 1 || jaroWinkler("ABC", "") != 0 {
+		t.Error("identity")
+	}
+}
+
+func TestStopReclustererNameSimi(t *testing.T) {
+	mkFeed := func() *gtfsparser.Feed {
+		feed := gtfsparser.NewFeed()
+		mk := func(id, name string, lat, lon float32) {
+			feed.Stops[id] = &gtfs.Stop{Id: id, Name: name, Lat: lat, Lon: lon}
+		}
+
+		mk("a1", "Freiburg Hbf", 48.0, 7.8)
+		mk("a2", "Freiburg (Breisgau) Hauptbahnhof", 48.0, 7.8003)
+		mk("b1", "Freiburg Messe", 48.1, 7.9)
+		mk("b2", "Freiburg Stadion", 48.2, 7.9)
+		mk("b3", "Freiburg Zoo", 48.3, 7.9)
+		return feed
+	}
+
+	feed := mkFeed()
+	StopReclusterer{DistThreshold: 75, NameSimiThreshold: 0.55, GridCellSize: 10000}.Run(feed)
+
+	if feed.Stops["a1"].Parent_station != nil {
+		t.Error("without name similarity config, a1 and a2 should not be clustered")
+	}
+
+	c, _ := MakeNameSimiConfig([]string{"de"}, true)
+	c.JaroWinklerWeight = 0.2
+
+	feed = mkFeed()
+	StopReclusterer{DistThreshold: 75, NameSimiThreshold: 0.55, GridCellSize: 10000, NameSimi: c}.Run(feed)
+
+	if feed.Stops["a1"].Parent_station == nil || feed.Stops["a1"].Parent_station != feed.Stops["a2"].Parent_station {
+		t.Error("a1 and a2 should be clustered")
+	}
+}
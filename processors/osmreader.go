@@ -0,0 +1,541 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OsmWay is a single way read from an OSM file
+type OsmWay struct {
+	Id    int64
+	Nodes []int64
+	Tags  map[string]string
+}
+
+// OsmData holds the ways read from an OSM file, together with the
+// coordinates (lat, lon) of all nodes they reference
+type OsmData struct {
+	Ways  []OsmWay
+	Nodes map[int64][2]float64
+}
+
+// ReadOsm reads all ways for which keep returns true, and the nodes
+// referenced by them, from an OSM file. Files ending with .pbf are read
+// as OSM PBF files, all other files as OSM XML.
+func ReadOsm(path string, keep func(tags map[string]string) bool) (*OsmData, error) {
+	ret := &OsmData{Ways: make([]OsmWay, 0), Nodes: make(map[int64][2]float64)}
+
+	pbf := strings.HasSuffix(strings.ToLower(path), ".pbf")
+
+	handleWay := func(id int64, refs []int64, tags map[string]string) {
+		if !keep(tags) {
+			return
+		}
+		ret.Ways = append(ret.Ways, OsmWay{id, refs, tags})
+		for _, ref := range refs {
+			ret.Nodes[ref] = [2]float64{}
+		}
+	}
+
+	handleNode := func(id int64, lat float64, lon float64) {
+		if _, ok := ret.Nodes[id]; ok {
+			ret.Nodes[id] = [2]float64{lat, lon}
+		}
+	}
+
+	// first pass: ways, second pass: the nodes referenced by them
+	for pass := 0; pass < 2; pass++ {
+		var err error
+		wh, nh := handleWay, handleNode
+		if pass == 0 {
+			nh = nil
+		} else {
+			wh = nil
+		}
+
+		if pbf {
+			err = readOsmPbf(path, wh, nh)
+		} else {
+			err = readOsmXml(path, wh, nh)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// drop references to nodes missing in the extract
+	for i := range ret.Ways {
+		nodes := make([]int64, 0, len(ret.Ways[i].Nodes))
+		for _, ref := range ret.Ways[i].Nodes {
+			if c := ret.Nodes[ref]; c[0] != 0 || c[1] != 0 {
+				nodes = append(nodes, ref)
+			}
+		}
+		ret.Ways[i].Nodes = nodes
+	}
+
+	return ret, nil
+}
+
+// Read an OSM XML file, calling handleWay for every way and handleNode for
+// every node. Both handlers may be nil.
+func readOsmXml(path string, handleWay func(int64, []int64, map[string]string), handleNode func(int64, float64, float64)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := xml.NewDecoder(bufio.NewReader(file))
+
+	var wayId int64
+	var refs []int64
+	var tags map[string]string
+	inWay := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "node":
+				if handleNode == nil {
+					continue
+				}
+				var id int64
+				var lat, lon float64
+				for _, a := range el.Attr {
+					switch a.Name.Local {
+					case "id":
+						id, err = strconv.ParseInt(a.Value, 10, 64)
+					case "lat":
+						lat, err = strconv.ParseFloat(a.Value, 64)
+					case "lon":
+						lon, err = strconv.ParseFloat(a.Value, 64)
+					}
+					if err != nil {
+						return fmt.Errorf("invalid node attribute '%s': %s", a.Name.Local, err.Error())
+					}
+				}
+				handleNode(id, lat, lon)
+			case "way":
+				if handleWay == nil {
+					continue
+				}
+				inWay = true
+				refs = make([]int64, 0)
+				tags = make(map[string]string)
+				for _, a := range el.Attr {
+					if a.Name.Local == "id" {
+						wayId, err = strconv.ParseInt(a.Value, 10, 64)
+						if err != nil {
+							return fmt.Errorf("invalid way id '%s'", a.Value)
+						}
+					}
+				}
+			case "nd":
+				if !inWay {
+					continue
+				}
+				for _, a := range el.Attr {
+					if a.Name.Local == "ref" {
+						ref, err := strconv.ParseInt(a.Value, 10, 64)
+						if err != nil {
+							return fmt.Errorf("invalid node reference '%s'", a.Value)
+						}
+						refs = append(refs, ref)
+					}
+				}
+			case "tag":
+				if !inWay {
+					continue
+				}
+				k, v := "", ""
+				for _, a := range el.Attr {
+					if a.Name.Local == "k" {
+						k = a.Value
+					} else if a.Name.Local == "v" {
+						v = a.Value
+					}
+				}
+				tags[k] = v
+			}
+		case xml.EndElement:
+			if el.Name.Local == "way" && inWay {
+				inWay = false
+				handleWay(wayId, refs, tags)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Read an OSM PBF file, calling handleWay for every way and handleNode for
+// every node. Both handlers may be nil.
+func readOsmPbf(path string, handleWay func(int64, []int64, map[string]string), handleNode func(int64, float64, float64)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	lenBuf := make([]byte, 4)
+
+	for {
+		if _, err := io.ReadFull(r, lenBuf); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		headerBuf := make([]byte, binary.BigEndian.Uint32(lenBuf))
+		if _, err := io.ReadFull(r, headerBuf); err != nil {
+			return err
+		}
+
+		// BlobHeader
+		blobType := ""
+		dataSize := 0
+		hr := pbfReader{buf: headerBuf}
+		for hr.more() {
+			field, wt := hr.key()
+			switch field {
+			case 1:
+				blobType = string(hr.bytes())
+			case 3:
+				dataSize = int(hr.varint())
+			default:
+				hr.skip(wt)
+			}
+		}
+
+		if hr.err != nil {
+			return hr.err
+		}
+
+		blobBuf := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, blobBuf); err != nil {
+			return err
+		}
+
+		if blobType != "OSMData" {
+			continue
+		}
+
+		data, err := pbfBlobData(blobBuf)
+		if err != nil {
+			return err
+		}
+
+		if err := pbfPrimitiveBlock(data, handleWay, handleNode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the uncompressed content of a PBF blob
+func pbfBlobData(blob []byte) ([]byte, error) {
+	br := pbfReader{buf: blob}
+	for br.more() {
+		field, wt := br.key()
+		switch field {
+		case 1:
+			return br.bytes(), br.err
+		case 3:
+			zr, err := zlib.NewReader(bytes.NewReader(br.bytes()))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(zr)
+		case 4, 5, 6, 7:
+			return nil, errors.New("unsupported PBF blob compression")
+		default:
+			br.skip(wt)
+		}
+	}
+
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	return nil, errors.New("empty PBF blob")
+}
+
+// Parse a PBF PrimitiveBlock
+func pbfPrimitiveBlock(data []byte, handleWay func(int64, []int64, map[string]string), handleNode func(int64, float64, float64)) error {
+	strs := make([]string, 0)
+	groups := make([][]byte, 0)
+	granularity := int64(100)
+	latOffset := int64(0)
+	lonOffset := int64(0)
+
+	r := pbfReader{buf: data}
+	for r.more() {
+		field, wt := r.key()
+		switch field {
+		case 1:
+			sr := pbfReader{buf: r.bytes()}
+			for sr.more() {
+				f, swt := sr.key()
+				if f == 1 {
+					strs = append(strs, string(sr.bytes()))
+				} else {
+					sr.skip(swt)
+				}
+			}
+			if sr.err != nil {
+				return sr.err
+			}
+		case 2:
+			groups = append(groups, r.bytes())
+		case 17:
+			granularity = int64(r.varint())
+		case 19:
+			latOffset = int64(r.varint())
+		case 20:
+			lonOffset = int64(r.varint())
+		default:
+			r.skip(wt)
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+
+	coord := func(offset int64, v int64) float64 {
+		return 1e-9 * float64(offset+granularity*v)
+	}
+
+	for _, g := range groups {
+		gr := pbfReader{buf: g}
+		for gr.more() {
+			field, wt := gr.key()
+			switch field {
+			case 1:
+				// plain node
+				nr := pbfReader{buf: gr.bytes()}
+				if handleNode == nil {
+					continue
+				}
+				var id, lat, lon int64
+				for nr.more() {
+					f, nwt := nr.key()
+					switch f {
+					case 1:
+						id = nr.svarint()
+					case 8:
+						lat = nr.svarint()
+					case 9:
+						lon = nr.svarint()
+					default:
+						nr.skip(nwt)
+					}
+				}
+				if nr.err != nil {
+					return nr.err
+				}
+				handleNode(id, coord(latOffset, lat), coord(lonOffset, lon))
+			case 2:
+				// dense nodes
+				dr := pbfReader{buf: gr.bytes()}
+				if handleNode == nil {
+					continue
+				}
+				var ids, lats, lons []int64
+				for dr.more() {
+					f, dwt := dr.key()
+					switch f {
+					case 1:
+						ids = dr.packedSvarints()
+					case 8:
+						lats = dr.packedSvarints()
+					case 9:
+						lons = dr.packedSvarints()
+					default:
+						dr.skip(dwt)
+					}
+				}
+				if dr.err != nil {
+					return dr.err
+				}
+				if len(lats) != len(ids) || len(lons) != len(ids) {
+					return errors.New("invalid dense nodes in PBF")
+				}
+				var id, lat, lon int64
+				for i := range ids {
+					id += ids[i]
+					lat += lats[i]
+					lon += lons[i]
+					handleNode(id, coord(latOffset, lat), coord(lonOffset, lon))
+				}
+			case 3:
+				wr := pbfReader{buf: gr.bytes()}
+				if handleWay == nil {
+					continue
+				}
+				var id int64
+				var keys, vals []uint64
+				var refs []int64
+				for wr.more() {
+					f, wwt := wr.key()
+					switch f {
+					case 1:
+						id = int64(wr.varint())
+					case 2:
+						keys = wr.packedVarints()
+					case 3:
+						vals = wr.packedVarints()
+					case 8:
+						refs = wr.packedSvarints()
+					default:
+						wr.skip(wwt)
+					}
+				}
+				if wr.err != nil {
+					return wr.err
+				}
+				tags := make(map[string]string, len(keys))
+				for i := range keys {
+					if i >= len(vals) || keys[i] >= uint64(len(strs)) || vals[i] >= uint64(len(strs)) {
+						return errors.New("invalid way tags in PBF")
+					}
+					tags[strs[keys[i]]] = strs[vals[i]]
+				}
+				ref := int64(0)
+				for i := range refs {
+					ref += refs[i]
+					refs[i] = ref
+				}
+				handleWay(id, refs, tags)
+			default:
+				gr.skip(wt)
+			}
+		}
+
+		if gr.err != nil {
+			return gr.err
+		}
+	}
+
+	return nil
+}
+
+// A minimal protocol buffer reader
+type pbfReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *pbfReader) more() bool {
+	return r.err == nil && r.pos < len(r.buf)
+}
+
+func (r *pbfReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.err = errors.New("invalid varint in PBF")
+		r.pos = len(r.buf)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *pbfReader) svarint() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *pbfReader) key() (int, int) {
+	k := r.varint()
+	return int(k >> 3), int(k & 7)
+}
+
+func (r *pbfReader) bytes() []byte {
+	l := int(r.varint())
+	if r.err != nil {
+		return nil
+	}
+	if l < 0 || r.pos+l > len(r.buf) {
+		r.err = errors.New("invalid length in PBF")
+		r.pos = len(r.buf)
+		return nil
+	}
+	ret := r.buf[r.pos : r.pos+l]
+	r.pos += l
+	return ret
+}
+
+func (r *pbfReader) packedVarints() []uint64 {
+	pr := pbfReader{buf: r.bytes()}
+	ret := make([]uint64, 0)
+	for pr.more() {
+		ret = append(ret, pr.varint())
+	}
+	if pr.err != nil {
+		r.err = pr.err
+	}
+	return ret
+}
+
+func (r *pbfReader) packedSvarints() []int64 {
+	pr := pbfReader{buf: r.bytes()}
+	ret := make([]int64, 0)
+	for pr.more() {
+		ret = append(ret, pr.svarint())
+	}
+	if pr.err != nil {
+		r.err = pr.err
+	}
+	return ret
+}
+
+func (r *pbfReader) skip(wt int) {
+	switch wt {
+	case 0:
+		r.varint()
+	case 1:
+		r.pos += 8
+	case 2:
+		r.bytes()
+	case 5:
+		r.pos += 4
+	default:
+		r.err = fmt.Errorf("unsupported wire type %d in PBF", wt)
+	}
+
+	if r.pos > len(r.buf) {
+		r.err = errors.New("unexpected end of PBF message")
+		r.pos = len(r.buf)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"os"
+	"path"
+	"testing"
+)
+
+// A minimal protocol buffer writer for test fixtures
+type pbfWriter struct {
+	buf []byte
+}
+
+func (w *pbfWriter) varint(field int, v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field<<3))
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *pbfWriter) svarint(field int, v int64) {
+	w.varint(field, uint64((v<<1)^(v>>63)))
+}
+
+func (w *pbfWriter) bytes(field int, b []byte) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field<<3|2))
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *pbfWriter) packedVarints(field int, vs []uint64) {
+	p := make([]byte, 0)
+	for _, v := range vs {
+		p = binary.AppendUvarint(p, v)
+	}
+	w.bytes(field, p)
+}
+
+// write delta-encoded values
+func (w *pbfWriter) packedDeltas(field int, vs []int64) {
+	p := make([]byte, 0)
+	prev := int64(0)
+	for _, v := range vs {
+		d := v - prev
+		prev = v
+		p = binary.AppendUvarint(p, uint64((d<<1)^(d>>63)))
+	}
+	w.bytes(field, p)
+}
+
+// Append a blob with header to a PBF file
+func appendPbfBlob(file []byte, blobType string, data []byte, compress bool) []byte {
+	blob := pbfWriter{}
+	if compress {
+		var zb bytes.Buffer
+		zw := zlib.NewWriter(&zb)
+		zw.Write(data)
+		zw.Close()
+		blob.varint(2, uint64(len(data)))
+		blob.bytes(3, zb.Bytes())
+	} else {
+		blob.bytes(1, data)
+	}
+
+	header := pbfWriter{}
+	header.bytes(1, []byte(blobType))
+	header.varint(3, uint64(len(blob.buf)))
+
+	file = binary.BigEndian.AppendUint32(file, uint32(len(header.buf)))
+	file = append(file, header.buf...)
+	return append(file, blob.buf...)
+}
+
+func TestReadOsmPbf(t *testing.T) {
+	dir, err := os.MkdirTemp("", "osmpbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const gran = 1000
+	const latOff = 5000000
+	const lonOff = -3000000
+
+	// coordinates in nanodegrees, relative to the offsets
+	enc := func(deg float64, off int64) int64 {
+		return (int64(math.Round(deg*1e9)) - off) / gran
+	}
+
+	strs := pbfWriter{}
+	for _, s := range []string{"", "highway", "residential", "name", "Hauptstraße", "building", "yes"} {
+		strs.bytes(1, []byte(s))
+	}
+
+	// dense nodes 1, 2, 3
+	dense := pbfWriter{}
+	dense.packedDeltas(1, []int64{1, 2, 3})
+	dense.packedDeltas(8, []int64{enc(48.0, latOff), enc(48.001, latOff), enc(48.002, latOff)})
+	dense.packedDeltas(9, []int64{enc(7.8, lonOff), enc(7.801, lonOff), enc(7.802, lonOff)})
+
+	// plain node 10
+	node := pbfWriter{}
+	node.svarint(1, 10)
+	node.svarint(8, enc(48.003, latOff))
+	node.svarint(9, enc(7.803, lonOff))
+
+	nodeGroup := pbfWriter{}
+	nodeGroup.bytes(2, dense.buf)
+	nodeGroup.bytes(1, node.buf)
+
+	way := pbfWriter{}
+	way.varint(1, 100)
+	way.packedVarints(2, []uint64{1, 3})
+	way.packedVarints(3, []uint64{2, 4})
+	way.packedDeltas(8, []int64{1, 2, 3, 10})
+
+	building := pbfWriter{}
+	building.varint(1, 101)
+	building.packedVarints(2, []uint64{5})
+	building.packedVarints(3, []uint64{6})
+	building.packedDeltas(8, []int64{2, 3})
+
+	wayGroup := pbfWriter{}
+	wayGroup.bytes(3, way.buf)
+	wayGroup.bytes(3, building.buf)
+
+	block := pbfWriter{}
+	block.bytes(1, strs.buf)
+	block.bytes(2, nodeGroup.buf)
+	block.bytes(2, wayGroup.buf)
+	block.varint(17, gran)
+
+	// offsets are int64, negative values are written as two's complement
+	lat, lon := int64(latOff), int64(lonOff)
+	block.varint(19, uint64(lat))
+	block.varint(20, uint64(lon))
+
+	file := appendPbfBlob(nil, "OSMHeader", []byte{}, false)
+	file = appendPbfBlob(file, "OSMData", block.buf, true)
+
+	// the same block again, uncompressed
+	file = appendPbfBlob(file, "OSMData", block.buf, false)
+
+	p := path.Join(dir, "test.osm.pbf")
+	os.WriteFile(p, file, 0644)
+
+	data, err := ReadOsm(p, func(tags map[string]string) bool {
+		return len(tags["highway"]) != 0
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data.Ways) != 2 {
+		t.Fatalf("expected 2 ways, got %d", len(data.Ways))
+	}
+
+	w := data.Ways[0]
+	if w.Id != 100 || w.Tags["highway"] != "residential" || w.Tags["name"] != "Hauptstraße" {
+		t.Errorf("unexpected way %v", w)
+	}
+
+	if len(w.Nodes) != 4 || w.Nodes[0] != 1 || w.Nodes[3] != 10 {
+		t.Errorf("unexpected way nodes %v", w.Nodes)
+	}
+
+	exp := map[int64][2]float64{1: {48.0, 7.8}, 2: {48.001, 7.801}, 3: {48.002, 7.802}, 10: {48.003, 7.803}}
+	for id, c := range exp {
+		if math.Abs(data.Nodes[id][0]-c[0]) > 1e-9 || math.Abs(data.Nodes[id][1]-c[1]) > 1e-9 {
+			t.Errorf("node %d: expected %v, got %v", id, c, data.Nodes[id])
+		}
+	}
+
+	// truncated file
+	os.WriteFile(p, file[:len(file)-5], 0644)
+	if _, err := ReadOsm(p, func(tags map[string]string) bool { return true }); err == nil {
+		t.Error("expected error for truncated file")
+	}
+}
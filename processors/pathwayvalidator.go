@@ -0,0 +1,253 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pathway modes, see the GTFS reference
+const (
+	pathwayWalkway    = 1
+	pathwayStairs     = 2
+	pathwayTravelator = 3
+	pathwayEscalator  = 4
+	pathwayElevator   = 5
+	pathwayFareGate   = 6
+	pathwayExitGate   = 7
+)
+
+// PathwayValidator checks the pathway graph of each station which has
+// pathways. Every platform (or boarding area) must be reachable from an
+// entrance and must lead back to one, bidirectional flags and traversal
+// times (at MinSpeed to MaxSpeed m/s) must be plausible, and levels must be
+// consistent with the pathway modes and stair counts. The feed is not
+// changed, problems are written to ReportFile. If no report file is given,
+// the offending stations are listed in the output.
+type PathwayValidator struct {
+	MinSpeed   float64
+	MaxSpeed   float64
+	ReportFile string
+}
+
+// Run this PathwayValidator on some feed
+func (pv PathwayValidator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Validating pathways... ")
+
+	report := NewReport("station_id", "problem", "entity_id", "details")
+
+	// pathways and stops per station
+	pathways := make(map[*gtfs.Stop][]*gtfs.Pathway)
+	for _, p := range feed.Pathways {
+		if st := stationOf(p.From_stop); st != nil {
+			pathways[st] = append(pathways[st], p)
+		}
+		if st := stationOf(p.To_stop); st != nil && st != stationOf(p.From_stop) {
+			pathways[st] = append(pathways[st], p)
+		}
+	}
+
+	stops := make(map[*gtfs.Stop][]*gtfs.Stop)
+	for _, s := range feed.Stops {
+		if st := stationOf(s); st != nil && s != st {
+			stops[st] = append(stops[st], s)
+		}
+	}
+
+	for st, pws := range pathways {
+		pv.checkReachability(st, stops[st], pws, report)
+
+		for _, p := range pws {
+			if stationOf(p.From_stop) != st {
+				// pathways between stations are checked in the station of their from stop
+				continue
+			}
+			pv.checkPathway(st, p, report)
+		}
+	}
+
+	stations := make(map[string]bool)
+	for _, r := range report.Rows {
+		stations[r[0]] = true
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d problems in %d of %d stations with pathways%s)\n", report.Len(), len(stations), len(pathways), report.writeIfRequested(pv.ReportFile))
+
+	if len(pv.ReportFile) == 0 && len(stations) > 0 {
+		fmt.Fprintf(os.Stdout, "  Stations with pathway problems: %s\n", strings.Join(sortedKeys(stations), ", "))
+	}
+}
+
+// Get the station a stop belongs to, nil if there is none
+func stationOf(s *gtfs.Stop) *gtfs.Stop {
+	for i := 0; s != nil && i < 3; i++ {
+		if s.Location_type == 1 {
+			return s
+		}
+		s = s.Parent_station
+	}
+	return nil
+}
+
+// Check that every platform and boarding area of station st is reachable
+// from an entrance, and that an entrance is reachable from it
+func (pv *PathwayValidator) checkReachability(st *gtfs.Stop, stops []*gtfs.Stop, pws []*gtfs.Pathway, report *Report) {
+	fwd := make(map[*gtfs.Stop][]*gtfs.Stop)
+	bwd := make(map[*gtfs.Stop][]*gtfs.Stop)
+
+	link := func(a, b *gtfs.Stop) {
+		fwd[a] = append(fwd[a], b)
+		bwd[b] = append(bwd[b], a)
+	}
+
+	for _, p := range pws {
+		link(p.From_stop, p.To_stop)
+		if p.Is_bidirectional {
+			link(p.To_stop, p.From_stop)
+		}
+	}
+
+	entrances := make([]*gtfs.Stop, 0)
+	boardingAreas := make(map[*gtfs.Stop][]*gtfs.Stop)
+
+	for _, s := range stops {
+		if s.Location_type == 2 {
+			entrances = append(entrances, s)
+		}
+		if s.Location_type == 4 && s.Parent_station != nil {
+			boardingAreas[s.Parent_station] = append(boardingAreas[s.Parent_station], s)
+		}
+	}
+
+	if len(entrances) == 0 {
+		report.Add(st.Id, "no entrance", st.Id, "station has pathways, but no entrance (location_type=2)")
+		return
+	}
+
+	from := reachableFrom(entrances, fwd)
+	to := reachableFrom(entrances, bwd)
+
+	for _, s := range stops {
+		// platforms with boarding areas are reached via their boarding areas
+		if s.Location_type == 4 || (s.Location_type == 0 && len(boardingAreas[s]) == 0) {
+			if !from[s] {
+				report.Add(st.Id, "unreachable platform", s.Id, "not reachable from any entrance")
+			}
+			if !to[s] {
+				report.Add(st.Id, "dead-end platform", s.Id, "no entrance reachable from here")
+			}
+		}
+	}
+}
+
+// Get the stops reachable from srcs in the graph given by adj
+func reachableFrom(srcs []*gtfs.Stop, adj map[*gtfs.Stop][]*gtfs.Stop) map[*gtfs.Stop]bool {
+	ret := make(map[*gtfs.Stop]bool)
+	stack := append([]*gtfs.Stop{}, srcs...)
+
+	for _, s := range srcs {
+		ret[s] = true
+	}
+
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, n := range adj[cur] {
+			if !ret[n] {
+				ret[n] = true
+				stack = append(stack, n)
+			}
+		}
+	}
+
+	return ret
+}
+
+// Check a single pathway for plausibility
+func (pv *PathwayValidator) checkPathway(st *gtfs.Stop, p *gtfs.Pathway, report *Report) {
+	if p.Is_bidirectional && (p.Mode == pathwayEscalator || p.Mode == pathwayExitGate) {
+		report.Add(st.Id, "implausible bidirectional", p.Id, "escalators and exit gates are one-directional")
+	}
+
+	// geometric distance, only if both stops have their own coordinates
+	geoDist := math.NaN()
+	if hasOwnCoords(p.From_stop) && hasOwnCoords(p.To_stop) {
+		geoDist = haversine(float64(p.From_stop.Lat), float64(p.From_stop.Lon), float64(p.To_stop.Lat), float64(p.To_stop.Lon))
+	}
+
+	length := float64(p.Length)
+	if !math.IsNaN(length) && !math.IsNaN(geoDist) && length < geoDist*0.9-1 {
+		report.Add(st.Id, "implausible length", p.Id, fmt.Sprintf("length %.1f m is shorter than the distance of %.1f m between the stops", length, geoDist))
+	}
+
+	if math.IsNaN(length) {
+		length = geoDist
+	}
+
+	if p.Traversal_time >= 0 && !math.IsNaN(length) && length > 0 {
+		if p.Traversal_time == 0 {
+			report.Add(st.Id, "implausible traversal time", p.Id, fmt.Sprintf("traversal time is 0 s for %.1f m", length))
+		} else {
+			speed := length / float64(p.Traversal_time)
+			if speed > pv.MaxSpeed {
+				report.Add(st.Id, "implausible traversal time", p.Id, fmt.Sprintf("%d s for %.1f m is too fast (%.2f m/s)", p.Traversal_time, length, speed))
+			} else if speed < pv.MinSpeed && p.Mode != pathwayElevator {
+				// elevators include the waiting time
+				report.Add(st.Id, "implausible traversal time", p.Id, fmt.Sprintf("%d s for %.1f m is too slow (%.2f m/s)", p.Traversal_time, length, speed))
+			}
+		}
+	}
+
+	pv.checkLevels(st, p, report)
+}
+
+// Check that the levels of the stops of p are consistent with its mode
+func (pv *PathwayValidator) checkLevels(st *gtfs.Stop, p *gtfs.Pathway, report *Report) {
+	if p.From_stop.Level == nil || p.To_stop.Level == nil {
+		return
+	}
+
+	from := p.From_stop.Level.Index
+	to := p.To_stop.Level.Index
+	levels := "levels " + strconv.FormatFloat(float64(from), 'f', -1, 32) + " and " + strconv.FormatFloat(float64(to), 'f', -1, 32)
+
+	switch p.Mode {
+	case pathwayStairs, pathwayEscalator, pathwayElevator:
+		if from == to {
+			report.Add(st.Id, "level mismatch", p.Id, fmt.Sprintf("%s connects stops on the same level %s", pathwayModeName(p.Mode), strconv.FormatFloat(float64(from), 'f', -1, 32)))
+		}
+	case pathwayFareGate, pathwayExitGate:
+		// walkways and travelators may be ramps between levels, gates not
+		if from != to {
+			report.Add(st.Id, "level mismatch", p.Id, fmt.Sprintf("%s connects different %s", pathwayModeName(p.Mode), levels))
+		}
+	}
+
+	// positive stair counts go up from the from stop to the to stop
+	if p.Mode == pathwayStairs && p.Stair_count != 0 && from != to && (p.Stair_count > 0) != (to > from) {
+		report.Add(st.Id, "level mismatch", p.Id, fmt.Sprintf("stair count %d contradicts %s", p.Stair_count, levels))
+	}
+}
+
+func hasOwnCoords(s *gtfs.Stop) bool {
+	return !math.IsNaN(float64(s.Lat)) && !math.IsNaN(float64(s.Lon))
+}
+
+func pathwayModeName(mode uint8) string {
+	names := []string{"", "walkway", "stairs", "travelator", "escalator", "elevator", "fare gate", "exit gate"}
+	if int(mode) < len(names) && mode > 0 {
+		return names[mode]
+	}
+	return "pathway"
+}
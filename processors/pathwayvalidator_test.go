@@ -0,0 +1,108 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPathwayValidator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	l0 := &gtfs.Level{Id: "l0", Index: 0}
+	l1 := &gtfs.Level{Id: "l1", Index: -1}
+
+	nan := float32(math.NaN())
+
+	st := &gtfs.Stop{Id: "S", Lat: 48, Lon: 7.8, Location_type: 1}
+	stops := []*gtfs.Stop{
+		st,
+		{Id: "e", Lat: 48, Lon: 7.8, Location_type: 2, Parent_station: st, Level: l0},
+		{Id: "n", Lat: nan, Lon: nan, Location_type: 3, Parent_station: st, Level: l1},
+		{Id: "p1", Lat: 48, Lon: 7.8001, Parent_station: st, Level: l1},
+		{Id: "p2", Lat: 48, Lon: 7.8002, Parent_station: st, Level: l1},
+		{Id: "p3", Lat: 48, Lon: 7.8003, Parent_station: st, Level: l1},
+		{Id: "p4", Lat: 48, Lon: 7.8004, Parent_station: st, Level: l1},
+	}
+
+	// a station with pathways, but without an entrance
+	st2 := &gtfs.Stop{Id: "T", Lat: 49, Lon: 7.8, Location_type: 1}
+	stops = append(stops, st2, &gtfs.Stop{Id: "q", Lat: 49, Lon: 7.8, Parent_station: st2}, &gtfs.Stop{Id: "r", Lat: 49, Lon: 7.8001, Parent_station: st2})
+
+	for _, s := range stops {
+		feed.Stops[s.Id] = s
+	}
+
+	pw := func(id, from, to string, mode uint8, bidir bool, length float32, time int) {
+		feed.Pathways[id] = &gtfs.Pathway{Id: id, From_stop: feed.Stops[from], To_stop: feed.Stops[to], Mode: mode, Is_bidirectional: bidir, Length: length, Traversal_time: time}
+	}
+
+	pw("stairs", "e", "n", pathwayStairs, true, nan, 30)
+	pw("walk1", "n", "p1", pathwayWalkway, false, nan, -1)
+	pw("esc", "n", "p2", pathwayEscalator, true, nan, -1)
+	pw("walk2", "p3", "p2", pathwayWalkway, false, 2, 0)
+	pw("walk3", "q", "r", pathwayWalkway, true, nan, -1)
+	pw("ramp", "e", "p4", pathwayWalkway, true, nan, -1)
+	pw("gate", "e", "p4", pathwayFareGate, true, nan, -1)
+
+	feed.Pathways["stairs"].Stair_count = 20
+
+	dir, err := os.MkdirTemp("", "pathwayvalidator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pv := PathwayValidator{MinSpeed: 0.1, MaxSpeed: 5, ReportFile: path.Join(dir, "pathways.csv")}
+	pv.Run(feed)
+
+	content, err := os.ReadFile(pv.ReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := string(content)
+
+	expected := []string{
+		"S,dead-end platform,p1",
+		"S,implausible bidirectional,esc",
+		"S,unreachable platform,p3",
+		"S,implausible length,walk2",
+		"S,implausible traversal time,walk2",
+		"S,level mismatch,esc,escalator connects stops on the same level -1",
+		"S,level mismatch,stairs,stair count 20 contradicts levels 0 and -1",
+		"S,level mismatch,gate,fare gate connects different levels 0 and -1",
+		"T,no entrance,T",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(report, e) {
+			t.Errorf("expected '%s' in report:\n%s", e, report)
+		}
+	}
+
+	unexpected := []string{
+		"S,dead-end platform,p2",
+		"S,unreachable platform,p1",
+		"S,unreachable platform,p2",
+		"S,dead-end platform,p3",
+		"level mismatch,walk",
+		"level mismatch,ramp",
+	}
+
+	for _, e := range unexpected {
+		if strings.Contains(report, e) {
+			t.Errorf("did not expect '%s' in report:\n%s", e, report)
+		}
+	}
+}
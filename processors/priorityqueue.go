@@ -56,3 +56,30 @@ func (pq *PriorityQueue) Pop() interface{} {
 	pq.Items = old[0 : n-1]
 	return item
 }
+
+type distItem struct {
+	node int
+	dist float64
+}
+
+// A distQueue is a min-heap of graph nodes by distance, to be used with
+// container/heap in shortest path searches
+type distQueue []distItem
+
+func (dq distQueue) Len() int { return len(dq) }
+
+func (dq distQueue) Less(i, j int) bool { return dq[i].dist < dq[j].dist }
+
+func (dq distQueue) Swap(i, j int) { dq[i], dq[j] = dq[j], dq[i] }
+
+func (dq *distQueue) Push(x interface{}) {
+	*dq = append(*dq, x.(distItem))
+}
+
+func (dq *distQueue) Pop() interface{} {
+	old := *dq
+	n := len(old)
+	item := old[n-1]
+	*dq = old[0 : n-1]
+	return item
+}
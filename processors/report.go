@@ -0,0 +1,78 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Report collects rows describing the changes a processor made (or the
+// problems it found), to be written to a CSV file
+type Report struct {
+	Header []string
+	Rows   [][]string
+	mutex  sync.Mutex
+}
+
+// NewReport creates a new, empty report with the given CSV header
+func NewReport(header ...string) *Report {
+	return &Report{Header: header, Rows: make([][]string, 0)}
+}
+
+// Add a row to the report. Safe for concurrent use.
+func (r *Report) Add(row ...string) {
+	r.mutex.Lock()
+	r.Rows = append(r.Rows, row)
+	r.mutex.Unlock()
+}
+
+// Len returns the number of rows in the report
+func (r *Report) Len() int {
+	return len(r.Rows)
+}
+
+// Write the report as a CSV file to path. Rows are sorted, so that the
+// output is deterministic.
+func (r *Report) Write(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sort.SliceStable(r.Rows, func(i, j int) bool {
+		for k := 0; k < len(r.Rows[i]) && k < len(r.Rows[j]); k++ {
+			if r.Rows[i][k] != r.Rows[j][k] {
+				return r.Rows[i][k] < r.Rows[j][k]
+			}
+		}
+		return len(r.Rows[i]) < len(r.Rows[j])
+	})
+
+	w := csv.NewWriter(file)
+	w.Write(r.Header)
+	w.WriteAll(r.Rows)
+
+	return w.Error()
+}
+
+// Write the report to path if path is not empty, and return a
+// short note for the processor output
+func (r *Report) writeIfRequested(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	if err := r.Write(path); err != nil {
+		return ", could not write report: " + err.Error()
+	}
+
+	return ", report written to " + path
+}
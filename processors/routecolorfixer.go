@@ -0,0 +1,242 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"hash/fnv"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoutePalette holds the colors RouteColorFixer assigns if no
+// other palette is given
+var DefaultRoutePalette = []string{
+	"E6194B", "3CB44B", "FFE119", "4363D8", "F58231", "911EB4", "46F0F0", "F032E6",
+	"BCF60C", "FABEBE", "008080", "E6BEFF", "9A6324", "FFFAC8", "800000", "AAFFC3",
+	"808000", "FFD8B1", "000075", "808080",
+}
+
+// RouteColorFixer sets the text color of routes to black or white if the
+// contrast ratio (as defined by WCAG 2) between route_color and
+// route_text_color is below MinContrast. If AssignColors is set, routes
+// without a color get a color from Palette, distinct from the colors of the
+// other routes of the same agency and mode where possible. The color is
+// chosen by a hash of the agency, mode and route names, so it is stable
+// across runs.
+type RouteColorFixer struct {
+	MinContrast  float64
+	AssignColors bool
+	Palette      []string
+}
+
+// ReadRoutePalette reads a palette of colors from a file, one hex color
+// (with or without leading #) per line. Empty lines and other lines
+// starting with # are ignored.
+func ReadRoutePalette(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := make([]string, 0)
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		color := strings.ToUpper(strings.TrimPrefix(line, "#"))
+		if _, ok := parseColor(color); !ok {
+			if line[0] == '#' {
+				// a comment
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid color '%s'", i, line)
+		}
+
+		ret = append(ret, color)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no colors in palette")
+	}
+
+	return ret, nil
+}
+
+// Run this RouteColorFixer on some feed
+func (rcf RouteColorFixer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Fixing route colors... ")
+
+	assigned := 0
+	if rcf.AssignColors {
+		assigned = rcf.assignColors(feed)
+	}
+
+	fixed := 0
+
+	for _, r := range feed.Routes {
+		bg, okBg := parseColor(r.Color)
+		fg, okFg := parseColor(r.Text_color)
+
+		if !okBg {
+			continue
+		}
+
+		if okFg && contrastRatio(bg, fg) >= rcf.MinContrast {
+			continue
+		}
+
+		text := "000000"
+		if contrastRatio(bg, [3]uint8{255, 255, 255}) > contrastRatio(bg, [3]uint8{0, 0, 0}) {
+			text = "FFFFFF"
+		}
+
+		if !strings.EqualFold(text, r.Text_color) {
+			r.Text_color = text
+			fixed++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d route colors assigned, %d text colors fixed)\n", assigned, fixed)
+}
+
+// Assign colors to routes without a color, returns the number of routes
+// which got a color
+func (rcf RouteColorFixer) assignColors(feed *gtfsparser.Feed) int {
+	palette := rcf.Palette
+	if len(palette) == 0 {
+		palette = DefaultRoutePalette
+	}
+
+	type groupKey struct {
+		agency *gtfs.Agency
+		mode   int16
+	}
+
+	used := make(map[groupKey]map[string]bool)
+	uncolored := make(map[groupKey][]*gtfs.Route)
+
+	for _, r := range feed.Routes {
+		k := groupKey{r.Agency, gtfs.GetTypeFromExtended(r.Type)}
+		if used[k] == nil {
+			used[k] = make(map[string]bool)
+		}
+
+		if hasRouteColor(r) {
+			used[k][strings.ToUpper(r.Color)] = true
+		} else {
+			uncolored[k] = append(uncolored[k], r)
+		}
+	}
+
+	n := 0
+
+	for k, routes := range uncolored {
+		sort.Slice(routes, func(i, j int) bool {
+			ii, ij := routeIdentity(routes[i]), routeIdentity(routes[j])
+			if ii != ij {
+				return ii < ij
+			}
+			return routes[i].Id < routes[j].Id
+		})
+
+		for _, r := range routes {
+			h := fnv.New32a()
+			h.Write([]byte(routeIdentity(r)))
+			start := int(h.Sum32() % uint32(len(palette)))
+
+			// the first unused color starting at the hashed position,
+			// if all colors are used, the hashed one
+			color := palette[start]
+			for i := 0; i < len(palette); i++ {
+				c := palette[(start+i)%len(palette)]
+				if !used[k][c] {
+					color = c
+					break
+				}
+			}
+
+			r.Color = color
+			used[k][color] = true
+			n++
+		}
+	}
+
+	return n
+}
+
+// True if a route has an explicit color. White is the default color and
+// is treated as no color.
+func hasRouteColor(r *gtfs.Route) bool {
+	return len(r.Color) != 0 && !strings.EqualFold(r.Color, "FFFFFF")
+}
+
+// Get a string identifying a route independent of its ID
+func routeIdentity(r *gtfs.Route) string {
+	agency := ""
+	if r.Agency != nil {
+		agency = r.Agency.Id
+	}
+
+	name := r.Short_name + "\x00" + r.Long_name
+	if len(r.Short_name) == 0 && len(r.Long_name) == 0 {
+		name = r.Id
+	}
+
+	return agency + "\x00" + strconv.Itoa(int(gtfs.GetTypeFromExtended(r.Type))) + "\x00" + name
+}
+
+// Parse a 6-digit hex color
+func parseColor(c string) ([3]uint8, bool) {
+	if len(c) != 6 {
+		return [3]uint8{}, false
+	}
+
+	v, err := strconv.ParseUint(c, 16, 32)
+	if err != nil {
+		return [3]uint8{}, false
+	}
+
+	return [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+}
+
+// Relative luminance of a color, as defined by WCAG 2
+func luminance(c [3]uint8) float64 {
+	lin := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+
+	return 0.2126*lin(c[0]) + 0.7152*lin(c[1]) + 0.0722*lin(c[2])
+}
+
+// Contrast ratio between two colors, as defined by WCAG 2, in [1, 21]
+func contrastRatio(a, b [3]uint8) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRouteColorFixer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	ag := &gtfs.Agency{Id: "A"}
+
+	routes := []*gtfs.Route{
+		{Id: "same", Agency: ag, Type: 3, Color: "1E5AA0", Text_color: "1E5AA0"},
+		{Id: "dark", Agency: ag, Type: 3, Color: "000080", Text_color: "000000"},
+		{Id: "light", Agency: ag, Type: 3, Color: "FFFF00", Text_color: "FFFFFF"},
+		{Id: "ok", Agency: ag, Type: 3, Color: "000000", Text_color: "FFFFFF"},
+		{Id: "none1", Agency: ag, Type: 3, Short_name: "1", Color: "ffffff", Text_color: "000000"},
+		{Id: "none2", Agency: ag, Type: 3, Short_name: "2", Color: "ffffff", Text_color: "000000"},
+		{Id: "none3", Agency: ag, Type: 3, Short_name: "3", Color: "ffffff", Text_color: "000000"},
+	}
+
+	for _, r := range routes {
+		feed.Routes[r.Id] = r
+	}
+
+	palette := []string{"000000", "FFFF00", "FF0000", "00FF00", "0000FF"}
+
+	RouteColorFixer{MinContrast: 4.5, AssignColors: true, Palette: palette}.Run(feed)
+
+	expText := map[string]string{"same": "FFFFFF", "dark": "FFFFFF", "light": "000000", "ok": "FFFFFF"}
+
+	for id, c := range expText {
+		if feed.Routes[id].Text_color != c {
+			t.Errorf("expected text color %s for %s, got %s", c, id, feed.Routes[id].Text_color)
+		}
+	}
+
+	// black and yellow are already used by routes of the agency
+	colors := make(map[string]bool)
+	for _, id := range []string{"none1", "none2", "none3"} {
+		c := feed.Routes[id].Color
+		if c != "FF0000" && c != "00FF00" && c != "0000FF" {
+			t.Errorf("expected an unused palette color for %s, got %s", id, c)
+		}
+		colors[c] = true
+	}
+
+	if len(colors) != 3 {
+		t.Errorf("expected distinct colors, got %v", colors)
+	}
+
+	// stable across runs
+	feed2 := gtfsparser.NewFeed()
+	for _, r := range routes {
+		r2 := *r
+		if strings.HasPrefix(r.Id, "none") {
+			r2.Color = "ffffff"
+		}
+		r2.Id = "x" + r.Id
+		feed2.Routes[r2.Id] = &r2
+	}
+
+	RouteColorFixer{MinContrast: 4.5, AssignColors: true, Palette: palette}.Run(feed2)
+
+	for _, id := range []string{"none1", "none2", "none3"} {
+		if feed2.Routes["x"+id].Color != feed.Routes[id].Color {
+			t.Errorf("expected stable color for %s", id)
+		}
+	}
+}
+
+func TestReadRoutePalette(t *testing.T) {
+	dir, err := os.MkdirTemp("", "routepalette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "palette.txt")
+	os.WriteFile(p, []byte("# colors\n#ff0000\n\n00ff00\n"), 0644)
+
+	pal, err := ReadRoutePalette(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pal) != 2 || pal[0] != "FF0000" || pal[1] != "00FF00" {
+		t.Errorf("unexpected palette %v", pal)
+	}
+
+	os.WriteFile(p, []byte("red\n"), 0644)
+
+	if _, err := ReadRoutePalette(p); err == nil {
+		t.Error("expected error for invalid color")
+	}
+}
@@ -15,8 +15,11 @@ import (
 )
 
 // ServiceMinimizer minimizes services by finding optimal calendar.txt and
-// calendar_dates.txt coverages.
+// calendar_dates.txt coverages. Holidays are treated as a separate day type:
+// they are ignored while searching for the best weekday cover, and are thus
+// preferably expressed as calendar_dates.txt exceptions.
 type ServiceMinimizer struct {
+	Holidays Holidays
 }
 
 type serviceException struct {
@@ -145,6 +148,7 @@ func (sm ServiceMinimizer) perfectMinimize(service *gtfs.Service) {
 	endTimeAm := endTime.AddDate(0, 0, 6-int(endTime.Weekday()))
 
 	activeOn := sm.getActiveOnMap(startTimeAm, endTimeAm, service)
+	holidayOn, numHolidays := sm.getHolidayMap(startTimeAm, endTimeAm)
 	daysNotMatched := sm.getDaysNotMatched(service)
 	l := len(activeOn)
 
@@ -156,13 +160,13 @@ out:
 		for b := l - 1; b > a; b = b - 7 {
 			fullWeekCoverage := ((b - a) - 7) / 7
 			for d := uint(1); d < 128; d++ {
-				minExc := fullWeekCoverage*daysNotMatched[d] - len(service.Exceptions())
+				minExc := fullWeekCoverage*daysNotMatched[d] - len(service.Exceptions()) - numHolidays
 
 				if minExc > -1 && uint(minExc) > e {
 					continue
 				}
 
-				c := sm.countExceptions(service, activeOn, d, startDiff, endDiff, a, b, e)
+				c := sm.countExceptions(service, activeOn, holidayOn, d, startDiff, endDiff, a, b, e)
 
 				if c < e {
 					e = c
@@ -179,10 +183,27 @@ out:
 		}
 	}
 
+	if holidayOn != nil {
+		// because holidays were ignored above, make sure the cover is
+		// still better than simply listing every active date
+		numActive := uint(0)
+		for _, act := range activeOn {
+			if act {
+				numActive++
+			}
+		}
+
+		if sm.countExceptions(service, activeOn, nil, bestMap, startDiff, endDiff, bestA, bestB, numActive) >= numActive {
+			bestMap = 0
+		}
+	}
+
 	sm.updateService(service, bestMap, bestA, bestB, startTime, endTime, start, end)
 }
 
-func (sm ServiceMinimizer) countExceptions(s *gtfs.Service, actmap []bool, bm uint, startDiff int, endDiff int, a int, b int, max uint) uint {
+// Count the exceptions needed for weekmap bm between a and b. Days marked
+// in hol are not counted.
+func (sm ServiceMinimizer) countExceptions(s *gtfs.Service, actmap []bool, hol []bool, bm uint, startDiff int, endDiff int, a int, b int, max uint) uint {
 	ret := uint(0)
 	l := len(actmap)
 
@@ -195,6 +216,10 @@ func (sm ServiceMinimizer) countExceptions(s *gtfs.Service, actmap []bool, bm ui
 			return max
 		}
 
+		if hol != nil && hol[d] {
+			continue
+		}
+
 		if d < a || d > b {
 			// we are out of the weekmap span
 			if actmap[d] {
@@ -310,6 +335,25 @@ func (sm ServiceMinimizer) getActiveOnMap(startTimeAm time.Time, endTimeAm time.
 	return activeOn
 }
 
+// Returns a map of holidays between startTimeAm and endTimeAm, and the number
+// of holidays in it. If no holidays are defined, nil is returned.
+func (sm ServiceMinimizer) getHolidayMap(startTimeAm time.Time, endTimeAm time.Time) ([]bool, int) {
+	if len(sm.Holidays) == 0 {
+		return nil, 0
+	}
+
+	holidayOn := make([]bool, 0)
+	num := 0
+	for d := sm.getGtfsDateFromTime(startTimeAm); !d.GetTime().After(endTimeAm); d = sm.getNextDate(d) {
+		hol := sm.Holidays.IsHoliday(d)
+		if hol {
+			num++
+		}
+		holidayOn = append(holidayOn, hol)
+	}
+	return holidayOn, num
+}
+
 func (sm ServiceMinimizer) updateService(service *gtfs.Service, bestMap uint, bestA int, bestB int, startTime time.Time, endTime time.Time, start gtfs.Date, end gtfs.Date) {
 	newMap := [7]bool{hasBit(bestMap, 0),
 		hasBit(bestMap, 1),
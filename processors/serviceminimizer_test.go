@@ -220,3 +220,45 @@ func TestServiceMinimizer(t *testing.T) {
 		t.Error(testa.Daymap(2))
 	}
 }
+
+func TestServiceMinimizerHolidays(t *testing.T) {
+	hol := Holidays{gtfs.NewDate(6, 1, 2017): "Epiphany", gtfs.NewDate(9, 1, 2017): ""}
+	proc := ServiceMinimizer{Holidays: hol}
+
+	// weekday service, not running on holidays
+	testa := gtfs.EmptyService()
+	testa.SetId("a")
+	testa.SetRawDaymap(0)
+	testa.SetStart_date(gtfs.NewDate(2, 1, 2017))
+	testa.SetEnd_date(gtfs.NewDate(13, 1, 2017))
+
+	for d := testa.Start_date(); !d.GetTime().After(testa.End_date().GetTime()); d = d.GetOffsettedDate(1) {
+		wd := d.GetTime().Weekday()
+		if wd == 0 || wd == 6 || hol.IsHoliday(d) {
+			continue
+		}
+		testa.Exceptions()[d] = true
+	}
+
+	proc.perfectMinimize(testa)
+
+	for i := 1; i < 6; i++ {
+		if !testa.Daymap(i) {
+			t.Error(i, testa.Daymap(i))
+		}
+	}
+
+	if testa.Daymap(0) || testa.Daymap(6) {
+		t.Error("weekend active")
+	}
+
+	if len(testa.Exceptions()) != 2 {
+		t.Error(testa.Exceptions())
+	}
+
+	for d := range hol {
+		if testa.IsActiveOn(d) {
+			t.Error(d)
+		}
+	}
+}
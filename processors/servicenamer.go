@@ -0,0 +1,258 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServiceNameLocale holds the abbreviations used in descriptive service IDs
+type ServiceNameLocale struct {
+	// abbreviated day names, starting with Sunday
+	DayNames [7]string
+	Holiday  string
+}
+
+// ServiceNameLocales are the built-in locales for the ServiceNamer
+var ServiceNameLocales = map[string]ServiceNameLocale{
+	"en": {[7]string{"Su", "Mo", "Tu", "We", "Th", "Fr", "Sa"}, "hol"},
+	"de": {[7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"}, "Fei"},
+	"fr": {[7]string{"Di", "Lu", "Ma", "Me", "Je", "Ve", "Sa"}, "fer"},
+	"nl": {[7]string{"Zo", "Ma", "Di", "Wo", "Do", "Vr", "Za"}, "fd"},
+	"it": {[7]string{"Do", "Lu", "Ma", "Me", "Gi", "Ve", "Sa"}, "fest"},
+	"es": {[7]string{"Do", "Lu", "Ma", "Mi", "Ju", "Vi", "Sa"}, "fest"},
+}
+
+// ServiceNamer replaces service IDs with descriptive IDs derived from the
+// service calendar, like "Mo-Fr_20260101-20261231", "Sa+hol" or "Su_ex3".
+// The date range is omitted if it equals the most common range in the feed.
+// Services without a weekly pattern are named after the number of days they
+// are active on and their first and last active date, like "3d_20260101-20260105".
+// Should be run after the services have been minimized.
+type ServiceNamer struct {
+	Locale   ServiceNameLocale
+	Holidays Holidays
+}
+
+type serviceRange struct {
+	start gtfs.Date
+	end   gtfs.Date
+}
+
+// MakeServiceNamer creates a ServiceNamer for a locale given by its language code
+func MakeServiceNamer(locale string, holidays Holidays) (ServiceNamer, error) {
+	loc, ok := ServiceNameLocales[strings.ToLower(locale)]
+	if !ok {
+		locs := make([]string, 0)
+		for l := range ServiceNameLocales {
+			locs = append(locs, l)
+		}
+		sort.Strings(locs)
+		return ServiceNamer{}, errors.New("unknown service name locale '" + locale + "', supported are: " + strings.Join(locs, ","))
+	}
+
+	return ServiceNamer{Locale: loc, Holidays: holidays}, nil
+}
+
+// Run this ServiceNamer on some feed
+func (sn ServiceNamer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Naming services... ")
+
+	services := make([]*gtfs.Service, 0, len(feed.Services))
+	for _, s := range feed.Services {
+		services = append(services, s)
+	}
+
+	// sort for deterministic suffixes of ambiguous names
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Id() < services[j].Id()
+	})
+
+	common := sn.getCommonRange(services)
+
+	newServices := make(map[string]*gtfs.Service, len(feed.Services))
+	renamed := 0
+
+	for _, s := range services {
+		base := sn.getName(s, common)
+		id := base
+
+		for i := 2; ; i++ {
+			if _, ok := newServices[id]; !ok {
+				break
+			}
+			id = base + "_" + strconv.Itoa(i)
+		}
+
+		if id != s.Id() {
+			renamed++
+		}
+
+		s.SetId(id)
+		newServices[id] = s
+	}
+
+	feed.Services = newServices
+
+	fmt.Fprintf(os.Stdout, "done. (%d services renamed [%.2f%%])\n",
+		renamed,
+		100.0*float64(renamed)/(float64(len(feed.Services))+0.001))
+}
+
+// Get the descriptive name of a single service
+func (sn ServiceNamer) getName(s *gtfs.Service, common serviceRange) string {
+	if s.RawDaymap() == 0 || s.Start_date().IsEmpty() || s.End_date().IsEmpty() {
+		return sn.getDateListName(s)
+	}
+
+	addHol := false
+	remHol := false
+	numExc := 0
+
+	for d, active := range s.Exceptions() {
+		if active == sn.isActiveWeekly(s, d) {
+			// exception without effect
+			continue
+		}
+
+		if sn.Holidays.IsHoliday(d) {
+			if active {
+				addHol = true
+			} else {
+				remHol = true
+			}
+			continue
+		}
+
+		numExc++
+	}
+
+	name := sn.getDaymapName(s.RawDaymap())
+
+	if addHol {
+		name += "+" + sn.Locale.Holiday
+	}
+
+	if s.Start_date() != common.start || s.End_date() != common.end {
+		name += "_" + dateStr(s.Start_date()) + "-" + dateStr(s.End_date())
+	}
+
+	if remHol {
+		name += "_no" + sn.Locale.Holiday
+	}
+
+	if numExc > 0 {
+		name += "_ex" + strconv.Itoa(numExc)
+	}
+
+	return name
+}
+
+// Check if s is active on d, ignoring exceptions
+func (sn ServiceNamer) isActiveWeekly(s *gtfs.Service, d gtfs.Date) bool {
+	t := d.GetTime()
+	return s.Daymap(int(t.Weekday())) && !t.Before(s.Start_date().GetTime()) && !t.After(s.End_date().GetTime())
+}
+
+// Get the name of a service defined by calendar_dates.txt entries only
+func (sn ServiceNamer) getDateListName(s *gtfs.Service) string {
+	dates := make([]gtfs.Date, 0)
+	allHol := true
+
+	for d, active := range s.Exceptions() {
+		if !active {
+			continue
+		}
+		dates = append(dates, d)
+		if !sn.Holidays.IsHoliday(d) {
+			allHol = false
+		}
+	}
+
+	if len(dates) == 0 {
+		return "none"
+	}
+
+	if allHol {
+		return sn.Locale.Holiday
+	}
+
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].GetTime().Before(dates[j].GetTime())
+	})
+
+	if len(dates) == 1 {
+		return dateStr(dates[0])
+	}
+
+	return strconv.Itoa(len(dates)) + "d_" + dateStr(dates[0]) + "-" + dateStr(dates[len(dates)-1])
+}
+
+// Get the name of a weekday map, with consecutive days given as ranges,
+// starting at Monday, e.g. "Mo-Fr" or "Mo.We.Fr". Days are not separated
+// by commas, which would need quoting in the CSV output.
+func (sn ServiceNamer) getDaymapName(daymap uint8) string {
+	parts := make([]string, 0)
+
+	for i := 0; i < 7; i++ {
+		if daymap&(1<<uint((i+1)%7)) == 0 {
+			continue
+		}
+
+		j := i
+		for j+1 < 7 && daymap&(1<<uint((j+2)%7)) != 0 {
+			j++
+		}
+
+		if j-i >= 2 {
+			parts = append(parts, sn.Locale.DayNames[(i+1)%7]+"-"+sn.Locale.DayNames[(j+1)%7])
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, sn.Locale.DayNames[(k+1)%7])
+			}
+		}
+
+		i = j
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Get the most common date range of all weekly services
+func (sn ServiceNamer) getCommonRange(services []*gtfs.Service) serviceRange {
+	counts := make(map[serviceRange]int)
+	for _, s := range services {
+		if s.RawDaymap() == 0 {
+			continue
+		}
+		counts[serviceRange{s.Start_date(), s.End_date()}]++
+	}
+
+	best := serviceRange{}
+	bestCount := 0
+
+	for r, c := range counts {
+		if c > bestCount || (c == bestCount && (r.start.GetTime().Before(best.start.GetTime()) || (r.start == best.start && r.end.GetTime().Before(best.end.GetTime())))) {
+			best = r
+			bestCount = c
+		}
+	}
+
+	return best
+}
+
+// Format a date as YYYYMMDD
+func dateStr(d gtfs.Date) string {
+	return fmt.Sprintf("%04d%02d%02d", d.Year(), d.Month(), d.Day())
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestServiceNamer(t *testing.T) {
+	proc, err := MakeServiceNamer("en", Holidays{gtfs.NewDate(6, 1, 2026): ""})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := MakeServiceNamer("xx", nil); err == nil {
+		t.Error("expected error for unknown locale")
+	}
+
+	feed := gtfsparser.NewFeed()
+
+	weekdays := gtfs.EmptyService()
+	weekdays.SetId("a")
+	weekdays.SetRawDaymap(62)
+	weekdays.SetStart_date(gtfs.NewDate(1, 1, 2026))
+	weekdays.SetEnd_date(gtfs.NewDate(31, 12, 2026))
+	weekdays.Exceptions()[gtfs.NewDate(6, 1, 2026)] = false
+	feed.Services["a"] = weekdays
+
+	weekdays2 := gtfs.EmptyService()
+	weekdays2.SetId("b")
+	weekdays2.SetRawDaymap(62)
+	weekdays2.SetStart_date(gtfs.NewDate(1, 1, 2026))
+	weekdays2.SetEnd_date(gtfs.NewDate(31, 12, 2026))
+	feed.Services["b"] = weekdays2
+
+	saturdays := gtfs.EmptyService()
+	saturdays.SetId("c")
+	saturdays.SetRawDaymap(64)
+	saturdays.SetStart_date(gtfs.NewDate(1, 1, 2026))
+	saturdays.SetEnd_date(gtfs.NewDate(31, 12, 2026))
+	saturdays.Exceptions()[gtfs.NewDate(6, 1, 2026)] = true
+	feed.Services["c"] = saturdays
+
+	sundays := gtfs.EmptyService()
+	sundays.SetId("d")
+	sundays.SetRawDaymap(1)
+	sundays.SetStart_date(gtfs.NewDate(1, 3, 2026))
+	sundays.SetEnd_date(gtfs.NewDate(31, 3, 2026))
+	sundays.Exceptions()[gtfs.NewDate(8, 3, 2026)] = false
+	sundays.Exceptions()[gtfs.NewDate(15, 3, 2026)] = false
+	sundays.Exceptions()[gtfs.NewDate(17, 3, 2026)] = true
+	feed.Services["d"] = sundays
+
+	dates := gtfs.EmptyService()
+	dates.SetId("e")
+	dates.Exceptions()[gtfs.NewDate(2, 2, 2026)] = true
+	dates.Exceptions()[gtfs.NewDate(5, 2, 2026)] = true
+	feed.Services["e"] = dates
+
+	weekend := gtfs.EmptyService()
+	weekend.SetId("f")
+	weekend.SetRawDaymap(1 + 64 + 2 + 8)
+	weekend.SetStart_date(gtfs.NewDate(1, 1, 2026))
+	weekend.SetEnd_date(gtfs.NewDate(31, 12, 2026))
+	feed.Services["f"] = weekend
+
+	proc.Run(feed)
+
+	expected := map[string]*gtfs.Service{
+		"Mo-Fr_nohol":              weekdays,
+		"Mo-Fr":                    weekdays2,
+		"Sa+hol":                   saturdays,
+		"Su_20260301-20260331_ex3": sundays,
+		"2d_20260202-20260205":     dates,
+		"Mo.We.Sa.Su":              weekend,
+	}
+
+	if len(feed.Services) != len(expected) {
+		t.Error(feed.Services)
+	}
+
+	for id, s := range expected {
+		if feed.Services[id] != s || s.Id() != id {
+			t.Error(id, s.Id())
+		}
+	}
+}
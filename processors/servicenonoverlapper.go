@@ -14,6 +14,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 type DayType struct {
@@ -24,20 +25,24 @@ type DayType struct {
 // ServiceNonOverlapper constructs day-wise non-overlapping trips. Basically, this works as
 // follows: uniqe day types are constructed for each day of the week. A day type is one DOW
 // on which *excactly* the same trips are served. Similary day types are than aggreated,
-// and outfitted with an ID "<Weekday> (WW<list of calendar weeks served)".
+// and outfitted with an ID "<Weekday> (WW<list of calendar weeks served)". Holidays are
+// collected into dedicated day types with an ID "<HolidayName> (<list of holiday names>)".
 type ServiceNonOverlapper struct {
-	DayNames []string
+	DayNames     []string
 	YearWeekName string
+	Holidays     Holidays
+	HolidayName  string
 }
 
 // Run this ServiceMinimizer on some feed
 func (sm ServiceNonOverlapper) Run(feed *gtfsparser.Feed) {
 	fmt.Fprintf(os.Stdout, "Creating distinct, non-overlapping services... ")
 
-	days := make([]map[gtfs.Date][]*gtfs.Trip, 7)
-	day_types := make([][]DayType, 7)
+	// the 8th day type holds holidays
+	days := make([]map[gtfs.Date][]*gtfs.Trip, 8)
+	day_types := make([][]DayType, 8)
 
-	for i := 0; i < 7; i++ {
+	for i := 0; i < 8; i++ {
 		days[i] = make(map[gtfs.Date][]*gtfs.Trip)
 		day_types[i] = make([]DayType, 0)
 	}
@@ -48,7 +53,11 @@ func (sm ServiceNonOverlapper) Run(feed *gtfsparser.Feed) {
 
 		for cur.GetTime().Before(last.GetTime()) || cur.GetTime() == last.GetTime() {
 			if t.Service.IsActiveOn(cur) {
-				days[cur.GetTime().Weekday()][cur] = append(days[cur.GetTime().Weekday()][cur], t)
+				wd := int(cur.GetTime().Weekday())
+				if sm.Holidays.IsHoliday(cur) {
+					wd = 7
+				}
+				days[wd][cur] = append(days[wd][cur], t)
 			}
 			cur = cur.GetOffsettedDate(1)
 		}
@@ -103,6 +112,12 @@ func (sm ServiceNonOverlapper) Run(feed *gtfsparser.Feed) {
 				weeknums = append(weeknums, weeknum)
 			}
 
+			if wd == 7 {
+				id := sm.holidayId(t.Dates, feed)
+				sm.writeDayType(id, t, feed)
+				continue
+			}
+
 			id := sm.DayNames[t.Dates[0].GetTime().Weekday()]
 
 			if len(day_types[wd]) > 1 {
@@ -134,24 +149,65 @@ func (sm ServiceNonOverlapper) Run(feed *gtfsparser.Feed) {
 				}
 			}
 
-			exceptions := make(map[gtfs.Date]bool)
-			for _, d := range t.Dates {
-				exceptions[d] = true
-			}
+			sm.writeDayType(id, t, feed)
+		}
+	}
 
-			feed.Services[id] = gtfs.EmptyService()
-			feed.Services[id].SetId(id)
-			feed.Services[id].SetExceptions(exceptions)
+	fmt.Fprintf(os.Stdout, "done. (created %d calendar_dates.txt entries, %d monday, %d tuesday, %d wednesday, %d thursday, %d friday, %d saturday, %d sunday, %d holiday types)\n", len(feed.Services), len(day_types[1]), len(day_types[2]), len(day_types[3]), len(day_types[4]), len(day_types[5]), len(day_types[6]), len(day_types[0]), len(day_types[7]))
+}
 
-			for _, trip := range t.Trips {
-				newt := *trip
-				newt.Id = newt.Id + ":" + id
-				newt.Service = feed.Services[id]
-				newt.StopTimes = append(gtfs.StopTimes{}, trip.StopTimes...)
-				feed.Trips[newt.Id] = &newt
-			}
+// Write a single day type as a service with ID id
+func (sm ServiceNonOverlapper) writeDayType(id string, t DayType, feed *gtfsparser.Feed) {
+	exceptions := make(map[gtfs.Date]bool)
+	for _, d := range t.Dates {
+		exceptions[d] = true
+	}
+
+	feed.Services[id] = gtfs.EmptyService()
+	feed.Services[id].SetId(id)
+	feed.Services[id].SetExceptions(exceptions)
+
+	for _, trip := range t.Trips {
+		newt := *trip
+		newt.Id = newt.Id + ":" + id
+		newt.Service = feed.Services[id]
+		newt.StopTimes = append(gtfs.StopTimes{}, trip.StopTimes...)
+		feed.Trips[newt.Id] = &newt
+	}
+}
+
+// Build a readable ID for a holiday day type, like "Holiday (Christmas Day, Boxing Day)".
+// Unnamed holidays are identified by their date.
+func (sm ServiceNonOverlapper) holidayId(dates []gtfs.Date, feed *gtfsparser.Feed) string {
+	prefix := sm.HolidayName
+	if len(prefix) == 0 {
+		prefix = "Holiday"
+	}
+
+	names := make([]string, 0)
+	datestrs := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, d := range dates {
+		datestr := fmt.Sprintf("%04d-%02d-%02d", d.Year(), d.Month(), d.Day())
+		datestrs = append(datestrs, datestr)
+
+		name := sm.Holidays[d]
+		if len(name) == 0 {
+			name = datestr
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
 		}
 	}
 
-	fmt.Fprintf(os.Stdout, "done. (created %d calendar_dates.txt entries, %d monday, %d tuesday, %d wednesday, %d thursday, %d friday, %d saturday, %d sunday types)\n", len(feed.Services), len(day_types[1]), len(day_types[2]), len(day_types[3]), len(day_types[4]), len(day_types[5]), len(day_types[6]), len(day_types[0]))
+	id := prefix + " (" + strings.Join(names, ", ") + ")"
+
+	if _, ok := feed.Services[id]; ok {
+		// the same holidays are served by different day types in different years
+		id = prefix + " (" + strings.Join(datestrs, ", ") + ")"
+	}
+
+	return id
 }
@@ -0,0 +1,279 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"strconv"
+)
+
+// ShapeCleaner removes spikes and zig-zags from shapes. A point is removed if
+// the shape turns back on itself at this point (the turn angle exceeds
+// MaxTurnAngle degrees), or if it creates a detour MaxDetourFactor times longer
+// than the direct connection between its neighbors. A point is kept if a
+// stop served on the shape is within ProtectDist meters of the two segments
+// meeting at it, but not of the segment which would replace them, so that
+// spurs and turnarounds leading to stops survive. Cleaned shapes are
+// re-measured in meters, and the stop times of their trips are updated.
+type ShapeCleaner struct {
+	MaxTurnAngle    float64
+	MaxDetourFactor float64
+	ProtectDist     float64
+	ReportFile      string
+}
+
+// Run this ShapeCleaner on some feed
+func (sc ShapeCleaner) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Cleaning shapes... ")
+
+	report := NewReport("shape_id", "points_before", "points_after", "removed_points")
+
+	// build shape-to-trip index
+	tidx := make(map[*gtfs.Shape][]*gtfs.Trip)
+
+	for _, t := range feed.Trips {
+		if t.Shape != nil {
+			tidx[t.Shape] = append(tidx[t.Shape], t)
+		}
+	}
+
+	shapes := make([]*gtfs.Shape, 0, len(feed.Shapes))
+	for _, s := range feed.Shapes {
+		shapes = append(shapes, s)
+	}
+
+	numchunks := MaxParallelism()
+	sem := make(chan empty, numchunks)
+	removed := make([]int, len(shapes))
+	measures := make([][]float64, len(shapes))
+	oldPts := make([]gtfs.ShapePoints, len(shapes))
+
+	for c := 0; c < numchunks; c++ {
+		go func(c int) {
+			for i := c; i < len(shapes); i += numchunks {
+				oldPts[i] = shapes[i].Points
+				removed[i], measures[i] = sc.cleanShape(shapes[i], tidx[shapes[i]])
+			}
+			sem <- empty{}
+		}(c)
+	}
+
+	for c := 0; c < numchunks; c++ {
+		<-sem
+	}
+
+	// stop times may be shared between trips, only remeasure them once
+	remeasured := make(map[*gtfs.StopTime]bool)
+	numRemoved := 0
+
+	for i, shp := range shapes {
+		if removed[i] == 0 {
+			continue
+		}
+
+		numRemoved += removed[i]
+		report.Add(shp.Id, strconv.Itoa(len(oldPts[i])), strconv.Itoa(len(shp.Points)), strconv.Itoa(removed[i]))
+
+		if measures[i] == nil {
+			continue
+		}
+
+		for _, t := range tidx[shp] {
+			if len(t.StopTimes) > 0 && !remeasured[&t.StopTimes[0]] {
+				remeasured[&t.StopTimes[0]] = true
+				remapStopTimeMeasures(t, oldPts[i], measures[i])
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (-%d shape points, %d shapes modified%s)\n", numRemoved, report.Len(), report.writeIfRequested(sc.ReportFile))
+}
+
+// Clean a single shape. Returns the number of removed points and, if the
+// shape was measured before, the new measure of each original point.
+func (sc *ShapeCleaner) cleanShape(shp *gtfs.Shape, trips []*gtfs.Trip) (int, []float64) {
+	if len(shp.Points) < 3 {
+		return 0, nil
+	}
+
+	measured := true
+	for i, p := range shp.Points {
+		if !p.HasDistanceTraveled() || (i > 0 && p.Dist_traveled < shp.Points[i-1].Dist_traveled) {
+			measured = false
+			break
+		}
+	}
+
+	if !measured {
+		// we cannot remap measured stop times on an unmeasured shape
+		for _, t := range trips {
+			for i := range t.StopTimes {
+				if t.StopTimes[i].HasDistanceTraveled() {
+					return 0, nil
+				}
+			}
+		}
+	}
+
+	mercs := make([][2]float64, len(shp.Points))
+	for i, p := range shp.Points {
+		mercs[i][0], mercs[i][1] = latLngToWebMerc(p.Lat, p.Lon)
+	}
+
+	stops := sc.getServedStops(trips)
+
+	keep := make([]bool, len(shp.Points))
+	for i := range keep {
+		keep[i] = true
+	}
+
+	// removing a point may create a new spike, so repeat until stable
+	for changed := true; changed; {
+		changed = false
+		prev := 0
+
+		for i := 1; i < len(mercs)-1; i++ {
+			if !keep[i] {
+				continue
+			}
+
+			next := i + 1
+			for next < len(mercs)-1 && !keep[next] {
+				next++
+			}
+
+			if sc.isSpike(mercs[prev], mercs[i], mercs[next]) && !sc.leadsToStop(mercs[prev], mercs[i], mercs[next], stops) {
+				keep[i] = false
+				changed = true
+				continue
+			}
+
+			prev = i
+		}
+	}
+
+	numRemoved := 0
+	for _, k := range keep {
+		if !k {
+			numRemoved++
+		}
+	}
+
+	if numRemoved == 0 {
+		return 0, nil
+	}
+
+	// build the cleaned shape, measured in meters
+	newPts := make(gtfs.ShapePoints, 0, len(shp.Points)-numRemoved)
+	kept := make([]int, 0, len(shp.Points)-numRemoved)
+	d := 0.0
+
+	for i, p := range shp.Points {
+		if !keep[i] {
+			continue
+		}
+		if len(kept) > 0 {
+			last := shp.Points[kept[len(kept)-1]]
+			d += haversine(float64(last.Lat), float64(last.Lon), float64(p.Lat), float64(p.Lon))
+		}
+		p.Dist_traveled = float32(d)
+		p.Sequence = uint32(len(newPts))
+		newPts = append(newPts, p)
+		kept = append(kept, i)
+	}
+
+	var measures []float64
+
+	if measured {
+		// new measure of each old point, removed points are projected onto
+		// the cleaned segment they were cut from
+		measures = make([]float64, len(shp.Points))
+		k := 0
+		for i := range shp.Points {
+			if keep[i] {
+				measures[i] = float64(newPts[k].Dist_traveled)
+				k++
+				continue
+			}
+
+			a, b := kept[k-1], kept[k]
+			_, _, progr := snapToWithProgr(mercs[i][0], mercs[i][1], mercs[a][0], mercs[a][1], mercs[b][0], mercs[b][1])
+			measures[i] = float64(newPts[k-1].Dist_traveled) + progr*float64(newPts[k].Dist_traveled-newPts[k-1].Dist_traveled)
+		}
+	}
+
+	shp.Points = newPts
+
+	return numRemoved, measures
+}
+
+// True if point b, between a and c, is a spike
+func (sc *ShapeCleaner) isSpike(a, b, c [2]float64) bool {
+	ab := dist(a[0], a[1], b[0], b[1])
+	bc := dist(b[0], b[1], c[0], c[1])
+	ac := dist(a[0], a[1], c[0], c[1])
+
+	if ab == 0 || bc == 0 {
+		return false
+	}
+
+	// turn angle at b, 0 means straight ahead, 180 a full reversal
+	cos := ((b[0]-a[0])*(c[0]-b[0]) + (b[1]-a[1])*(c[1]-b[1])) / (ab * bc)
+	angle := math.Acos(math.Max(-1, math.Min(1, cos))) / DEG_TO_RAD
+
+	if angle > sc.MaxTurnAngle {
+		return true
+	}
+
+	return ac > 0 && (ab+bc)/ac > sc.MaxDetourFactor
+}
+
+// Get the web mercator positions of the stops served by trips, together
+// with ProtectDist in web mercator units at their position
+func (sc *ShapeCleaner) getServedStops(trips []*gtfs.Trip) [][3]float64 {
+	ret := make([][3]float64, 0)
+
+	stops := make(map[*gtfs.Stop]bool)
+	for _, t := range trips {
+		for i := range t.StopTimes {
+			s := t.StopTimes[i].Stop()
+			if stops[s] {
+				continue
+			}
+			stops[s] = true
+
+			lat, lon := getStopLatLon(s)
+			x, y := latLngToWebMerc(lat, lon)
+
+			// web mercator distances are stretched by 1/cos(lat)
+			d := sc.ProtectDist / math.Max(0.01, math.Cos(float64(lat)*DEG_TO_RAD))
+
+			ret = append(ret, [3]float64{x, y, d})
+		}
+	}
+
+	return ret
+}
+
+// True if removing b, between a and c, would move the shape away from a
+// served stop, that is if the stop is near a-b or b-c, but not near a-c
+func (sc *ShapeCleaner) leadsToStop(a, b, c [2]float64, stops [][3]float64) bool {
+	for _, s := range stops {
+		if perpendicularDist(s[0], s[1], a[0], a[1], c[0], c[1]) <= s[2] {
+			continue
+		}
+		if perpendicularDist(s[0], s[1], a[0], a[1], b[0], b[1]) <= s[2] || perpendicularDist(s[0], s[1], b[0], b[1], c[0], c[1]) <= s[2] {
+			return true
+		}
+	}
+
+	return false
+}
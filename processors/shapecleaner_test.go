@@ -0,0 +1,93 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"testing"
+)
+
+func TestShapeCleaner(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	shp := &gtfs.Shape{Id: "s"}
+	pts := [][2]float32{{48, 7.80}, {48, 7.81}, {48, 7.82}, {48.05, 7.825}, {48, 7.83}, {48, 7.84}, {48, 7.835}, {48, 7.85}}
+	for i, p := range pts {
+		shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: p[0], Lon: p[1], Sequence: uint32(i), Dist_traveled: float32(i * 10)})
+	}
+	feed.Shapes["s"] = shp
+
+	a := &gtfs.Stop{Id: "a", Lat: 48, Lon: 7.80}
+	b := &gtfs.Stop{Id: "b", Lat: 48, Lon: 7.83}
+	c := &gtfs.Stop{Id: "c", Lat: 48, Lon: 7.85}
+
+	trip := &gtfs.Trip{Id: "t", Shape: shp}
+	for i, s := range []*gtfs.Stop{a, b, c} {
+		st := gtfs.StopTime{}
+		st.SetStop(s)
+		st.SetShape_dist_traveled([]float32{0, 40, 70}[i])
+		trip.StopTimes = append(trip.StopTimes, st)
+	}
+	feed.Trips["t"] = trip
+
+	proc := ShapeCleaner{MaxTurnAngle: 170, MaxDetourFactor: 5, ProtectDist: 20}
+	proc.Run(feed)
+
+	// both the spike and the reversal are removed
+	if len(shp.Points) != 6 {
+		t.Fatal(shp.Points)
+	}
+
+	for i, p := range shp.Points {
+		if p.Lat != 48 || int(p.Sequence) != i {
+			t.Error(p)
+		}
+	}
+
+	// stop times are re-measured in meters
+	if trip.StopTimes[0].Shape_dist_traveled() != 0 {
+		t.Error(trip.StopTimes[0].Shape_dist_traveled())
+	}
+
+	if math.Abs(float64(trip.StopTimes[1].Shape_dist_traveled())-haversine(48, 7.80, 48, 7.83)) > 1 {
+		t.Error(trip.StopTimes[1].Shape_dist_traveled())
+	}
+
+	if trip.StopTimes[2].Shape_dist_traveled() != shp.Points[5].Dist_traveled {
+		t.Error(trip.StopTimes[2].Shape_dist_traveled(), shp.Points[5].Dist_traveled)
+	}
+
+	// reversals at stops are kept
+	shp.Points = nil
+	for i, lon := range []float32{7.80, 7.82, 7.83, 7.825, 7.84} {
+		shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: 48, Lon: lon, Sequence: uint32(i), Dist_traveled: float32(i * 10)})
+	}
+	for i := range trip.StopTimes {
+		trip.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+	}
+
+	proc.Run(feed)
+
+	if len(shp.Points) != 4 || shp.Points[2].Lon != 7.83 {
+		t.Error(shp.Points)
+	}
+
+	// an out-and-back spur to a stop, with its apex far from the stop
+	shp.Points = nil
+	for i, p := range [][2]float32{{48, 7.80}, {48, 7.81}, {48.002, 7.81}, {48, 7.8101}, {48, 7.85}} {
+		shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: p[0], Lon: p[1], Sequence: uint32(i), Dist_traveled: float32(i * 10)})
+	}
+	b.Lat, b.Lon = 48.001, 7.81
+
+	proc.Run(feed)
+
+	if len(shp.Points) != 5 {
+		t.Error(shp.Points)
+	}
+}
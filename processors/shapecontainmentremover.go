@@ -0,0 +1,306 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+)
+
+// ShapeContainmentRemover removes shapes which are completely contained (within
+// MaxEqDist) in a longer shape, e.g. shapes of short-turn trips. Trips using
+// a contained shape are moved to the containing shape, and the shape_dist_traveled
+// values of their stop times are re-measured along it. Only shapes that are
+// fully measured are considered, so a ShapeRemeasurer should be run before.
+// Shapes used by trips with stop times without shape_dist_traveled are kept,
+// as these stop times could not be re-measured.
+type ShapeContainmentRemover struct {
+	MaxEqDist float64
+	deleted   map[*gtfs.Shape]bool
+	mercs     map[*gtfs.Shape][][]float64
+	lengths   map[*gtfs.Shape]float64
+}
+
+// stop times of a trip moved away from a shape
+type shapeMoveKey struct {
+	st  *gtfs.StopTime
+	shp *gtfs.Shape
+}
+
+// Run this ShapeContainmentRemover on some feed
+func (scr ShapeContainmentRemover) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Removing contained shapes... ")
+
+	scr.deleted = make(map[*gtfs.Shape]bool)
+	scr.mercs = make(map[*gtfs.Shape][][]float64)
+	scr.lengths = make(map[*gtfs.Shape]float64)
+
+	shapes := make([]*gtfs.Shape, 0)
+
+	for _, s := range feed.Shapes {
+		if len(s.Points) < 2 || !scr.isMeasured(s) {
+			continue
+		}
+
+		l := 0.0
+		for i, p := range s.Points {
+			x, y := latLngToWebMerc(p.Lat, p.Lon)
+			scr.mercs[s] = append(scr.mercs[s], []float64{x, y})
+			if i > 0 {
+				l += dist(scr.mercs[s][i-1][0], scr.mercs[s][i-1][1], x, y)
+			}
+		}
+
+		scr.lengths[s] = l
+		shapes = append(shapes, s)
+	}
+
+	// shortest shapes first, ties broken by ID for determinism
+	sort.Slice(shapes, func(i, j int) bool {
+		if scr.lengths[shapes[i]] == scr.lengths[shapes[j]] {
+			return shapes[i].Id < shapes[j].Id
+		}
+		return scr.lengths[shapes[i]] < scr.lengths[shapes[j]]
+	})
+
+	idx := NewShapeIdx(shapes, scr.mercs, 5000, 5000)
+
+	// build shape-to-trip index
+	tidx := make(map[*gtfs.Shape][]*gtfs.Trip)
+
+	for _, t := range feed.Trips {
+		if t.Shape != nil {
+			tidx[t.Shape] = append(tidx[t.Shape], t)
+		}
+	}
+
+	// stop times may be shared between trips, only remeasure them once per
+	// shape they are moved away from. Trips moved to a shape which is later
+	// found to be contained itself are moved (and remeasured) again.
+	remeasured := make(map[shapeMoveKey]bool)
+
+	bef := len(feed.Shapes)
+
+	for _, s := range shapes {
+		if !scr.tripsMeasured(tidx[s]) {
+			continue
+		}
+
+		cands := make([]*gtfs.Shape, 0)
+		for c := range idx.GetNeighbors(scr.mercs[s], scr.MaxEqDist) {
+			if c == s || scr.deleted[c] || scr.lengths[c] < scr.lengths[s] || (scr.lengths[c] == scr.lengths[s] && c.Id < s.Id) {
+				continue
+			}
+			cands = append(cands, c)
+		}
+
+		// prefer the longest container
+		sort.Slice(cands, func(i, j int) bool {
+			if scr.lengths[cands[i]] == scr.lengths[cands[j]] {
+				return cands[i].Id < cands[j].Id
+			}
+			return scr.lengths[cands[i]] > scr.lengths[cands[j]]
+		})
+
+		for _, c := range cands {
+			measures := scr.getContainedMeasures(s, c)
+			if measures == nil {
+				continue
+			}
+
+			for _, t := range tidx[s] {
+				if len(t.StopTimes) > 0 && !remeasured[shapeMoveKey{&t.StopTimes[0], s}] {
+					remeasured[shapeMoveKey{&t.StopTimes[0], s}] = true
+					remapStopTimeMeasures(t, s.Points, measures)
+				}
+				t.Shape = c
+				tidx[c] = append(tidx[c], t)
+			}
+
+			delete(tidx, s)
+			scr.deleted[s] = true
+			feed.DeleteShape(s.Id)
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (-%d shapes [-%.2f%%])\n",
+		bef-len(feed.Shapes),
+		100.0*float64(bef-len(feed.Shapes))/(float64(bef)+0.001))
+}
+
+// True if all stop times of trips have a shape_dist_traveled value
+func (scr *ShapeContainmentRemover) tripsMeasured(trips []*gtfs.Trip) bool {
+	for _, t := range trips {
+		for i := range t.StopTimes {
+			if !t.StopTimes[i].HasDistanceTraveled() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// True if every point of shp has a shape_dist_traveled value, and the values
+// are non-decreasing
+func (scr *ShapeContainmentRemover) isMeasured(shp *gtfs.Shape) bool {
+	for i, p := range shp.Points {
+		if !p.HasDistanceTraveled() {
+			return false
+		}
+		if i > 0 && p.Dist_traveled < shp.Points[i-1].Dist_traveled {
+			return false
+		}
+	}
+	return true
+}
+
+// If shape a is contained in shape b, return the measures on b of each point
+// of a. Otherwise, nil is returned.
+func (scr *ShapeContainmentRemover) getContainedMeasures(a, b *gtfs.Shape) []float64 {
+	am := scr.mercs[a]
+	bm := scr.mercs[b]
+
+	// try every position on b near the first point of a as a starting point,
+	// because b may pass the start of a several times (e.g. on loops)
+	for start := 0; start < len(bm)-1; start++ {
+		if perpendicularDist(am[0][0], am[0][1], bm[start][0], bm[start][1], bm[start+1][0], bm[start+1][1]) > scr.MaxEqDist {
+			continue
+		}
+
+		// skip segments which would give the same projection
+		if start > 0 && perpendicularDist(am[0][0], am[0][1], bm[start-1][0], bm[start-1][1], bm[start][0], bm[start][1]) <= scr.MaxEqDist {
+			continue
+		}
+
+		measures, endSeg := scr.snapForward(a, b, start)
+
+		if measures != nil && scr.coversOnly(a, b, start, endSeg) {
+			return measures
+		}
+	}
+
+	return nil
+}
+
+// Snap the points of a in order onto b, beginning at segment start of b. Returns
+// the measures of a's points on b and the last segment used, or nil if a leaves b.
+func (scr *ShapeContainmentRemover) snapForward(a, b *gtfs.Shape, start int) ([]float64, int) {
+	am := scr.mercs[a]
+	bm := scr.mercs[b]
+
+	measures := make([]float64, len(am))
+	seg := start
+	segProgr := 0.0
+	step := 10.0
+
+	snap := func(px, py, alongA float64) (float64, bool) {
+		// only search as far along b as we moved along a, plus some slack
+		maxAlong := alongA*1.5 + 2*scr.MaxEqDist + 1
+		along := -segProgr * dist(bm[seg][0], bm[seg][1], bm[seg+1][0], bm[seg+1][1])
+
+		bestD := math.Inf(1)
+		bestSeg := -1
+		bestT := 0.0
+
+		for i := seg; i < len(bm)-1 && along <= maxAlong; i++ {
+			_, _, t := snapToWithProgr(px, py, bm[i][0], bm[i][1], bm[i+1][0], bm[i+1][1])
+			if i == seg && t < segProgr {
+				t = segProgr
+			}
+			x := bm[i][0] + t*(bm[i+1][0]-bm[i][0])
+			y := bm[i][1] + t*(bm[i+1][1]-bm[i][1])
+			d := dist(px, py, x, y)
+
+			if d < bestD {
+				bestD = d
+				bestSeg = i
+				bestT = t
+			}
+
+			along += dist(bm[i][0], bm[i][1], bm[i+1][0], bm[i+1][1])
+		}
+
+		if bestSeg < 0 || bestD > scr.MaxEqDist {
+			return 0, false
+		}
+
+		seg = bestSeg
+		segProgr = bestT
+
+		pa := b.Points[seg].Dist_traveled
+		pb := b.Points[seg+1].Dist_traveled
+		return float64(pa) + bestT*float64(pb-pa), true
+	}
+
+	for i := 0; i < len(am); i++ {
+		if i > 0 {
+			d := dist(am[i-1][0], am[i-1][1], am[i][0], am[i][1])
+
+			// also check interpolated points between the shape points
+			for curD := step; curD < d; curD += step {
+				px := am[i-1][0] + (am[i][0]-am[i-1][0])*curD/d
+				py := am[i-1][1] + (am[i][1]-am[i-1][1])*curD/d
+				if _, ok := snap(px, py, step); !ok {
+					return nil, 0
+				}
+			}
+
+			m, ok := snap(am[i][0], am[i][1], d-math.Floor(d/step)*step)
+			if !ok {
+				return nil, 0
+			}
+			measures[i] = m
+		} else {
+			m, ok := snap(am[i][0], am[i][1], 0)
+			if !ok {
+				return nil, 0
+			}
+			measures[i] = m
+		}
+	}
+
+	return measures, seg
+}
+
+// True if the section of b between segments from and to lies within
+// MaxEqDist of a, i.e. b does not take any detour a doesn't take
+func (scr *ShapeContainmentRemover) coversOnly(a, b *gtfs.Shape, from, to int) bool {
+	am := scr.mercs[a]
+	bm := scr.mercs[b]
+
+	last := 0
+
+	for i := from + 1; i <= to; i++ {
+		minD := math.Inf(1)
+		minSeg := last
+
+		for j := last; j < len(am)-1; j++ {
+			d := perpendicularDist(bm[i][0], bm[i][1], am[j][0], am[j][1], am[j+1][0], am[j+1][1])
+			if d < minD {
+				minD = d
+				minSeg = j
+			}
+			if minD <= scr.MaxEqDist && d > minD+scr.MaxEqDist*10 {
+				// moved away again
+				break
+			}
+		}
+
+		if minD > scr.MaxEqDist {
+			return false
+		}
+
+		last = minSeg
+	}
+
+	return true
+}
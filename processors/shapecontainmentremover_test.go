@@ -0,0 +1,123 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"testing"
+)
+
+func straightShape(id string, lat float32, fromLon, toLon float32, n int, measOffset float32) *gtfs.Shape {
+	shp := &gtfs.Shape{Id: id}
+	for i := 0; i < n; i++ {
+		lon := fromLon + (toLon-fromLon)*float32(i)/float32(n-1)
+		shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: lat, Lon: lon, Sequence: uint32(i), Dist_traveled: measOffset + float32(i)*100})
+	}
+	return shp
+}
+
+func TestShapeContainmentRemover(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	long := straightShape("long", 48, 7.80, 7.90, 11, 0)
+	short := straightShape("short", 48, 7.83, 7.86, 4, 0)
+	other := straightShape("other", 48.1, 7.83, 7.86, 4, 0)
+
+	feed.Shapes["long"] = long
+	feed.Shapes["short"] = short
+	feed.Shapes["other"] = other
+
+	trip := &gtfs.Trip{Id: "t", Shape: short}
+	for _, m := range []float32{0, 150, 300} {
+		st := gtfs.StopTime{}
+		st.SetShape_dist_traveled(m)
+		trip.StopTimes = append(trip.StopTimes, st)
+	}
+	feed.Trips["t"] = trip
+
+	proc := ShapeContainmentRemover{MaxEqDist: 1}
+	proc.Run(feed)
+
+	if len(feed.Shapes) != 2 {
+		t.Error(feed.Shapes)
+	}
+
+	if _, ok := feed.Shapes["short"]; ok {
+		t.Error("contained shape was not removed")
+	}
+
+	if trip.Shape != long {
+		t.Error(trip.Shape.Id)
+	}
+
+	for i, exp := range []float32{300, 450, 600} {
+		if math.Abs(float64(trip.StopTimes[i].Shape_dist_traveled()-exp)) > 0.5 {
+			t.Error(i, trip.StopTimes[i].Shape_dist_traveled(), exp)
+		}
+	}
+}
+
+func TestShapeContainmentRemoverChain(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	// short is contained in mid, and mid in long, but short is too far
+	// away from long to be contained in it directly
+	long := straightShape("long", 48, 7.80, 7.90, 11, 5000)
+	mid := straightShape("mid", 48.00004, 7.82, 7.88, 7, 1000)
+	short := straightShape("short", 48.00008, 7.83, 7.86, 4, 0)
+
+	feed.Shapes["long"] = long
+	feed.Shapes["mid"] = mid
+	feed.Shapes["short"] = short
+
+	trip := &gtfs.Trip{Id: "t", Shape: short}
+	for _, m := range []float32{0, 150, 300} {
+		st := gtfs.StopTime{}
+		st.SetShape_dist_traveled(m)
+		trip.StopTimes = append(trip.StopTimes, st)
+	}
+	feed.Trips["t"] = trip
+
+	proc := ShapeContainmentRemover{MaxEqDist: 10}
+	proc.Run(feed)
+
+	if len(feed.Shapes) != 1 || trip.Shape != long {
+		t.Error(feed.Shapes)
+		return
+	}
+
+	for i, exp := range []float32{5300, 5450, 5600} {
+		if math.Abs(float64(trip.StopTimes[i].Shape_dist_traveled()-exp)) > 0.5 {
+			t.Error(i, trip.StopTimes[i].Shape_dist_traveled(), exp)
+		}
+	}
+}
+
+func TestShapeContainmentRemoverUnmeasured(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	long := straightShape("long", 48, 7.80, 7.90, 11, 0)
+	short := straightShape("short", 48, 7.83, 7.86, 4, 0)
+
+	feed.Shapes["long"] = long
+	feed.Shapes["short"] = short
+
+	// the stop times of t cannot be re-measured on long
+	trip := &gtfs.Trip{Id: "t", Shape: short, StopTimes: make(gtfs.StopTimes, 3)}
+	for i := range trip.StopTimes {
+		trip.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+	}
+	feed.Trips["t"] = trip
+
+	ShapeContainmentRemover{MaxEqDist: 1}.Run(feed)
+
+	if len(feed.Shapes) != 2 || trip.Shape != short {
+		t.Error("shape of trip without measured stop times was removed")
+	}
+}
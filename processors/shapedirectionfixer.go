@@ -0,0 +1,282 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	shapeDirOk = iota
+	shapeDirReversed
+	shapeDirMismatched
+)
+
+// ShapeDirectionFixer checks whether the stops of each trip progress along
+// the trip's shape. If the stops progress backwards, the shape is reversed
+// (or a reversed copy is created, if other trips use the shape correctly).
+// If most stops are farther than MaxDist meters away from the shape, the
+// shape does not belong to the trip and is detached from it.
+type ShapeDirectionFixer struct {
+	MaxDist    float64
+	ReportFile string
+}
+
+type shapeDirKey struct {
+	shp     *gtfs.Shape
+	pattern string
+}
+
+// Run this ShapeDirectionFixer on some feed
+func (sdf ShapeDirectionFixer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Checking shape directions... ")
+
+	report := NewReport("trip_id", "shape_id", "problem", "action", "new_shape_id")
+
+	// group trips by shape and stop pattern, the result is the same for all of them
+	groups := make(map[shapeDirKey][]*gtfs.Trip)
+	for _, t := range feed.Trips {
+		if t.Shape == nil || len(t.Shape.Points) < 2 || len(t.StopTimes) < 2 {
+			continue
+		}
+		key := shapeDirKey{t.Shape, stopPatternKey(t)}
+		groups[key] = append(groups[key], t)
+	}
+
+	keys := make([]shapeDirKey, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+
+	numchunks := MaxParallelism()
+	sem := make(chan empty, numchunks)
+	results := make([]int, len(keys))
+
+	for c := 0; c < numchunks; c++ {
+		go func(c int) {
+			for i := c; i < len(keys); i += numchunks {
+				results[i] = sdf.check(keys[i].shp, groups[keys[i]][0])
+			}
+			sem <- empty{}
+		}(c)
+	}
+
+	for c := 0; c < numchunks; c++ {
+		<-sem
+	}
+
+	// collect the trips to fix per shape
+	reversed := make(map[*gtfs.Shape][]*gtfs.Trip)
+	correct := make(map[*gtfs.Shape]bool)
+	detached := 0
+
+	for i, k := range keys {
+		switch results[i] {
+		case shapeDirOk:
+			correct[k.shp] = true
+		case shapeDirReversed:
+			reversed[k.shp] = append(reversed[k.shp], groups[k]...)
+		case shapeDirMismatched:
+			for _, t := range groups[k] {
+				report.Add(t.Id, t.Shape.Id, "mismatched", "detached", "")
+				t.Shape = nil
+				for j := range t.StopTimes {
+					t.StopTimes[j].SetShape_dist_traveled(float32(math.NaN()))
+				}
+				detached++
+			}
+		}
+	}
+
+	shps := make([]*gtfs.Shape, 0, len(reversed))
+	for shp := range reversed {
+		shps = append(shps, shp)
+	}
+
+	sort.Slice(shps, func(i, j int) bool {
+		return shps[i].Id < shps[j].Id
+	})
+
+	numReversed := 0
+	numCopied := 0
+
+	// stop times may be shared between trips, only remeasure them once
+	remeasured := make(map[*gtfs.StopTime]bool)
+
+	for _, shp := range shps {
+		target := shp
+		action := "reversed shape"
+
+		if correct[shp] {
+			// shape is used correctly by other trips, create a reversed copy
+			target = &gtfs.Shape{Id: freeShapeId("rev", shp.Id, feed), Points: append(gtfs.ShapePoints{}, shp.Points...)}
+			feed.Shapes[target.Id] = target
+			action = "created reversed copy"
+			numCopied++
+		} else {
+			numReversed++
+		}
+
+		sdf.reverse(target)
+
+		for _, t := range reversed[shp] {
+			report.Add(t.Id, shp.Id, "reversed", action, target.Id)
+			t.Shape = target
+
+			if len(t.StopTimes) == 0 || remeasured[&t.StopTimes[0]] {
+				continue
+			}
+			remeasured[&t.StopTimes[0]] = true
+
+			sdf.remeasure(t, target)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d shapes reversed, %d reversed shape copies created, %d trips detached from their shape%s)\n", numReversed, numCopied, detached, report.writeIfRequested(sdf.ReportFile))
+}
+
+// Check the direction of shape shp for trip t
+func (sdf *ShapeDirectionFixer) check(shp *gtfs.Shape, t *gtfs.Trip) int {
+	mercs := make([][2]float64, len(shp.Points))
+	for i, p := range shp.Points {
+		mercs[i][0], mercs[i][1] = latLngToWebMerc(p.Lat, p.Lon)
+	}
+
+	progrs := make([]float64, 0, len(t.StopTimes))
+	far := 0
+
+	for i := range t.StopTimes {
+		lat, lon := getStopLatLon(t.StopTimes[i].Stop())
+		x, y := latLngToWebMerc(lat, lon)
+
+		best := math.Inf(1)
+		bestProgr := 0.0
+
+		for j := 1; j < len(mercs); j++ {
+			a, b := mercs[j-1], mercs[j]
+			_, _, tt := snapToWithProgr(x, y, a[0], a[1], b[0], b[1])
+			d := dist(x, y, a[0]+tt*(b[0]-a[0]), a[1]+tt*(b[1]-a[1]))
+			if d < best {
+				best = d
+				bestProgr = float64(j-1) + tt
+			}
+		}
+
+		// web mercator distances are stretched by 1/cos(lat)
+		if best*math.Cos(float64(lat)*DEG_TO_RAD) > sdf.MaxDist {
+			far++
+		}
+
+		progrs = append(progrs, bestProgr)
+	}
+
+	if far*2 > len(progrs) {
+		return shapeDirMismatched
+	}
+
+	fwd := 0
+	bwd := 0
+
+	for i := 1; i < len(progrs); i++ {
+		if progrs[i] > progrs[i-1] {
+			fwd++
+		} else if progrs[i] < progrs[i-1] {
+			bwd++
+		}
+	}
+
+	if bwd > fwd*2 {
+		return shapeDirReversed
+	}
+
+	return shapeDirOk
+}
+
+// Re-measure the stop times of trip t by projecting its stops onto shape
+// shp, in stop order, so the measures never decrease. If shp is not
+// measured, the measures of the stop times are removed.
+func (sdf *ShapeDirectionFixer) remeasure(t *gtfs.Trip, shp *gtfs.Shape) {
+	measured := false
+	for i := range t.StopTimes {
+		measured = measured || t.StopTimes[i].HasDistanceTraveled()
+	}
+
+	if !measured {
+		return
+	}
+
+	for _, p := range shp.Points {
+		if !p.HasDistanceTraveled() {
+			for i := range t.StopTimes {
+				t.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+			}
+			return
+		}
+	}
+
+	mercs := make([][2]float64, len(shp.Points))
+	for i, p := range shp.Points {
+		mercs[i][0], mercs[i][1] = latLngToWebMerc(p.Lat, p.Lon)
+	}
+
+	// the position of the previous stop, as segment and progress on it
+	seg := 0
+	progr := 0.0
+
+	for i := range t.StopTimes {
+		lat, lon := getStopLatLon(t.StopTimes[i].Stop())
+		x, y := latLngToWebMerc(lat, lon)
+
+		best := math.Inf(1)
+		bestSeg := seg
+		bestProgr := progr
+
+		for j := seg; j < len(mercs)-1; j++ {
+			a, b := mercs[j], mercs[j+1]
+			_, _, tt := snapToWithProgr(x, y, a[0], a[1], b[0], b[1])
+			if j == seg && tt < progr {
+				tt = progr
+			}
+			d := dist(x, y, a[0]+tt*(b[0]-a[0]), a[1]+tt*(b[1]-a[1]))
+			if d < best {
+				best = d
+				bestSeg = j
+				bestProgr = tt
+			}
+		}
+
+		seg = bestSeg
+		progr = bestProgr
+
+		ma := float64(shp.Points[seg].Dist_traveled)
+		mb := float64(shp.Points[seg+1].Dist_traveled)
+		t.StopTimes[i].SetShape_dist_traveled(float32(ma + progr*(mb-ma)))
+	}
+}
+
+// Reverse a shape in place, keeping the range of its measurements
+func (sdf *ShapeDirectionFixer) reverse(shp *gtfs.Shape) {
+	first := shp.Points[0].Dist_traveled
+	last := shp.Points[len(shp.Points)-1].Dist_traveled
+
+	pts := make(gtfs.ShapePoints, len(shp.Points))
+
+	for i, p := range shp.Points {
+		p.Sequence = uint32(len(pts) - 1 - i)
+		if p.HasDistanceTraveled() {
+			p.Dist_traveled = first + last - p.Dist_traveled
+		}
+		pts[len(pts)-1-i] = p
+	}
+
+	shp.Points = pts
+}
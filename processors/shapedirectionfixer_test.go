@@ -0,0 +1,79 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"testing"
+)
+
+func TestShapeDirectionFixer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	shp := straightShape("s", 48, 7.80, 7.90, 11, 0)
+	feed.Shapes["s"] = shp
+
+	stops := []*gtfs.Stop{
+		{Id: "a", Lat: 48, Lon: 7.81},
+		{Id: "b", Lat: 48, Lon: 7.85},
+		{Id: "c", Lat: 48, Lon: 7.89},
+		{Id: "d", Lat: 48.5, Lon: 7.85},
+	}
+
+	addTrip := func(id string, stops ...*gtfs.Stop) *gtfs.Trip {
+		trip := addTestTrip(feed, id, stops...)
+		trip.Shape = shp
+		for i := range trip.StopTimes {
+			trip.StopTimes[i].SetShape_dist_traveled(float32(i * 100))
+		}
+		return trip
+	}
+
+	fwd := addTrip("fwd", stops[0], stops[1], stops[2])
+	bwd := addTrip("bwd", stops[2], stops[1], stops[0])
+	other := addTrip("other", stops[3], stops[3])
+
+	proc := ShapeDirectionFixer{MaxDist: 100}
+	proc.Run(feed)
+
+	if fwd.Shape != shp || shp.Points[0].Lon != 7.80 {
+		t.Error("correctly used shape was modified")
+	}
+
+	if bwd.Shape == nil || bwd.Shape == shp || bwd.Shape.Points[0].Lon != 7.90 || bwd.Shape.Points[0].Dist_traveled != 0 {
+		t.Error(bwd.Shape)
+	}
+
+	// stops are projected onto the reversed shape, measures never decrease
+	for i := 1; i < len(bwd.StopTimes); i++ {
+		if bwd.StopTimes[i].Shape_dist_traveled() < bwd.StopTimes[i-1].Shape_dist_traveled() {
+			t.Error("decreasing measures", bwd.StopTimes[i-1].Shape_dist_traveled(), bwd.StopTimes[i].Shape_dist_traveled())
+		}
+	}
+
+	m0 := bwd.StopTimes[0].Shape_dist_traveled()
+	m1 := bwd.StopTimes[1].Shape_dist_traveled()
+	m2 := bwd.StopTimes[2].Shape_dist_traveled()
+	if math.Abs(float64(m0)-float64(bwd.Shape.Points[1].Dist_traveled)) > 1 || math.Abs(float64(m2-m1)-float64(m1-m0)) > 1 {
+		t.Error(m0, m1, m2)
+	}
+
+	if other.Shape != nil || other.StopTimes[0].HasDistanceTraveled() {
+		t.Error("mismatched shape was not detached")
+	}
+
+	// without correct users, the shape is reversed in place
+	delete(feed.Trips, "fwd")
+	bwd.Shape = shp
+	proc.Run(feed)
+
+	if bwd.Shape != shp || shp.Points[0].Lon != 7.90 {
+		t.Error(shp.Points)
+	}
+}
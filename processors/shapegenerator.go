@@ -0,0 +1,581 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	osmModeRail = iota
+	osmModeTram
+	osmModeSubway
+	osmModeBus
+	osmModeFerry
+	osmModeFunicular
+	osmNumModes
+)
+
+// ShapeGenerator generates shapes for trips without a shape by map-matching
+// their stop sequence onto a network built from a local OSM extract (PBF or XML).
+// A separate network is built for rail, tram, subway, bus, ferry and funicular
+// routes. Trips that cannot be matched keep their missing shape.
+type ShapeGenerator struct {
+	OsmFile string
+
+	// maximum distance in meters between a stop and the network
+	MaxSnapDist float64
+}
+
+type osmGraphEdge struct {
+	from   int
+	to     int
+	length float64
+}
+
+type osmGraph struct {
+	lats  []float64
+	lons  []float64
+	mercs [][2]float64
+	edges []osmGraphEdge
+	adj   [][]int
+	grid  map[[2]int][]int
+}
+
+type osmCand struct {
+	edge int
+	t    float64
+	d    float64
+	lat  float64
+	lon  float64
+}
+
+type osmPattern struct {
+	mode  int
+	trips []*gtfs.Trip
+	pts   [][2]float64
+	meas  []int
+	ok    bool
+}
+
+// grid cell size of the edge index, in web mercator units
+const osmGridCellSize = 500.0
+
+// maximum number of candidate positions per stop
+const osmMaxCands = 12
+
+// Run this ShapeGenerator on some feed
+func (sg ShapeGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating shapes from OSM data... ")
+
+	// group trips without shapes by mode and stop pattern
+	patterns := make(map[int]map[string]*osmPattern)
+	needed := make([]bool, osmNumModes)
+
+	for _, t := range feed.Trips {
+		if t.Shape != nil || len(t.StopTimes) < 2 {
+			continue
+		}
+
+		mode := osmModeFromRouteType(t.Route.Type)
+		if mode < 0 {
+			continue
+		}
+
+		needed[mode] = true
+
+		if patterns[mode] == nil {
+			patterns[mode] = make(map[string]*osmPattern)
+		}
+
+		key := stopPatternKey(t)
+		if patterns[mode][key] == nil {
+			patterns[mode][key] = &osmPattern{mode: mode}
+		}
+		patterns[mode][key].trips = append(patterns[mode][key].trips, t)
+	}
+
+	if len(patterns) == 0 {
+		fmt.Fprintf(os.Stdout, "done. (+0 shapes)\n")
+		return
+	}
+
+	data, err := ReadOsm(sg.OsmFile, func(tags map[string]string) bool {
+		for mode, need := range needed {
+			if need && osmWayUsable(mode, tags) {
+				return true
+			}
+		}
+		return false
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "failed. (could not read OSM file: %s)\n", err.Error())
+		return
+	}
+
+	graphs := make([]*osmGraph, osmNumModes)
+	for mode, need := range needed {
+		if need {
+			graphs[mode] = sg.buildGraph(data, mode)
+		}
+	}
+
+	// sort patterns for deterministic shape IDs
+	pats := make([]*osmPattern, 0)
+	for _, ps := range patterns {
+		for _, p := range ps {
+			sort.Slice(p.trips, func(i, j int) bool {
+				return p.trips[i].Id < p.trips[j].Id
+			})
+			pats = append(pats, p)
+		}
+	}
+
+	sort.Slice(pats, func(i, j int) bool {
+		return pats[i].trips[0].Id < pats[j].trips[0].Id
+	})
+
+	// match patterns in parallel
+	numchunks := MaxParallelism()
+	sem := make(chan empty, numchunks)
+
+	for c := 0; c < numchunks; c++ {
+		go func(c int) {
+			for i := c; i < len(pats); i += numchunks {
+				p := pats[i]
+				p.pts, p.meas, p.ok = sg.match(graphs[p.mode], p.trips[0])
+			}
+			sem <- empty{}
+		}(c)
+	}
+
+	for c := 0; c < numchunks; c++ {
+		<-sem
+	}
+
+	// write shapes, sharing identical geometries
+	geoms := make(map[string]*gtfs.Shape)
+	newShapes := 0
+	failed := 0
+
+	for _, p := range pats {
+		if !p.ok {
+			failed += len(p.trips)
+			continue
+		}
+
+		key := geomKey(p.pts)
+		shp, ok := geoms[key]
+		var measures []float64
+
+		if !ok {
+			shp, measures = newMeasuredShape(freeShapeId("osm", p.trips[0].Id, feed), p.pts)
+			feed.Shapes[shp.Id] = shp
+			geoms[key] = shp
+			newShapes++
+		} else {
+			_, measures = newMeasuredShape(shp.Id, p.pts)
+		}
+
+		for _, t := range p.trips {
+			t.Shape = shp
+			for i := range t.StopTimes {
+				t.StopTimes[i].SetShape_dist_traveled(float32(measures[p.meas[i]]))
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d shapes, %d trips could not be matched)\n", newShapes, failed)
+}
+
+// Get the OSM network mode for a GTFS route type, or -1 if unsupported
+func osmModeFromRouteType(t int16) int {
+	switch gtfs.GetTypeFromExtended(t) {
+	case 0, 5:
+		return osmModeTram
+	case 1:
+		return osmModeSubway
+	case 2, 12:
+		return osmModeRail
+	case 3, 11:
+		return osmModeBus
+	case 4:
+		return osmModeFerry
+	case 7:
+		return osmModeFunicular
+	}
+	return -1
+}
+
+// True if a way with tags can be used by mode
+func osmWayUsable(mode int, tags map[string]string) bool {
+	switch mode {
+	case osmModeRail:
+		switch tags["railway"] {
+		case "rail", "light_rail", "narrow_gauge", "monorail", "preserved":
+			return true
+		}
+	case osmModeTram:
+		switch tags["railway"] {
+		case "tram", "light_rail":
+			return true
+		}
+	case osmModeSubway:
+		switch tags["railway"] {
+		case "subway", "light_rail", "rail":
+			return true
+		}
+	case osmModeBus:
+		if tags["access"] == "no" || tags["access"] == "private" {
+			if tags["bus"] != "yes" && tags["psv"] != "yes" && tags["bus"] != "designated" && tags["psv"] != "designated" {
+				return false
+			}
+		}
+		switch tags["highway"] {
+		case "motorway", "trunk", "primary", "secondary", "tertiary", "unclassified",
+			"residential", "service", "living_street", "road", "busway", "bus_guideway",
+			"motorway_link", "trunk_link", "primary_link", "secondary_link", "tertiary_link":
+			return true
+		}
+	case osmModeFerry:
+		return tags["route"] == "ferry"
+	case osmModeFunicular:
+		return tags["railway"] == "funicular"
+	}
+	return false
+}
+
+// Get the directions (forward, backward) in which a way can be used by mode
+func osmWayDirs(mode int, tags map[string]string) (bool, bool) {
+	if mode != osmModeBus {
+		return true, true
+	}
+
+	if tags["oneway:bus"] == "no" || tags["oneway:psv"] == "no" || tags["busway"] == "opposite_lane" {
+		return true, true
+	}
+
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		return true, false
+	case "-1", "reverse":
+		return false, true
+	case "no", "false", "0":
+		return true, true
+	}
+
+	if tags["junction"] == "roundabout" || tags["highway"] == "motorway" || tags["highway"] == "motorway_link" {
+		return true, false
+	}
+
+	return true, true
+}
+
+// Build the network graph for a mode
+func (sg *ShapeGenerator) buildGraph(data *OsmData, mode int) *osmGraph {
+	g := &osmGraph{grid: make(map[[2]int][]int)}
+	nodeIds := make(map[int64]int)
+
+	getNode := func(id int64) int {
+		if nid, ok := nodeIds[id]; ok {
+			return nid
+		}
+		c := data.Nodes[id]
+		x, y := latLngToWebMerc(float32(c[0]), float32(c[1]))
+		nid := len(g.lats)
+		nodeIds[id] = nid
+		g.lats = append(g.lats, c[0])
+		g.lons = append(g.lons, c[1])
+		g.mercs = append(g.mercs, [2]float64{x, y})
+		g.adj = append(g.adj, make([]int, 0))
+		return nid
+	}
+
+	for _, w := range data.Ways {
+		if !osmWayUsable(mode, w.Tags) {
+			continue
+		}
+
+		fwd, bwd := osmWayDirs(mode, w.Tags)
+
+		for i := 1; i < len(w.Nodes); i++ {
+			if w.Nodes[i-1] == w.Nodes[i] {
+				continue
+			}
+			a := getNode(w.Nodes[i-1])
+			b := getNode(w.Nodes[i])
+			l := haversine(g.lats[a], g.lons[a], g.lats[b], g.lons[b])
+
+			if fwd {
+				g.addEdge(a, b, l)
+			}
+			if bwd {
+				g.addEdge(b, a, l)
+			}
+		}
+	}
+
+	return g
+}
+
+// Add a directed edge to the graph and the grid index
+func (g *osmGraph) addEdge(from, to int, length float64) {
+	eid := len(g.edges)
+	g.edges = append(g.edges, osmGraphEdge{from, to, length})
+	g.adj[from] = append(g.adj[from], eid)
+
+	a := g.mercs[from]
+	b := g.mercs[to]
+
+	for x := int(math.Floor(math.Min(a[0], b[0]) / osmGridCellSize)); x <= int(math.Floor(math.Max(a[0], b[0])/osmGridCellSize)); x++ {
+		for y := int(math.Floor(math.Min(a[1], b[1]) / osmGridCellSize)); y <= int(math.Floor(math.Max(a[1], b[1])/osmGridCellSize)); y++ {
+			g.grid[[2]int{x, y}] = append(g.grid[[2]int{x, y}], eid)
+		}
+	}
+}
+
+// Get candidate positions on the network for a stop
+func (sg *ShapeGenerator) getCands(g *osmGraph, lat, lon float64) []osmCand {
+	px, py := latLngToWebMerc(float32(lat), float32(lon))
+
+	// web mercator distances are stretched by 1/cos(lat)
+	rad := sg.MaxSnapDist / math.Max(0.01, math.Cos(lat*DEG_TO_RAD))
+
+	seen := make(map[int]bool)
+	ret := make([]osmCand, 0)
+
+	for x := int(math.Floor((px - rad) / osmGridCellSize)); x <= int(math.Floor((px+rad)/osmGridCellSize)); x++ {
+		for y := int(math.Floor((py - rad) / osmGridCellSize)); y <= int(math.Floor((py+rad)/osmGridCellSize)); y++ {
+			for _, eid := range g.grid[[2]int{x, y}] {
+				if seen[eid] {
+					continue
+				}
+				seen[eid] = true
+
+				e := g.edges[eid]
+				a := g.mercs[e.from]
+				b := g.mercs[e.to]
+				_, _, t := snapToWithProgr(px, py, a[0], a[1], b[0], b[1])
+
+				clat := g.lats[e.from] + t*(g.lats[e.to]-g.lats[e.from])
+				clon := g.lons[e.from] + t*(g.lons[e.to]-g.lons[e.from])
+				d := haversine(lat, lon, clat, clon)
+
+				if d <= sg.MaxSnapDist {
+					ret = append(ret, osmCand{eid, t, d, clat, clon})
+				}
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].d == ret[j].d {
+			return ret[i].edge < ret[j].edge
+		}
+		return ret[i].d < ret[j].d
+	})
+
+	if len(ret) > osmMaxCands {
+		ret = ret[:osmMaxCands]
+	}
+
+	return ret
+}
+
+// Shortest path distances from src to all nodes in targets, not exceeding
+// maxCost. If pred is not nil, it is filled with the predecessor edge of each
+// settled node.
+func (g *osmGraph) dijkstra(src int, maxCost float64, targets map[int]bool, pred map[int]int) map[int]float64 {
+	dists := map[int]float64{src: 0}
+	settled := make(map[int]float64)
+	pq := &distQueue{{src, 0}}
+	found := 0
+
+	for pq.Len() > 0 {
+		cur := heap.Pop(pq).(distItem)
+		if _, ok := settled[cur.node]; ok {
+			continue
+		}
+		settled[cur.node] = cur.dist
+
+		if targets[cur.node] {
+			found++
+			if found == len(targets) {
+				break
+			}
+		}
+
+		for _, eid := range g.adj[cur.node] {
+			e := g.edges[eid]
+			nd := cur.dist + e.length
+			if nd > maxCost {
+				continue
+			}
+			if old, ok := dists[e.to]; !ok || nd < old {
+				dists[e.to] = nd
+				if pred != nil {
+					pred[e.to] = eid
+				}
+				heap.Push(pq, distItem{e.to, nd})
+			}
+		}
+	}
+
+	return settled
+}
+
+// Get the network distance between two candidates, or +Inf if b cannot be
+// reached from a. Shortest paths from the end of a's edge are taken from dists.
+func (g *osmGraph) candDist(a, b osmCand, dists map[int]float64) float64 {
+	ea := g.edges[a.edge]
+	eb := g.edges[b.edge]
+
+	if a.edge == b.edge && b.t >= a.t {
+		return (b.t - a.t) * ea.length
+	}
+
+	d, ok := dists[eb.from]
+	if !ok {
+		return math.Inf(1)
+	}
+
+	return (1-a.t)*ea.length + d + b.t*eb.length
+}
+
+// Map-match the stop sequence of trip t onto graph g. Returns the matched
+// points, and for each stop time the index of its point.
+func (sg *ShapeGenerator) match(g *osmGraph, t *gtfs.Trip) ([][2]float64, []int, bool) {
+	n := len(t.StopTimes)
+	stopLats := make([]float64, n)
+	stopLons := make([]float64, n)
+	layers := make([][]osmCand, n)
+
+	for i := range t.StopTimes {
+		lat, lon := getStopLatLon(t.StopTimes[i].Stop())
+		stopLats[i], stopLons[i] = float64(lat), float64(lon)
+		layers[i] = sg.getCands(g, stopLats[i], stopLons[i])
+		if len(layers[i]) == 0 {
+			return nil, nil, false
+		}
+	}
+
+	// Viterbi: costs are the snapping distance plus the difference between
+	// network distance and great-circle distance between consecutive stops
+	costs := make([][]float64, n)
+	back := make([][]int, n)
+
+	// network distance between the edges of the best transition, used to
+	// bound the path search when building the geometry
+	netDists := make([][]float64, n)
+
+	costs[0] = make([]float64, len(layers[0]))
+	back[0] = make([]int, len(layers[0]))
+	for j, c := range layers[0] {
+		costs[0][j] = c.d
+	}
+
+	for i := 1; i < n; i++ {
+		costs[i] = make([]float64, len(layers[i]))
+		back[i] = make([]int, len(layers[i]))
+		netDists[i] = make([]float64, len(layers[i]))
+		for j := range costs[i] {
+			costs[i][j] = math.Inf(1)
+			back[i][j] = -1
+		}
+
+		gc := haversine(stopLats[i-1], stopLons[i-1], stopLats[i], stopLons[i])
+		maxCost := math.Max(3*gc, gc+3000)
+
+		targets := make(map[int]bool)
+		for _, b := range layers[i] {
+			targets[g.edges[b.edge].from] = true
+		}
+
+		for k, a := range layers[i-1] {
+			if math.IsInf(costs[i-1][k], 1) {
+				continue
+			}
+
+			dists := g.dijkstra(g.edges[a.edge].to, maxCost, targets, nil)
+
+			for j, b := range layers[i] {
+				d := g.candDist(a, b, dists)
+				if math.IsInf(d, 1) {
+					continue
+				}
+				c := costs[i-1][k] + math.Abs(d-gc) + b.d
+				if c < costs[i][j] {
+					costs[i][j] = c
+					back[i][j] = k
+					netDists[i][j] = dists[g.edges[b.edge].from]
+				}
+			}
+		}
+	}
+
+	best := -1
+	for j, c := range costs[n-1] {
+		if !math.IsInf(c, 1) && (best < 0 || c < costs[n-1][best]) {
+			best = j
+		}
+	}
+
+	if best < 0 {
+		return nil, nil, false
+	}
+
+	chosen := make([]osmCand, n)
+	chosenIdx := make([]int, n)
+	for i := n - 1; i >= 0; i-- {
+		chosen[i] = layers[i][best]
+		chosenIdx[i] = best
+		best = back[i][best]
+	}
+
+	// build the geometry
+	pts := [][2]float64{{chosen[0].lat, chosen[0].lon}}
+	idxs := make([]int, n)
+
+	for i := 1; i < n; i++ {
+		a := chosen[i-1]
+		b := chosen[i]
+
+		if !(a.edge == b.edge && b.t >= a.t) {
+			from := g.edges[a.edge].to
+			to := g.edges[b.edge].from
+			pred := make(map[int]int)
+
+			// the path was already found during matching, with this length
+			maxCost := netDists[i][chosenIdx[i]] + 0.01
+			if _, ok := g.dijkstra(from, maxCost, map[int]bool{to: true}, pred)[to]; !ok {
+				return nil, nil, false
+			}
+
+			path := make([]int, 0)
+			for cur := to; cur != from; cur = g.edges[pred[cur]].from {
+				path = append(path, cur)
+			}
+			path = append(path, from)
+
+			for k := len(path) - 1; k >= 0; k-- {
+				pts = append(pts, [2]float64{g.lats[path[k]], g.lons[path[k]]})
+			}
+		}
+
+		pts = append(pts, [2]float64{b.lat, b.lon})
+		idxs[i] = len(pts) - 1
+	}
+
+	return pts, idxs, true
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testOsm = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+ <node id="1" lat="48.0" lon="7.80"/>
+ <node id="2" lat="48.0" lon="7.81"/>
+ <node id="3" lat="48.01" lon="7.81"/>
+ <node id="4" lat="48.01" lon="7.82"/>
+ <node id="5" lat="48.0" lon="7.82"/>
+ <way id="10">
+  <nd ref="1"/>
+  <nd ref="2"/>
+  <nd ref="3"/>
+  <nd ref="4"/>
+  <tag k="highway" v="residential"/>
+ </way>
+ <way id="11">
+  <nd ref="2"/>
+  <nd ref="5"/>
+  <tag k="highway" v="footway"/>
+ </way>
+ <way id="12">
+  <nd ref="5"/>
+  <nd ref="4"/>
+  <tag k="highway" v="primary"/>
+  <tag k="oneway" v="-1"/>
+ </way>
+</osm>
+`
+
+func TestShapeGenerator(t *testing.T) {
+	osmFile := filepath.Join(t.TempDir(), "test.osm")
+	if err := os.WriteFile(osmFile, []byte(testOsm), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	feed := gtfsparser.NewFeed()
+
+	route := &gtfs.Route{Id: "r", Type: 3}
+	feed.Routes["r"] = route
+
+	stops := []*gtfs.Stop{
+		{Id: "a", Lat: 48.0001, Lon: 7.8},
+		{Id: "b", Lat: 48.0001, Lon: 7.81},
+		{Id: "c", Lat: 48.0001, Lon: 7.82},
+	}
+
+	for _, id := range []string{"t1", "t2"} {
+		trip := &gtfs.Trip{Id: id, Route: route}
+		for _, s := range stops {
+			st := gtfs.StopTime{}
+			st.SetStop(s)
+			st.SetShape_dist_traveled(float32(math.NaN()))
+			trip.StopTimes = append(trip.StopTimes, st)
+		}
+		feed.Trips[id] = trip
+	}
+
+	proc := ShapeGenerator{OsmFile: osmFile, MaxSnapDist: 50}
+	proc.Run(feed)
+
+	if len(feed.Shapes) != 1 {
+		t.Fatal(feed.Shapes)
+	}
+
+	shp := feed.Trips["t1"].Shape
+
+	if shp == nil || feed.Trips["t2"].Shape != shp {
+		t.Fatal("trips do not share the generated shape")
+	}
+
+	// the footway may not be used, the oneway street only in reverse
+	if len(shp.Points) != 6 || shp.Points[4].Lat != 48.01 || shp.Points[4].Lon != 7.82 {
+		t.Error(shp.Points)
+	}
+
+	last := float32(-1)
+	for _, st := range feed.Trips["t1"].StopTimes {
+		if !st.HasDistanceTraveled() || st.Shape_dist_traveled() <= last {
+			t.Error(st.Shape_dist_traveled())
+		}
+		last = st.Shape_dist_traveled()
+	}
+
+	if math.Abs(float64(last-shp.Points[len(shp.Points)-1].Dist_traveled)) > 0.01 {
+		t.Error(last, shp.Points[len(shp.Points)-1].Dist_traveled)
+	}
+}
@@ -37,13 +37,15 @@ func NewShapeIdx(shapes []*gtfs.Shape, mercs map[*gtfs.Shape][][]float64, cellWi
 			x, y := p[0], p[1]
 			if x < idx.llx {
 				idx.llx = x
-			} else if x > idx.urx {
+			}
+			if x > idx.urx {
 				idx.urx = x
 			}
 
 			if y < idx.lly {
 				idx.lly = y
-			} else if y > idx.ury {
+			}
+			if y > idx.ury {
 				idx.ury = y
 			}
 		}
@@ -60,8 +62,9 @@ func NewShapeIdx(shapes []*gtfs.Shape, mercs map[*gtfs.Shape][][]float64, cellWi
 		return &idx
 	}
 
-	idx.xWidth = uint(math.Ceil(idx.width / idx.cellWidth))
-	idx.yHeight = uint(math.Ceil(idx.height / idx.cellHeight))
+	// +1, points on the upper bounds belong to an extra cell
+	idx.xWidth = uint(math.Floor(idx.width/idx.cellWidth)) + 1
+	idx.yHeight = uint(math.Floor(idx.height/idx.cellHeight)) + 1
 
 	// resize rows
 	idx.grid = make([][]map[*gtfs.Shape]bool, idx.xWidth)
@@ -81,7 +84,7 @@ func NewShapeIdx(shapes []*gtfs.Shape, mercs map[*gtfs.Shape][][]float64, cellWi
 func (gi *ShapeIdx) Add(origShp *gtfs.Shape, shp [][]float64) {
 	for i := 1; i < len(shp); i++ {
 		ax, ay := shp[i-1][0], shp[i-1][1]
-		bx, by := shp[i][0], shp[0][1]
+		bx, by := shp[i][0], shp[i][1]
 		llx := math.Min(ax, bx)
 		lly := math.Min(ay, by)
 		urx := math.Max(ax, bx)
@@ -244,6 +247,37 @@ func (gi *ShapeIdx) GetNeighbors(shp [][]float64, d float64) map[*gtfs.Shape]boo
 	return ret
 }
 
+// GetNeighborsAlong returns all shapes sharing a grid cell with any segment
+// of shp. Unlike GetNeighbors, this also finds shapes only overlapping with
+// a small part of shp.
+func (gi *ShapeIdx) GetNeighborsAlong(shp [][]float64) map[*gtfs.Shape]bool {
+	ret := make(map[*gtfs.Shape]bool)
+
+	for i := 1; i < len(shp); i++ {
+		ax, ay := shp[i-1][0], shp[i-1][1]
+		bx, by := shp[i][0], shp[i][1]
+
+		swX := gi.getCellXFromX(math.Min(ax, bx))
+		swY := gi.getCellYFromY(math.Min(ay, by))
+
+		neX := gi.getCellXFromX(math.Max(ax, bx))
+		neY := gi.getCellYFromY(math.Max(ay, by))
+
+		for x := swX; x <= neX && x < uint(len(gi.grid)); x++ {
+			for y := swY; y <= neY && y < uint(len(gi.grid[x])); y++ {
+				if gi.grid[x][y] == nil || !gi.isects(ax, ay, bx, by, x, y) {
+					continue
+				}
+				for s := range gi.grid[x][y] {
+					ret[s] = true
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
 func (gi *ShapeIdx) getCellXFromX(x float64) uint {
 	return uint(math.Floor(math.Max(0, x-gi.llx) / gi.cellWidth))
 }
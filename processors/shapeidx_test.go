@@ -0,0 +1,56 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestShapeIdxAdd(t *testing.T) {
+	// a diagonal segment, which must be found near its end point
+	a := &gtfs.Shape{Id: "a"}
+	mercs := map[*gtfs.Shape][][]float64{a: {{0, 0}, {100, 100}}}
+
+	idx := NewShapeIdx([]*gtfs.Shape{a}, mercs, 10, 10)
+
+	if neighs := idx.GetNeighbors([][]float64{{90, 90}, {95, 95}}, 0); !neighs[a] {
+		t.Error("expected shape a to be found")
+	}
+}
+
+func TestShapeIdxBounds(t *testing.T) {
+	// the first point has the largest x and y, it must still extend the
+	// upper bounds of the index
+	a := &gtfs.Shape{Id: "a"}
+	mercs := map[*gtfs.Shape][][]float64{a: {{100, 100}, {50, 50}, {0, 0}}}
+
+	idx := NewShapeIdx([]*gtfs.Shape{a}, mercs, 10, 10)
+
+	if idx.urx != 100 || idx.ury != 100 {
+		t.Errorf("expected upper bounds 100, 100, got %f, %f", idx.urx, idx.ury)
+	}
+
+	if neighs := idx.GetNeighbors([][]float64{{60, 60}, {70, 70}}, 0); !neighs[a] {
+		t.Error("expected shape a to be found")
+	}
+
+	// b lies exactly on the upper x bound, which is a multiple of the cell
+	// width
+	b := &gtfs.Shape{Id: "b"}
+	mercs = map[*gtfs.Shape][][]float64{a: {{0, 0}, {10, 0}}, b: {{10, 0}, {10, 10}}}
+
+	idx = NewShapeIdx([]*gtfs.Shape{a, b}, mercs, 10, 10)
+
+	if idx.xWidth != 2 || idx.yHeight != 2 {
+		t.Errorf("expected 2x2 cells, got %dx%d", idx.xWidth, idx.yHeight)
+	}
+
+	if neighs := idx.GetNeighbors([][]float64{{10, 5}, {10, 6}}, 0); !neighs[b] {
+		t.Error("expected shape b to be found")
+	}
+}
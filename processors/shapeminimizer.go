@@ -7,20 +7,48 @@
 package processors
 
 import (
+	"container/heap"
 	"fmt"
 	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-// ShapeMinimizer minimizes shapes.
+const (
+	// DouglasPeucker simplification
+	DouglasPeucker = iota
+
+	// VisvalingamWhyatt simplification
+	VisvalingamWhyatt
+)
+
+// ShapeMinimizer minimizes shapes, using either Douglas-Peucker or
+// Visvalingam-Whyatt (where points with an effective area below
+// Epsilon^2 are removed). If PreserveTopology is set, segments shared by
+// several shapes are simplified only once, so that overlapping shapes stay
+// coincident. Points of different shapes closer than MaxSnapDist (in
+// meters) are treated as the same point and moved to the position of the
+// point seen first, so shared segments use exactly the same coordinates.
 type ShapeMinimizer struct {
-	Epsilon float64
+	Epsilon          float64
+	Algorithm        int
+	PreserveTopology bool
+	MaxSnapDist      float64
 }
 
 // Run this ShapeMinimizer on some feed
 func (sm ShapeMinimizer) Run(feed *gtfsparser.Feed) {
 	fmt.Fprintf(os.Stdout, "Minimizing shapes... ")
+
+	if sm.PreserveTopology {
+		sm.minimizeTopo(feed)
+		return
+	}
+
 	numchunks := MaxParallelism()
 	chunksize := (len(feed.Shapes) + numchunks - 1) / numchunks
 	chunks := make([][]*gtfs.Shape, numchunks)
@@ -41,7 +69,7 @@ func (sm ShapeMinimizer) Run(feed *gtfsparser.Feed) {
 			for _, s := range chunk {
 				bef := len(s.Points)
 				chunknum[a] += len(s.Points)
-				s.Points = sm.minimizeShape(s.Points, sm.Epsilon)
+				s.Points = sm.simplify(s.Points)
 				for i := 0; i < len(s.Points); i++ {
 					s.Points[i].Sequence = uint32(i)
 				}
@@ -97,3 +125,365 @@ func (sm *ShapeMinimizer) minimizeShape(points gtfs.ShapePoints, e float64) gtfs
 
 	return gtfs.ShapePoints{points[0], points[len(points)-1]}
 }
+
+// Simplify a single shape (or part of a shape) with the configured algorithm
+func (sm *ShapeMinimizer) simplify(points gtfs.ShapePoints) gtfs.ShapePoints {
+	if len(points) < 3 {
+		return points
+	}
+
+	if sm.Algorithm == VisvalingamWhyatt {
+		return sm.minimizeShapeVW(points, sm.Epsilon*sm.Epsilon)
+	}
+
+	return sm.minimizeShape(points, sm.Epsilon)
+}
+
+// Minimize a single shape using the Visvalingam-Whyatt algorithm, removing
+// all points with an effective area below minArea
+func (sm *ShapeMinimizer) minimizeShapeVW(points gtfs.ShapePoints, minArea float64) gtfs.ShapePoints {
+	n := len(points)
+	mercs := make([][2]float64, n)
+	for i, p := range points {
+		mercs[i][0], mercs[i][1] = latLngToWebMerc(p.Lat, p.Lon)
+	}
+
+	prev := make([]int, n)
+	next := make([]int, n)
+	areas := make([]float64, n)
+
+	area := func(i int) float64 {
+		a, b, c := mercs[prev[i]], mercs[i], mercs[next[i]]
+		return math.Abs((b[0]-a[0])*(c[1]-a[1])-(c[0]-a[0])*(b[1]-a[1])) / 2
+	}
+
+	pq := &distQueue{}
+
+	for i := 0; i < n; i++ {
+		prev[i] = i - 1
+		next[i] = i + 1
+	}
+
+	for i := 1; i < n-1; i++ {
+		areas[i] = area(i)
+		heap.Push(pq, distItem{i, areas[i]})
+	}
+
+	removed := make([]bool, n)
+
+	for pq.Len() > 0 {
+		cur := heap.Pop(pq).(distItem)
+
+		// skip outdated entries
+		if removed[cur.node] || cur.dist != areas[cur.node] {
+			continue
+		}
+
+		if cur.dist >= minArea {
+			break
+		}
+
+		removed[cur.node] = true
+		p, nx := prev[cur.node], next[cur.node]
+		next[p] = nx
+		prev[nx] = p
+
+		for _, j := range []int{p, nx} {
+			if j > 0 && j < n-1 {
+				// effective areas never decrease below the removed area
+				areas[j] = math.Max(area(j), cur.dist)
+				heap.Push(pq, distItem{j, areas[j]})
+			}
+		}
+	}
+
+	ret := make(gtfs.ShapePoints, 0)
+	for i, p := range points {
+		if !removed[i] {
+			ret = append(ret, p)
+		}
+	}
+
+	return ret
+}
+
+// Minimize all shapes, simplifying parts shared between shapes only once
+func (sm *ShapeMinimizer) minimizeTopo(feed *gtfsparser.Feed) {
+	shapes := make([]*gtfs.Shape, 0, len(feed.Shapes))
+	for _, s := range feed.Shapes {
+		shapes = append(shapes, s)
+	}
+
+	sort.Slice(shapes, func(i, j int) bool {
+		return shapes[i].Id < shapes[j].Id
+	})
+
+	mercs := make(map[*gtfs.Shape][][]float64)
+	for _, s := range shapes {
+		for _, p := range s.Points {
+			x, y := latLngToWebMerc(p.Lat, p.Lon)
+			mercs[s] = append(mercs[s], []float64{x, y})
+		}
+	}
+
+	keys := sm.getPointKeys(shapes, mercs)
+
+	segs := make(map[*gtfs.Shape]map[[2]uint64]bool)
+	pts := make(map[*gtfs.Shape]map[uint64]bool)
+
+	for _, s := range shapes {
+		segs[s] = make(map[[2]uint64]bool)
+		pts[s] = make(map[uint64]bool)
+		for i, k := range keys[s] {
+			pts[s][k] = true
+			if i > 0 {
+				segs[s][shapeSegKey(keys[s][i-1], k)] = true
+			}
+		}
+	}
+
+	idx := NewShapeIdx(shapes, mercs, 5000, 5000)
+
+	// simplified runs between anchors, shared between shapes
+	cache := make(map[string][]uint64)
+
+	n := 0
+	orign := 0
+
+	for _, s := range shapes {
+		orign += len(s.Points)
+
+		// move snapped points to their canonical position, keys encode it
+		for i, k := range keys[s] {
+			s.Points[i].Lat = math.Float32frombits(uint32(k >> 32))
+			s.Points[i].Lon = math.Float32frombits(uint32(k))
+		}
+
+		if len(s.Points) < 3 {
+			continue
+		}
+
+		neighs := make([]*gtfs.Shape, 0)
+		for o := range idx.GetNeighborsAlong(mercs[s]) {
+			if o != s {
+				neighs = append(neighs, o)
+			}
+		}
+
+		sort.Slice(neighs, func(i, j int) bool {
+			return neighs[i].Id < neighs[j].Id
+		})
+
+		anchors := sm.getAnchors(keys[s], neighs, segs, pts)
+
+		newPts := gtfs.ShapePoints{s.Points[0]}
+		for i := 1; i < len(anchors); i++ {
+			run := sm.simplifyRun(s.Points[anchors[i-1]:anchors[i]+1], keys[s][anchors[i-1]:anchors[i]+1], cache)
+			newPts = append(newPts, run[1:]...)
+		}
+
+		for i := range newPts {
+			newPts[i].Sequence = uint32(i)
+		}
+
+		n += len(s.Points) - len(newPts)
+		s.Points = newPts
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (-%d shape points [-%.2f%%], %d shared runs)\n",
+		n,
+		100.0*float64(n)/(float64(orign)+0.001),
+		len(cache))
+}
+
+// Get a key for each point of each shape. Points of different shapes
+// within MaxSnapDist of each other get the same key, which is the key of
+// the point seen first.
+func (sm *ShapeMinimizer) getPointKeys(shapes []*gtfs.Shape, mercs map[*gtfs.Shape][][]float64) map[*gtfs.Shape][]uint64 {
+	ret := make(map[*gtfs.Shape][]uint64)
+
+	type keyedPoint struct {
+		shp *gtfs.Shape
+		p   gtfs.ShapePoint
+		key uint64
+	}
+
+	// grid of already keyed points, a cell is MaxSnapDist wide in web
+	// mercator units, which are never shorter than meters
+	grid := make(map[[2]int64][]keyedPoint)
+	cell := sm.MaxSnapDist
+
+	for _, s := range shapes {
+		ret[s] = make([]uint64, len(s.Points))
+		for i, p := range s.Points {
+			ret[s][i] = shapePointKey(p)
+
+			if cell <= 0 {
+				continue
+			}
+
+			cx := int64(math.Floor(mercs[s][i][0] / cell))
+			cy := int64(math.Floor(mercs[s][i][1] / cell))
+
+			// number of cells to search in each direction, to account for
+			// the distortion of web mercator
+			r := int64(math.Ceil(1 / math.Cos(float64(p.Lat)*DEG_TO_RAD)))
+
+			best := math.Inf(1)
+			for x := cx - r; x <= cx+r; x++ {
+				for y := cy - r; y <= cy+r; y++ {
+					for _, o := range grid[[2]int64{x, y}] {
+						if o.shp == s {
+							continue
+						}
+						d := haversine(float64(p.Lat), float64(p.Lon), float64(o.p.Lat), float64(o.p.Lon))
+						if d <= sm.MaxSnapDist && d < best {
+							best = d
+							ret[s][i] = o.key
+						}
+					}
+				}
+			}
+
+			grid[[2]int64{cx, cy}] = append(grid[[2]int64{cx, cy}], keyedPoint{s, p, ret[s][i]})
+		}
+	}
+
+	return ret
+}
+
+// Get the indices of the points of a shape (given by its point keys) where
+// the set of shapes sharing the geometry changes. These points are kept,
+// everything in between is simplified as one run.
+func (sm *ShapeMinimizer) getAnchors(keys []uint64, neighs []*gtfs.Shape, segs map[*gtfs.Shape]map[[2]uint64]bool, pts map[*gtfs.Shape]map[uint64]bool) []int {
+	// for each segment, the neighbors sharing it
+	segShared := make([][]int, len(keys)-1)
+	for i := 1; i < len(keys); i++ {
+		key := shapeSegKey(keys[i-1], keys[i])
+		for j, o := range neighs {
+			if segs[o][key] {
+				segShared[i-1] = append(segShared[i-1], j)
+			}
+		}
+	}
+
+	ret := []int{0}
+
+	for i := 1; i < len(keys)-1; i++ {
+		if !intSliceEquals(segShared[i-1], segShared[i]) {
+			ret = append(ret, i)
+			continue
+		}
+
+		// other shapes touching or crossing s here
+		key := keys[i]
+		numTouching := 0
+		for _, o := range neighs {
+			if pts[o][key] {
+				numTouching++
+			}
+		}
+
+		if numTouching != len(segShared[i]) {
+			ret = append(ret, i)
+		}
+	}
+
+	return append(ret, len(keys)-1)
+}
+
+// Simplify a run of points between two anchors. Runs are simplified in a
+// canonical direction and the keys of the kept points are cached, so runs
+// with equal keys always keep the same points.
+func (sm *ShapeMinimizer) simplifyRun(run gtfs.ShapePoints, keys []uint64, cache map[string][]uint64) gtfs.ShapePoints {
+	if len(run) < 3 {
+		return run
+	}
+
+	fwd := make([]string, len(run))
+	for i, k := range keys {
+		fwd[i] = strconv.FormatUint(k, 16)
+	}
+
+	rev := make([]string, len(run))
+	for i := range fwd {
+		rev[i] = fwd[len(fwd)-1-i]
+	}
+
+	fwdKey := strings.Join(fwd, ",")
+	revKey := strings.Join(rev, ",")
+
+	reversed := revKey < fwdKey
+	key := fwdKey
+	if reversed {
+		key = revKey
+	}
+
+	keptKeys, ok := cache[key]
+
+	if !ok {
+		canonical := run
+		canonicalKeys := keys
+		if reversed {
+			canonical = make(gtfs.ShapePoints, len(run))
+			canonicalKeys = make([]uint64, len(run))
+			for i := range run {
+				canonical[i] = run[len(run)-1-i]
+				canonicalKeys[i] = keys[len(run)-1-i]
+			}
+		}
+		simple := sm.simplify(canonical)
+
+		// the simplified points are a subsequence of the canonical run
+		j := 0
+		for i, p := range canonical {
+			if j < len(simple) && p.Lat == simple[j].Lat && p.Lon == simple[j].Lon {
+				keptKeys = append(keptKeys, canonicalKeys[i])
+				j++
+			}
+		}
+		cache[key] = keptKeys
+	}
+
+	// take the kept points from this run, to keep its own measurements
+	kept := make(map[uint64]int)
+	for _, k := range keptKeys {
+		kept[k]++
+	}
+
+	ret := make(gtfs.ShapePoints, 0, len(keptKeys))
+	for i, p := range run {
+		if kept[keys[i]] > 0 {
+			kept[keys[i]]--
+			ret = append(ret, p)
+		}
+	}
+
+	return ret
+}
+
+// Get a key identifying the exact position of a shape point
+func shapePointKey(p gtfs.ShapePoint) uint64 {
+	return uint64(math.Float32bits(p.Lat))<<32 | uint64(math.Float32bits(p.Lon))
+}
+
+// Get a key identifying a shape segment from its point keys, regardless of
+// its direction
+func shapeSegKey(ka, kb uint64) [2]uint64 {
+	if kb < ka {
+		return [2]uint64{kb, ka}
+	}
+	return [2]uint64{ka, kb}
+}
+
+func intSliceEquals(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
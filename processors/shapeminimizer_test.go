@@ -67,3 +67,158 @@ func TestShapeMinimizer(t *testing.T) {
 		t.Error(feed.Shapes["B_shp"].Points[3])
 	}
 }
+
+func wigglyShape(id string, pts [][2]float32) *gtfs.Shape {
+	shp := &gtfs.Shape{Id: id}
+	for i, p := range pts {
+		shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: p[0], Lon: p[1], Sequence: uint32(i), Dist_traveled: float32(i)})
+	}
+	return shp
+}
+
+func TestShapeMinimizerTopo(t *testing.T) {
+	for _, algo := range []int{DouglasPeucker, VisvalingamWhyatt} {
+		// a slightly wiggly corridor shared by both shapes
+		corridor := make([][2]float32, 0)
+		for i := 0; i <= 20; i++ {
+			corridor = append(corridor, [2]float32{float32(i%2) * 0.00001, float32(i) * 0.001})
+		}
+
+		// A follows the corridor and branches off to the north,
+		// B comes from the south and follows the corridor backwards
+		a := append([][2]float32{}, corridor...)
+		for i := 1; i <= 10; i++ {
+			a = append(a, [2]float32{float32(i)*0.001 + float32(i%2)*0.00001, 0.02})
+		}
+
+		b := make([][2]float32, 0)
+		for i := 10; i >= 1; i-- {
+			b = append(b, [2]float32{-float32(i)*0.003 + float32(i%2)*0.00001, 0.015})
+		}
+		for i := len(corridor) - 1; i >= 0; i-- {
+			b = append(b, corridor[i])
+		}
+
+		feed := gtfsparser.NewFeed()
+		feed.Shapes["A"] = wigglyShape("A", a)
+		feed.Shapes["B"] = wigglyShape("B", b)
+
+		ShapeMinimizer{Epsilon: 20, Algorithm: algo, PreserveTopology: true}.Run(feed)
+
+		shpA := feed.Shapes["A"]
+		shpB := feed.Shapes["B"]
+
+		if len(shpA.Points) >= len(a) || len(shpB.Points) >= len(b) {
+			t.Errorf("algo %d: expected shapes to be simplified, got %d and %d points", algo, len(shpA.Points), len(shpB.Points))
+		}
+
+		inCorridor := make(map[[2]float32]bool)
+		for _, p := range corridor {
+			inCorridor[p] = true
+		}
+
+		// the corridor part must be exactly the same in both shapes
+		partA := make([]gtfs.ShapePoint, 0)
+		for _, p := range shpA.Points {
+			if inCorridor[[2]float32{p.Lat, p.Lon}] {
+				partA = append(partA, p)
+			}
+		}
+
+		partB := make([]gtfs.ShapePoint, 0)
+		for i := len(shpB.Points) - 1; i >= 0; i-- {
+			p := shpB.Points[i]
+			if inCorridor[[2]float32{p.Lat, p.Lon}] {
+				partB = append(partB, p)
+			}
+		}
+
+		if len(partA) < 2 || len(partA) >= len(corridor) {
+			t.Errorf("algo %d: expected corridor to be simplified, got %d points", algo, len(partA))
+		}
+
+		if len(partA) != len(partB) {
+			t.Errorf("algo %d: corridor has %d points in A, but %d points in B", algo, len(partA), len(partB))
+			continue
+		}
+
+		for i := range partA {
+			if partA[i].Lat != partB[i].Lat || partA[i].Lon != partB[i].Lon {
+				t.Errorf("algo %d: corridor point %d differs: %v vs %v", algo, i, partA[i], partB[i])
+			}
+		}
+
+		for i, p := range shpA.Points {
+			if p.Sequence != uint32(i) {
+				t.Error(p)
+			}
+		}
+	}
+}
+
+func TestShapeMinimizerTopoSnap(t *testing.T) {
+	// a slightly wiggly corridor followed by A and B, but B is about 0.2
+	// meters further north
+	corridor := make([][2]float32, 0)
+	for i := 0; i <= 20; i++ {
+		corridor = append(corridor, [2]float32{48 + float32(i%2)*0.00001, 7 + float32(i)*0.001})
+	}
+
+	// A continues straight to the east, B comes straight from the west
+	a := append([][2]float32{}, corridor...)
+	for i := 1; i <= 10; i++ {
+		a = append(a, [2]float32{48, 7.02 + float32(i)*0.001})
+	}
+
+	b := make([][2]float32, 0)
+	for i := 10; i >= 1; i-- {
+		b = append(b, [2]float32{48.000002, 7 - float32(i)*0.001})
+	}
+	for _, p := range corridor {
+		b = append(b, [2]float32{p[0] + 0.000002, p[1]})
+	}
+
+	for _, snap := range []float64{0, 1} {
+		feed := gtfsparser.NewFeed()
+		feed.Shapes["A"] = wigglyShape("A", a)
+		feed.Shapes["B"] = wigglyShape("B", b)
+
+		ShapeMinimizer{Epsilon: 20, Algorithm: DouglasPeucker, PreserveTopology: true, MaxSnapDist: snap}.Run(feed)
+
+		partA := make([]gtfs.ShapePoint, 0)
+		for _, p := range feed.Shapes["A"].Points {
+			if p.Dist_traveled <= 20 {
+				partA = append(partA, p)
+			}
+		}
+
+		partB := make([]gtfs.ShapePoint, 0)
+		for _, p := range feed.Shapes["B"].Points {
+			if p.Dist_traveled >= 10 {
+				partB = append(partB, p)
+			}
+		}
+
+		shared := len(partA) == len(partB)
+		for i := 0; shared && i < len(partA); i++ {
+			shared = partA[i].Lat == partB[i].Lat && partA[i].Lon == partB[i].Lon
+		}
+
+		// without snapping, nothing is shared and both shapes are
+		// simplified to straight lines
+		if shared != (snap > 0) {
+			t.Errorf("snap %f: got corridors %v and %v", snap, partA, partB)
+		}
+
+		// with snapping, B is moved onto A
+		for _, p := range partB {
+			lat := corridor[int(p.Dist_traveled)-10][0]
+			if snap == 0 {
+				lat += 0.000002
+			}
+			if p.Lat != lat {
+				t.Errorf("snap %f: unexpected point %v in B", snap, p)
+			}
+		}
+	}
+}
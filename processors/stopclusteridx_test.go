@@ -0,0 +1,45 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestStopClusterIdx(t *testing.T) {
+	// the first stop has the largest coordinates
+	a := &gtfs.Stop{Id: "a", Lat: 0.001, Lon: 0.001}
+	b := &gtfs.Stop{Id: "b", Lat: 0, Lon: 0}
+
+	clusters := []*StopCluster{{Parents: []*gtfs.Stop{a}}, {Parents: []*gtfs.Stop{b}}}
+
+	// a lies exactly on the upper bounds, one cell away from b
+	ax, ay := latLngToWebMerc(a.Lat, a.Lon)
+	bx, by := latLngToWebMerc(b.Lat, b.Lon)
+
+	idx := NewStopClusterIdx(clusters, ax-bx, ay-by)
+
+	if idx.urx != ax || idx.ury != ay {
+		t.Errorf("expected upper bounds %f, %f, got %f, %f", ax, ay, idx.urx, idx.ury)
+	}
+
+	if idx.xWidth != 2 || idx.yHeight != 2 {
+		t.Errorf("expected 2x2 cells, got %dx%d", idx.xWidth, idx.yHeight)
+	}
+
+	// searching around the lower left cell must not underflow
+	neighs := idx.GetNeighborsByLatLon(0, 0, ax-bx)
+
+	if len(neighs) != 2 || !neighs[0] || !neighs[1] {
+		t.Errorf("expected both clusters, got %v", neighs)
+	}
+
+	if neighs := idx.GetNeighborsByLatLon(0, 0, 0); len(neighs) != 1 || !neighs[1] {
+		t.Errorf("expected only cluster 1, got %v", neighs)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
 	"hash/fnv"
+	"math"
 	"os"
 	"unsafe"
 	"regexp"
@@ -24,6 +25,8 @@ type StopDuplicateRemover struct {
 	DistThresholdStation float64
 	Fuzzy                bool
 	KeepIFOPT            bool
+	Review               *StopMergeReview
+	Overrides            *StopOverrides
 	ifoptRegex           *regexp.Regexp
 }
 
@@ -102,6 +105,13 @@ func (sdr StopDuplicateRemover) Run(feed *gtfsparser.Feed) {
 			}
 		}
 
+		if i == 0 {
+			// stops which are always merged according to the overrides
+			for _, group := range sdr.Overrides.getMergeGroups(feed, true) {
+				sdr.combineStops(feed, group, stoptimes, stops, transfers, pathways)
+			}
+		}
+
 		i := 0
 
 		for _, s := range feed.Stops {
@@ -182,6 +192,10 @@ func (sdr StopDuplicateRemover) combineStops(feed *gtfsparser.Feed, stops []*gtf
 		}
 	}
 
+	if sdr.Review != nil {
+		sdr.addToReview(ref, stops)
+	}
+
 	for _, s := range stops {
 		if s == ref {
 			continue
@@ -242,6 +256,32 @@ func (sdr StopDuplicateRemover) combineStops(feed *gtfsparser.Feed, stops []*gtf
 	}
 }
 
+// Record a merge of stops into ref in the review
+func (sdr StopDuplicateRemover) addToReview(ref *gtfs.Stop, stops []*gtfs.Stop) {
+	members := make([]*gtfs.Stop, 0, len(stops)-1)
+	namesDiffer := false
+
+	for _, s := range stops {
+		if s != ref {
+			members = append(members, s)
+			namesDiffer = namesDiffer || s.Name != ref.Name
+		}
+	}
+
+	e := sdr.Review.add("StopDuplicateRemover", ref, members, math.NaN(), math.NaN())
+
+	threshold := sdr.DistThresholdStop
+	if ref.Location_type == 1 {
+		threshold = sdr.DistThresholdStation
+	}
+
+	sdr.Review.flagHigh(e, e.maxDist, threshold, threshold, "distance near threshold")
+
+	if namesDiffer {
+		e.reasons = append(e.reasons, "names differ")
+	}
+}
+
 func (sdr StopDuplicateRemover) getStopChunks(feed *gtfsparser.Feed) map[uint32][][]*gtfs.Stop {
 	numchunks := MaxParallelism()
 
@@ -389,6 +429,17 @@ func (sdr StopDuplicateRemover) ifoptEquals(ida string, idb string) bool {
 
 // Check if two stops are equal, distances under 1 m count as equal
 func (sdr StopDuplicateRemover) stopEquals(a *gtfs.Stop, b *gtfs.Stop, feed *gtfsparser.Feed) bool {
+	if !sdr.Overrides.mayMerge(a, b) {
+		return false
+	}
+
+	// never merge a stop with a parent override into a stop without one
+	_, parA := sdr.Overrides.parentId(a)
+	_, parB := sdr.Overrides.parentId(b)
+	if parA != parB {
+		return false
+	}
+
 	addFldsEq := true
 
 	if !sdr.Fuzzy {
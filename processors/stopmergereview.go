@@ -0,0 +1,241 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	geojson "github.com/paulmach/go.geojson"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StopMergeReview collects the merge decisions of StopReclusterer and
+// StopDuplicateRemover, so they can be audited before a feed is published.
+// Merges whose score, name similarity or distance is within ReviewMargin of
+// the respective threshold are flagged for manual review.
+type StopMergeReview struct {
+	ReviewMargin float64
+	entries      []*stopMergeEntry
+}
+
+type stopMergeMember struct {
+	id   string
+	name string
+	lat  float64
+	lon  float64
+	dist float64
+}
+
+type stopMergeEntry struct {
+	processor string
+	parent    stopMergeMember
+	members   []stopMergeMember
+	score     float64 // NaN if not applicable
+	nameSimi  float64 // NaN if not applicable
+	maxDist   float64 // max distance of a member to the parent
+	diameter  float64 // max distance between two members
+	reasons   []string
+}
+
+// NewStopMergeReview creates a new, empty StopMergeReview
+func NewStopMergeReview(margin float64) *StopMergeReview {
+	return &StopMergeReview{ReviewMargin: margin, entries: make([]*stopMergeEntry, 0)}
+}
+
+// Len returns the number of recorded merges
+func (r *StopMergeReview) Len() int {
+	return len(r.entries)
+}
+
+// NumFlagged returns the number of recorded merges flagged for review
+func (r *StopMergeReview) NumFlagged() int {
+	n := 0
+	for _, e := range r.entries {
+		if len(e.reasons) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Record a merge of members into parent. Must be called before any of the
+// stops are deleted from the feed.
+func (r *StopMergeReview) add(processor string, parent *gtfs.Stop, members []*gtfs.Stop, score, nameSimi float64) *stopMergeEntry {
+	e := &stopMergeEntry{processor: processor, parent: reviewMember(parent, nil), score: score, nameSimi: nameSimi}
+
+	for _, s := range members {
+		m := reviewMember(s, parent)
+		if m.dist > e.maxDist {
+			e.maxDist = m.dist
+		}
+		e.members = append(e.members, m)
+	}
+
+	for i, a := range e.members {
+		for _, b := range e.members[i+1:] {
+			if !math.IsNaN(a.lat) && !math.IsNaN(b.lat) {
+				e.diameter = math.Max(e.diameter, haversine(a.lat, a.lon, b.lat, b.lon))
+			}
+		}
+	}
+
+	r.entries = append(r.entries, e)
+	return e
+}
+
+// Flag e for review if value is below threshold, or less than ReviewMargin
+// (relative to scale) above it
+func (r *StopMergeReview) flagLow(e *stopMergeEntry, value, threshold, scale float64, reason string) {
+	if !math.IsNaN(value) && value <= threshold+r.ReviewMargin*scale {
+		e.reasons = append(e.reasons, reason)
+	}
+}
+
+// Flag e for review if value is above threshold, or less than ReviewMargin
+// (relative to scale) below it
+func (r *StopMergeReview) flagHigh(e *stopMergeEntry, value, threshold, scale float64, reason string) {
+	if !math.IsNaN(value) && value >= threshold-r.ReviewMargin*scale {
+		e.reasons = append(e.reasons, reason)
+	}
+}
+
+func reviewMember(s *gtfs.Stop, parent *gtfs.Stop) stopMergeMember {
+	m := stopMergeMember{id: s.Id, name: s.Name, lat: math.NaN(), lon: math.NaN()}
+
+	if !math.IsNaN(float64(s.Lat)) && !math.IsNaN(float64(s.Lon)) {
+		m.lat, m.lon = float64(s.Lat), float64(s.Lon)
+	} else if s.Parent_station != nil {
+		m.lat, m.lon = float64(s.Parent_station.Lat), float64(s.Parent_station.Lon)
+	}
+
+	if parent != nil && !math.IsNaN(m.lat) {
+		m.dist = haversine(m.lat, m.lon, float64(parent.Lat), float64(parent.Lon))
+	}
+
+	return m
+}
+
+// Get the entries in a deterministic order
+func (r *StopMergeReview) sorted() []*stopMergeEntry {
+	ret := append([]*stopMergeEntry{}, r.entries...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].processor != ret[j].processor {
+			return ret[i].processor < ret[j].processor
+		}
+		return ret[i].parent.id < ret[j].parent.id
+	})
+	return ret
+}
+
+// Write the review as a GeoJSON file to geojsonPath and as a CSV file to
+// csvPath. Empty paths are skipped.
+func (r *StopMergeReview) Write(geojsonPath, csvPath string) error {
+	entries := r.sorted()
+
+	if len(csvPath) > 0 {
+		report := NewReport("processor", "parent_id", "merge_id", "parent_name", "parent_lat", "parent_lon", "stop_id", "stop_name", "stop_lat", "stop_lon", "dist", "diameter", "score", "name_simi", "review", "reason")
+
+		for i, e := range entries {
+			for _, m := range e.members {
+				report.Add(e.processor, e.parent.id, strconv.Itoa(i+1), e.parent.name, fmtCoord(e.parent.lat), fmtCoord(e.parent.lon), m.id, m.name, fmtCoord(m.lat), fmtCoord(m.lon), fmtFloat(m.dist), fmtFloat(e.diameter), fmtFloat(e.score), fmtFloat(e.nameSimi), strconv.FormatBool(len(e.reasons) > 0), strings.Join(e.reasons, "; "))
+			}
+		}
+
+		if err := report.Write(csvPath); err != nil {
+			return err
+		}
+	}
+
+	if len(geojsonPath) > 0 {
+		fc := geojson.NewFeatureCollection()
+
+		for i, e := range entries {
+			if math.IsNaN(e.parent.lat) {
+				continue
+			}
+
+			ids := make([]string, len(e.members))
+			for j, m := range e.members {
+				ids[j] = m.id
+			}
+
+			f := geojson.NewPointFeature([]float64{e.parent.lon, e.parent.lat})
+			f.SetProperty("type", "merge")
+			f.SetProperty("merge_id", i+1)
+			f.SetProperty("processor", e.processor)
+			f.SetProperty("parent_id", e.parent.id)
+			f.SetProperty("parent_name", e.parent.name)
+			f.SetProperty("member_ids", ids)
+			f.SetProperty("max_dist", e.maxDist)
+			f.SetProperty("diameter", e.diameter)
+			setOptFloat(f, "score", e.score)
+			setOptFloat(f, "name_simi", e.nameSimi)
+			f.SetProperty("review", len(e.reasons) > 0)
+			f.SetProperty("reason", strings.Join(e.reasons, "; "))
+			fc.AddFeature(f)
+
+			for _, m := range e.members {
+				if math.IsNaN(m.lat) {
+					continue
+				}
+
+				f := geojson.NewPointFeature([]float64{m.lon, m.lat})
+				f.SetProperty("type", "member")
+				f.SetProperty("merge_id", i+1)
+				f.SetProperty("stop_id", m.id)
+				f.SetProperty("stop_name", m.name)
+				f.SetProperty("dist", m.dist)
+				f.SetProperty("review", len(e.reasons) > 0)
+				fc.AddFeature(f)
+
+				l := geojson.NewLineStringFeature([][]float64{{m.lon, m.lat}, {e.parent.lon, e.parent.lat}})
+				l.SetProperty("type", "link")
+				l.SetProperty("merge_id", i+1)
+				l.SetProperty("stop_id", m.id)
+				l.SetProperty("dist", m.dist)
+				l.SetProperty("review", len(e.reasons) > 0)
+				fc.AddFeature(l)
+			}
+		}
+
+		json, err := fc.MarshalJSON()
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(geojsonPath, json, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fmtCoord(f float64) string {
+	if math.IsNaN(f) {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
+
+func fmtFloat(f float64) string {
+	if math.IsNaN(f) {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+func setOptFloat(f *geojson.Feature, key string, val float64) {
+	if math.IsNaN(val) {
+		f.SetProperty(key, nil)
+	} else {
+		f.SetProperty(key, val)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStopMergeReviewDuplicates(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	if e := feed.Parse("./testfeed"); e != nil {
+		t.Error(e)
+		return
+	}
+
+	review := NewStopMergeReview(0.1)
+	StopDuplicateRemover{Review: review}.Run(feed)
+
+	found := false
+	for _, e := range review.entries {
+		if e.processor != "StopDuplicateRemover" {
+			t.Error(e.processor)
+		}
+		for _, m := range e.members {
+			if m.id == "duplicateB4" {
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		t.Error("merge of duplicateB4 not recorded")
+	}
+}
+
+func TestStopMergeReviewRecluster(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	mk := func(id, name string, lat, lon float32) {
+		feed.Stops[id] = &gtfs.Stop{Id: id, Name: name, Lat: lat, Lon: lon}
+	}
+
+	// a clear merge, and one close to the distance threshold
+	mk("a1", "Hauptbahnhof", 48.0, 7.8)
+	mk("a2", "Hauptbahnhof", 48.0, 7.8001)
+	mk("b1", "Rathaus", 48.01, 7.8)
+	mk("b2", "Rathaus", 48.01063, 7.8)
+
+	// unrelated stops, to get meaningful TF-IDF scores
+	mk("c1", "Messe", 48.1, 7.9)
+	mk("c2", "Stadion", 48.2, 7.9)
+	mk("c3", "Zoo", 48.3, 7.9)
+
+	review := NewStopMergeReview(0.1)
+	StopReclusterer{DistThreshold: 75, NameSimiThreshold: 0.55, GridCellSize: 10000, Review: review}.Run(feed)
+
+	if review.Len() != 2 {
+		t.Errorf("expected 2 merges, got %d", review.Len())
+		return
+	}
+
+	dir := t.TempDir()
+	if err := review.Write(filepath.Join(dir, "m.geojson"), filepath.Join(dir, "m.csv")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(dir, "m.csv"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// header + 2 members per merge
+	if len(rows) != 5 {
+		t.Error(rows)
+		return
+	}
+
+	for _, r := range rows[1:] {
+		flagged := r[14] == "true"
+		if (r[6] == "a1" || r[6] == "a2") && flagged {
+			t.Errorf("merge of %s should not be flagged: %v", r[6], r)
+		}
+		if (r[6] == "b1" || r[6] == "b2") && !flagged {
+			t.Errorf("merge of %s should be flagged: %v", r[6], r)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// StopOverrides holds manual corrections for stop clustering, honored by
+// StopDuplicateRemover, StopReclusterer and StopParentEnforcer. Stop IDs
+// refer to the input feeds, if multiple feeds are given, a rule applies to
+// the stop with this ID in every feed. Prefixes holds the ID prefixes the
+// input feeds were parsed with.
+type StopOverrides struct {
+	// groups of stops which are always merged
+	Merge [][]string
+
+	// groups of stops which are never merged with each other
+	Separate [][]string
+
+	// maps stop IDs to the ID of their parent station
+	Parents map[string]string
+
+	// ID prefixes of the input feeds
+	Prefixes []string
+
+	mergeGroup map[string]int
+	separated  map[[2]string]bool
+}
+
+// ReadStopOverrides reads stop overrides from a file. Each line holds one
+// rule, as a comma-separated list:
+//
+//	merge,<stop_id>,<stop_id>,...
+//	separate,<stop_id>,<stop_id>,...
+//	parent,<parent_id>,<stop_id>,<stop_id>,...
+//
+// If the parent of a parent rule does not exist, it is created. Empty lines
+// and lines starting with # are ignored.
+func ReadStopOverrides(path string) (*StopOverrides, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := &StopOverrides{Parents: make(map[string]string), mergeGroup: make(map[string]int), separated: make(map[[2]string]bool)}
+
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+
+		rule, ids := strings.ToLower(fields[0]), fields[1:]
+
+		for _, id := range ids {
+			if len(id) == 0 {
+				return nil, fmt.Errorf("line %d: empty stop ID", i)
+			}
+		}
+
+		switch rule {
+		case "merge":
+			if len(ids) < 2 {
+				return nil, fmt.Errorf("line %d: merge rule needs at least 2 stops", i)
+			}
+			ret.Merge = append(ret.Merge, ids)
+		case "separate":
+			if len(ids) < 2 {
+				return nil, fmt.Errorf("line %d: separate rule needs at least 2 stops", i)
+			}
+			ret.Separate = append(ret.Separate, ids)
+		case "parent":
+			if len(ids) < 2 {
+				return nil, fmt.Errorf("line %d: parent rule needs a parent and at least 1 stop", i)
+			}
+			for _, id := range ids[1:] {
+				if p, ok := ret.Parents[id]; ok && p != ids[0] {
+					return nil, fmt.Errorf("line %d: stop '%s' already assigned to parent '%s'", i, id, p)
+				}
+				ret.Parents[id] = ids[0]
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule '%s'", i, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	ret.init()
+
+	return ret, nil
+}
+
+// Build the lookup tables for the rules
+func (o *StopOverrides) init() {
+	o.mergeGroup = make(map[string]int)
+	o.separated = make(map[[2]string]bool)
+
+	// merge rules sharing a stop form a single group
+	uf := newUnionFind(len(o.Merge))
+	first := make(map[string]int)
+	for i, ids := range o.Merge {
+		for _, id := range ids {
+			if j, ok := first[id]; ok {
+				uf.union(i, j)
+			} else {
+				first[id] = i
+			}
+		}
+	}
+
+	for id, i := range first {
+		o.mergeGroup[id] = uf.find(i)
+	}
+
+	for _, ids := range o.Separate {
+		for i, a := range ids {
+			for _, b := range ids[i+1:] {
+				o.separated[[2]string{a, b}] = true
+				o.separated[[2]string{b, a}] = true
+
+				ga, oka := o.mergeGroup[a]
+				gb, okb := o.mergeGroup[b]
+				if oka && okb && ga == gb {
+					// conflicting rules, separation wins
+					fmt.Fprintf(os.Stderr, "Warning: stops '%s' and '%s' are both merged and separated by the stop overrides, keeping them separate.\n", a, b)
+				}
+			}
+		}
+	}
+}
+
+// Get the original ID of a stop, without the prefix of its input feed
+func (o *StopOverrides) overrideId(s *gtfs.Stop) string {
+	if o == nil {
+		return s.Id
+	}
+
+	// prefer the longest prefix, if one prefix is a prefix of another
+	best := ""
+	for _, prefix := range o.Prefixes {
+		if len(prefix) > len(best) && strings.HasPrefix(s.Id, prefix) {
+			best = prefix
+		}
+	}
+
+	return s.Id[len(best):]
+}
+
+// Get the rule ID of s, the ID as it appears in the rules (with or without
+// the input feed prefix)
+func (o *StopOverrides) ruleId(s *gtfs.Stop, rules map[string]int) (string, bool) {
+	if _, ok := rules[s.Id]; ok {
+		return s.Id, true
+	}
+	id := o.overrideId(s)
+	_, ok := rules[id]
+	return id, ok
+}
+
+// True if the rules allow a and b to be merged (or clustered). Stops
+// assigned to different parents are never merged.
+func (o *StopOverrides) mayMerge(a, b *gtfs.Stop) bool {
+	if o == nil {
+		return true
+	}
+
+	for _, ia := range []string{a.Id, o.overrideId(a)} {
+		for _, ib := range []string{b.Id, o.overrideId(b)} {
+			if o.separated[[2]string{ia, ib}] {
+				return false
+			}
+		}
+	}
+
+	pa, oka := o.parentId(a)
+	pb, okb := o.parentId(b)
+
+	return !oka || !okb || pa == pb
+}
+
+// True if the rules force a and b to be merged
+func (o *StopOverrides) mustMerge(a, b *gtfs.Stop) bool {
+	if o == nil || !o.mayMerge(a, b) {
+		return false
+	}
+
+	ia, oka := o.ruleId(a, o.mergeGroup)
+	ib, okb := o.ruleId(b, o.mergeGroup)
+
+	return oka && okb && o.mergeGroup[ia] == o.mergeGroup[ib]
+}
+
+// Get the ID of the parent assigned to s by the rules
+func (o *StopOverrides) parentId(s *gtfs.Stop) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	if p, ok := o.Parents[s.Id]; ok {
+		return p, true
+	}
+	p, ok := o.Parents[o.overrideId(s)]
+	return p, ok
+}
+
+// Get the stops of feed in each merge group, sorted by ID. Groups are
+// further split so that no two stops of a group are separated, and, if
+// byLocType is set, all stops of a group have the same location type.
+func (o *StopOverrides) getMergeGroups(feed *gtfsparser.Feed, byLocType bool) [][]*gtfs.Stop {
+	if o == nil || len(o.mergeGroup) == 0 {
+		return nil
+	}
+
+	groups := make(map[int][]*gtfs.Stop)
+
+	for _, s := range feed.Stops {
+		if id, ok := o.ruleId(s, o.mergeGroup); ok {
+			groups[o.mergeGroup[id]] = append(groups[o.mergeGroup[id]], s)
+		}
+	}
+
+	ret := make([][]*gtfs.Stop, 0)
+
+	for _, stops := range groups {
+		sort.Slice(stops, func(i, j int) bool {
+			return stops[i].Id < stops[j].Id
+		})
+
+		// greedily split the group into mergeable parts
+		parts := make([][]*gtfs.Stop, 0)
+		for _, s := range stops {
+			added := false
+			for i, part := range parts {
+				if byLocType && part[0].Location_type != s.Location_type {
+					continue
+				}
+				ok := true
+				for _, t := range part {
+					if !o.mayMerge(s, t) {
+						ok = false
+						break
+					}
+				}
+				if ok {
+					parts[i] = append(parts[i], s)
+					added = true
+					break
+				}
+			}
+			if !added {
+				parts = append(parts, []*gtfs.Stop{s})
+			}
+		}
+
+		for _, part := range parts {
+			if len(part) > 1 {
+				ret = append(ret, part)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i][0].Id < ret[j][0].Id
+	})
+
+	return ret
+}
+
+// Assign the stops of feed to the parents given by the parent rules.
+// Missing parents are created at the centroid of their stops. Returns the
+// number of stops which got a new parent.
+func (o *StopOverrides) applyParents(feed *gtfsparser.Feed) int {
+	if o == nil || len(o.Parents) == 0 {
+		return 0
+	}
+
+	// stops per parent ID
+	childs := make(map[string][]*gtfs.Stop)
+
+	for _, s := range feed.Stops {
+		if s.Location_type != 0 && s.Location_type != 2 && s.Location_type != 3 {
+			continue
+		}
+		if p, ok := o.parentId(s); ok {
+			childs[p] = append(childs[p], s)
+		}
+	}
+
+	pids := make([]string, 0, len(childs))
+	for pid := range childs {
+		pids = append(pids, pid)
+	}
+	sort.Strings(pids)
+
+	n := 0
+
+	for _, pid := range pids {
+		sort.Slice(childs[pid], func(i, j int) bool {
+			return childs[pid][i].Id < childs[pid][j].Id
+		})
+
+		parent := o.findStop(feed, pid)
+
+		if parent == nil {
+			parent = o.createParent(feed, pid, childs[pid])
+		}
+
+		if parent.Location_type != 1 {
+			fmt.Fprintf(os.Stderr, "Warning: stop override parent '%s' is not a station, ignoring.\n", pid)
+			continue
+		}
+
+		for _, s := range childs[pid] {
+			if s.Parent_station != parent {
+				s.Parent_station = parent
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// Find the stop with the given rule ID in feed
+func (o *StopOverrides) findStop(feed *gtfsparser.Feed, id string) *gtfs.Stop {
+	if s, ok := feed.Stops[id]; ok {
+		return s
+	}
+
+	ids := make([]string, 0)
+	for sid, s := range feed.Stops {
+		if o.overrideId(s) == id {
+			ids = append(ids, sid)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	sort.Strings(ids)
+	return feed.Stops[ids[0]]
+}
+
+// Create the parent station with ID pid for stops
+func (o *StopOverrides) createParent(feed *gtfsparser.Feed, pid string, stops []*gtfs.Stop) *gtfs.Stop {
+	ret := &gtfs.Stop{Id: pid, Name: stops[0].Name, Location_type: 1}
+	ret.Timezone = stops[0].Timezone
+
+	for _, s := range stops {
+		lat, lon := getStopLatLon(s)
+		ret.Lat += lat
+		ret.Lon += lon
+	}
+
+	ret.Lat /= float32(len(stops))
+	ret.Lon /= float32(len(stops))
+
+	feed.Stops[ret.Id] = ret
+
+	return ret
+}
+
+// a simple union-find structure over the integers 0..n-1
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	ret := make(unionFind, n)
+	for i := range ret {
+		ret[i] = i
+	}
+	return ret
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+func (uf unionFind) union(a, b int) {
+	ra, rb := uf.find(a), uf.find(b)
+	if ra < rb {
+		uf[rb] = ra
+	} else {
+		uf[ra] = rb
+	}
+}
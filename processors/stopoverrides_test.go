@@ -0,0 +1,180 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeStopOverrides(t *testing.T, content string) (*StopOverrides, error) {
+	path := filepath.Join(t.TempDir(), "overrides.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ReadStopOverrides(path)
+}
+
+func TestStopOverridesRead(t *testing.T) {
+	if _, err := writeStopOverrides(t, "swap,a,b\n"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+
+	if _, err := writeStopOverrides(t, "merge,a\n"); err == nil {
+		t.Error("expected error for merge rule with a single stop")
+	}
+
+	if _, err := writeStopOverrides(t, "parent,p,a\nparent,q,a\n"); err == nil {
+		t.Error("expected error for conflicting parents")
+	}
+
+	o, err := writeStopOverrides(t, "# comment\n\nmerge,a,b\nmerge, b, c\nseparate,a,d\nparent,p,e,f\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	a, c, d := &gtfs.Stop{Id: "a"}, &gtfs.Stop{Id: "1#c"}, &gtfs.Stop{Id: "d"}
+	e, f, g := &gtfs.Stop{Id: "e"}, &gtfs.Stop{Id: "f"}, &gtfs.Stop{Id: "g"}
+
+	o.Prefixes = []string{"1#"}
+
+	if !o.mustMerge(a, c) {
+		t.Error("a and c should be merged, c is merged with b")
+	}
+
+	if o.mayMerge(a, d) || o.mustMerge(a, d) {
+		t.Error("a and d must not be merged")
+	}
+
+	if !o.mayMerge(e, f) || !o.mayMerge(e, g) {
+		t.Error("e may be merged with f and g")
+	}
+
+	if p, ok := o.parentId(f); !ok || p != "p" {
+		t.Error(p)
+	}
+
+	// only the known prefixes are stripped
+	o.Prefixes = []string{"1#", "pre"}
+
+	if id := o.overrideId(&gtfs.Stop{Id: "x#a"}); id != "x#a" {
+		t.Error(id)
+	}
+
+	if id := o.overrideId(&gtfs.Stop{Id: "prea"}); id != "a" {
+		t.Error(id)
+	}
+
+	if !o.mustMerge(&gtfs.Stop{Id: "prea"}, &gtfs.Stop{Id: "1#b"}) {
+		t.Error("prefixed a and b should be merged")
+	}
+
+	if o.mustMerge(&gtfs.Stop{Id: "2#a"}, &gtfs.Stop{Id: "1#b"}) {
+		t.Error("2# is not a known prefix")
+	}
+}
+
+func TestStopOverridesDuplicateRemover(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	if e := feed.Parse("./testfeed"); e != nil {
+		t.Error(e)
+		return
+	}
+
+	o, err := writeStopOverrides(t, "separate,B4,duplicateB4\nmerge,B2,duplicate2B4\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	StopDuplicateRemover{Overrides: o}.Run(feed)
+
+	if _, ok := feed.Stops["duplicateB4"]; !ok {
+		t.Error("duplicateB4 should be kept")
+	}
+
+	_, okA := feed.Stops["B2"]
+	_, okB := feed.Stops["duplicate2B4"]
+	if okA == okB {
+		t.Error("B2 and duplicate2B4 should be merged")
+	}
+}
+
+func TestStopOverridesRecluster(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	mk := func(id, name string, lat, lon float32) {
+		feed.Stops[id] = &gtfs.Stop{Id: id, Name: name, Lat: lat, Lon: lon}
+	}
+
+	mk("a1", "Hauptbahnhof", 48.0, 7.8)
+	mk("a2", "Hauptbahnhof", 48.0, 7.8001)
+	mk("b1", "Rathaus", 48.01, 7.8)
+	mk("b2", "Marktplatz", 48.02, 7.8)
+	mk("c1", "Messe", 48.1, 7.9)
+	mk("c2", "Messe", 48.1, 7.9001)
+
+	o, err := writeStopOverrides(t, "separate,a1,a2\nmerge,b1,b2\nparent,station,c1,c2\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	StopReclusterer{DistThreshold: 75, NameSimiThreshold: 0.55, GridCellSize: 10000, Overrides: o}.Run(feed)
+
+	if feed.Stops["a1"].Parent_station != nil && feed.Stops["a1"].Parent_station == feed.Stops["a2"].Parent_station {
+		t.Error("a1 and a2 must not be clustered")
+	}
+
+	if feed.Stops["b1"].Parent_station == nil || feed.Stops["b1"].Parent_station != feed.Stops["b2"].Parent_station {
+		t.Error("b1 and b2 must be clustered")
+	}
+
+	if feed.Stops["c1"].Parent_station == nil || feed.Stops["c1"].Parent_station.Id != "station" || feed.Stops["c2"].Parent_station != feed.Stops["c1"].Parent_station {
+		t.Error("c1 and c2 must have parent 'station'")
+	}
+}
+
+func TestStopOverridesParentEnforcer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		feed.Stops[id] = &gtfs.Stop{Id: id, Name: id, Lat: 48, Lon: 7.8}
+	}
+
+	o, err := writeStopOverrides(t, "merge,a,b\nparent,P,c\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	StopParentEnforcer{Overrides: o}.Run(feed)
+
+	if feed.Stops["a"].Parent_station == nil || feed.Stops["a"].Parent_station != feed.Stops["b"].Parent_station {
+		t.Error("a and b should share a parent")
+	}
+
+	if feed.Stops["c"].Parent_station == nil || feed.Stops["c"].Parent_station.Id != "P" {
+		t.Error("c should have parent P")
+	}
+
+	if feed.Stops["d"].Parent_station == nil || feed.Stops["d"].Parent_station == feed.Stops["a"].Parent_station {
+		t.Error("d should have its own parent")
+	}
+
+	// a, b, c, d + 3 parents
+	if len(feed.Stops) != 7 {
+		t.Error(len(feed.Stops))
+	}
+}
@@ -9,23 +9,71 @@ package processors
 import (
 	"fmt"
 	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 )
 
-// StopParentEnforcer makes sure that all stops have parents
+// StopParentEnforcer makes sure that all stops have parents. Parents given
+// by Overrides are used first, and stops which are always merged according
+// to Overrides share their new parent. If Group is set, nearby parentless
+// stops with similar names (scored as in StopReclusterer, using
+// DistThreshold, NameSimiThreshold and NameSimi) share a single new parent,
+// placed at their centroid and named by their most common name.
 type StopParentEnforcer struct {
+	Overrides         *StopOverrides
+	Group             bool
+	DistThreshold     float64
+	NameSimiThreshold float64
+	NameSimi          *NameSimiConfig
 }
 
 // Run this StopParentEnforcer on some feed
 func (sdr StopParentEnforcer) Run(feed *gtfsparser.Feed) {
 	fmt.Fprintf(os.Stdout, "Adding parent stations to all stops... ")
 
-	after := 0
+	bef := len(feed.Stops)
+	sdr.Overrides.applyParents(feed)
 
-	// collect levels that use stops as parents
+	if sdr.Group {
+		sdr.groupParents(feed)
+	}
+
+	after := len(feed.Stops) - bef
+
+	// parentless stops which should share a parent
+	shared := make(map[*gtfs.Stop]*gtfs.Stop)
+	for _, group := range sdr.Overrides.getMergeGroups(feed, false) {
+		var first *gtfs.Stop
+		for _, s := range group {
+			if s.Location_type == 0 && s.Parent_station == nil {
+				if first == nil {
+					first = s
+				}
+				shared[s] = first
+			}
+		}
+	}
+
+	// iterate in a fixed order, so that shared parents are deterministic
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops))
 	for _, s := range feed.Stops {
+		stops = append(stops, s)
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Id < stops[j].Id
+	})
+
+	for _, s := range stops {
 		if s.Location_type == 0 && s.Parent_station == nil {
+			if first, ok := shared[s]; ok && first != s && first.Parent_station != nil {
+				s.Parent_station = first.Parent_station
+				continue
+			}
+
 			newstop := *s
 
 			newid := ""
@@ -53,3 +101,140 @@ func (sdr StopParentEnforcer) Run(feed *gtfsparser.Feed) {
 
 	fmt.Fprintf(os.Stdout, "done. (+%d stations)\n", (after))
 }
+
+// Create shared parents for clusters of nearby, similarly named parentless stops
+func (sdr StopParentEnforcer) groupParents(feed *gtfsparser.Feed) {
+	stops := make([]*gtfs.Stop, 0)
+	for _, s := range feed.Stops {
+		if s.Location_type == 0 && s.Parent_station == nil {
+			stops = append(stops, s)
+		}
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Id < stops[j].Id
+	})
+
+	// score stops like the StopReclusterer
+	r := StopReclusterer{DistThreshold: sdr.DistThreshold, NameSimiThreshold: sdr.NameSimiThreshold, NameSimi: sdr.NameSimi, Overrides: sdr.Overrides}
+	r.splitregex = regexp.MustCompile(`[^\pL]`)
+	r.buildTfIdfScores(feed.Stops)
+
+	clusters := make([]*StopCluster, len(stops))
+	for i, s := range stops {
+		clusters[i] = NewStopCluster(s)
+	}
+
+	idx := NewStopClusterIdx(clusters, 10000, 10000)
+	uf := newUnionFind(len(stops))
+
+	// members of each component, indexed by its root
+	comps := make([]*StopCluster, len(stops))
+	for i, s := range stops {
+		comps[i] = &StopCluster{Childs: []*gtfs.Stop{s}}
+	}
+
+	union := func(i, j int) {
+		ri, rj := uf.find(i), uf.find(j)
+		if ri == rj {
+			return
+		}
+		uf.union(ri, rj)
+		root := uf.find(ri)
+		other := ri
+		if root == ri {
+			other = rj
+		}
+		comps[root].Childs = append(comps[root].Childs, comps[other].Childs...)
+		comps[other] = nil
+	}
+
+	// stops which are always merged according to the overrides
+	pos := make(map[*gtfs.Stop]int, len(stops))
+	for i, s := range stops {
+		pos[s] = i
+	}
+
+	for _, group := range sdr.Overrides.getMergeGroups(feed, false) {
+		for _, s := range group[1:] {
+			i, oki := pos[group[0]]
+			j, okj := pos[s]
+			if oki && okj {
+				union(i, j)
+			}
+		}
+	}
+
+	// assume a max distortion between mercator coordinate distances and real-world distances of 10
+	for i := range stops {
+		neighs := idx.GetNeighbors(i, clusters[i], sdr.DistThreshold*10)
+
+		cands := make([]int, 0, len(neighs))
+		for j := range neighs {
+			if j > i {
+				cands = append(cands, j)
+			}
+		}
+		sort.Ints(cands)
+
+		for _, j := range cands {
+			if r.stopSimi(stops[i], stops[j]) < 0.5 {
+				continue
+			}
+
+			ri, rj := uf.find(i), uf.find(j)
+			if ri == rj {
+				continue
+			}
+
+			// average linkage, to avoid chaining stops along a street
+			if r.clusterSimi(comps[ri], comps[rj]) >= 0.5 {
+				union(i, j)
+			}
+		}
+	}
+
+	for i := range stops {
+		if uf.find(i) != i || len(comps[i].Childs) < 2 {
+			continue
+		}
+
+		members := comps[i].Childs
+		sort.Slice(members, func(a, b int) bool {
+			return members[a].Id < members[b].Id
+		})
+
+		parent := r.createParent(members, feed)
+		parent.Name = sdr.mostCommonName(members, &r)
+
+		for _, s := range members {
+			s.Parent_station = parent
+		}
+	}
+}
+
+// Get the most common name of stops, compared by their normalized names
+func (sdr StopParentEnforcer) mostCommonName(stops []*gtfs.Stop, r *StopReclusterer) string {
+	counts := make(map[string]int)
+	names := make(map[string]string)
+
+	for _, s := range stops {
+		r.getTokenVec(s)
+		norm := r.names[s]
+		counts[norm]++
+
+		// for each normalized name, take the first original name
+		if _, ok := names[norm]; !ok {
+			names[norm] = s.Name
+		}
+	}
+
+	best := ""
+	for norm := range counts {
+		if len(best) == 0 || counts[norm] > counts[best] || (counts[norm] == counts[best] && norm < best) {
+			best = norm
+		}
+	}
+
+	return names[best]
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"testing"
+)
+
+func TestStopParentEnforcerGroup(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	mk := func(id, name string, lat, lon float32) {
+		feed.Stops[id] = &gtfs.Stop{Id: id, Name: name, Lat: lat, Lon: lon}
+	}
+
+	// stops on both sides of the road, one with a differently written name
+	mk("a1", "Rathaus", 48.0, 7.8)
+	mk("a2", "Rathaus", 48.0002, 7.8)
+	mk("a3", "Rathaus ", 48.0001, 7.8002)
+
+	// same name, but far away
+	mk("b1", "Rathaus", 48.1, 7.8)
+
+	// close, but a different name
+	mk("c1", "Marktplatz", 48.0, 7.8003)
+
+	mk("d1", "Messe", 48.2, 7.9)
+	mk("d2", "Stadion", 48.3, 7.9)
+
+	StopParentEnforcer{Group: true, DistThreshold: 75, NameSimiThreshold: 0.55}.Run(feed)
+
+	for _, s := range feed.Stops {
+		if s.Location_type == 0 && s.Parent_station == nil {
+			t.Errorf("stop %s has no parent", s.Id)
+		}
+	}
+
+	par := feed.Stops["a1"].Parent_station
+	if par != feed.Stops["a2"].Parent_station || par != feed.Stops["a3"].Parent_station {
+		t.Error("a1, a2 and a3 should share a parent")
+		return
+	}
+
+	if par.Name != "Rathaus" {
+		t.Error(par.Name)
+	}
+
+	if math.Abs(float64(par.Lat)-48.0001) > 0.00001 || math.Abs(float64(par.Lon)-7.80006667) > 0.00001 {
+		t.Error(par.Lat, par.Lon)
+	}
+
+	if feed.Stops["b1"].Parent_station == par || feed.Stops["c1"].Parent_station == par {
+		t.Error("b1 and c1 should not share the parent of a1")
+	}
+
+	// 7 stops + 5 parents
+	if len(feed.Stops) != 12 {
+		t.Error(len(feed.Stops))
+	}
+}
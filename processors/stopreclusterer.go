@@ -63,6 +63,9 @@ type StopReclusterer struct {
 	DistThreshold     float64
 	NameSimiThreshold float64
 	GridCellSize      float64
+	Review            *StopMergeReview
+	Overrides         *StopOverrides
+	NameSimi          *NameSimiConfig
 	splitregex        *regexp.Regexp
 
 	// TF-IDF stuff
@@ -70,6 +73,7 @@ type StopReclusterer struct {
 	wordmap    map[string]int
 	vecs       map[*gtfs.Stop]map[int]float64
 	tokens     map[*gtfs.Stop]map[string]int
+	names      map[*gtfs.Stop]string
 
 	idx *StopClusterIdx
 }
@@ -80,6 +84,9 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 
 	m.splitregex = regexp.MustCompile(`[^\pL]`)
 
+	// stops assigned to a parent by the overrides start in its cluster
+	m.Overrides.applyParents(feed)
+
 	clusters := make([]*StopCluster, 0)
 
 	// maps from stops to their parent cluster id
@@ -127,6 +134,16 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 		}
 	}
 
+	// lowest merge score of each merged cluster, for the review
+	scores := make([]float32, len(clusters))
+	merged := make([]bool, len(clusters))
+	for cId := range scores {
+		scores[cId] = 1
+	}
+
+	// clusters which are always merged according to the overrides
+	m.mergeForced(feed, clusters, merged)
+
 	// geographical grid for faster merge cluster candidate retrieval
 	m.idx = NewStopClusterIdx(clusters, m.GridCellSize, m.GridCellSize)
 
@@ -187,6 +204,9 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 		clusters[neigh.id].Parents = append(clusters[neigh.id].Parents, clusters[top.value].Parents...)
 		clusters[neigh.id].Childs = append(clusters[neigh.id].Childs, clusters[top.value].Childs...)
 
+		scores[neigh.id] = float32(math.Min(float64(scores[neigh.id]), math.Min(float64(scores[top.value]), float64(top.priority))))
+		merged[neigh.id] = true
+
 		// clear secondary cluster
 		clusters[top.value].Parents = nil
 		clusters[top.value].Childs = nil
@@ -202,7 +222,7 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 
 	// translate the new cluster into the stop relationship
 	newl := 0 // keep count of the new clusters
-	for _, cl := range clusters {
+	for cId, cl := range clusters {
 		// there might now be empty clusters, skip them
 		if len(cl.Childs) == 0 && len(cl.Parents) == 0 {
 			continue
@@ -213,13 +233,15 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 			continue
 		}
 
-		m.writeCluster(cl, feed)
+		m.writeCluster(cl, feed, merged[cId], scores[cId])
 	}
 
+	m.Overrides.applyParents(feed)
+
 	fmt.Fprintf(os.Stdout, "done. (-%d clusters) [-%.2f%%]\n", (len(clusters) - newl), 100.0*float64(len(clusters)-newl)/(float64(len(clusters))+0.001))
 }
 
-func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
+func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed, merged bool, score float32) {
 	var parent *gtfs.Stop
 
 	if len(cl.Childs) > 1 && len(cl.Parents) == 0 {
@@ -229,6 +251,18 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 		parent = nil
 		bestsimi := float32(0.0)
 
+		// a parent assigned by the overrides always wins
+		for _, c := range cl.Childs {
+			if pid, ok := m.Overrides.parentId(c); ok {
+				for _, p := range cl.Parents {
+					if p.Id == pid || m.Overrides.overrideId(p) == pid {
+						parent = p
+						bestsimi = float32(math.Inf(1))
+					}
+				}
+			}
+		}
+
 		for _, p := range cl.Parents {
 			cursimi := float32(0.0)
 			for _, c := range cl.Childs {
@@ -242,6 +276,10 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 		}
 	}
 
+	if merged && m.Review != nil {
+		m.addToReview(cl, parent, score)
+	}
+
 	for _, s := range cl.Childs {
 		if s.Location_type == 0 || s.Location_type == 2 || s.Location_type == 3 {
 			s.Parent_station = parent
@@ -281,6 +319,103 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 	}
 }
 
+// Merge the clusters containing stops which are always merged according to
+// the overrides
+func (m *StopReclusterer) mergeForced(feed *gtfsparser.Feed, clusters []*StopCluster, merged []bool) {
+	groups := m.Overrides.getMergeGroups(feed, false)
+
+	if len(groups) == 0 {
+		return
+	}
+
+	clusterOf := make(map[*gtfs.Stop]int)
+	for cId, cl := range clusters {
+		for _, s := range cl.Parents {
+			clusterOf[s] = cId
+		}
+		for _, s := range cl.Childs {
+			clusterOf[s] = cId
+		}
+	}
+
+	for _, group := range groups {
+		target := -1
+		for _, s := range group {
+			if cId, ok := clusterOf[s]; ok && (target == -1 || cId < target) {
+				target = cId
+			}
+		}
+
+		for _, s := range group {
+			cId, ok := clusterOf[s]
+			if !ok || cId == target {
+				continue
+			}
+
+			for _, o := range clusters[cId].Parents {
+				clusterOf[o] = target
+			}
+			for _, o := range clusters[cId].Childs {
+				clusterOf[o] = target
+			}
+
+			clusters[target].Parents = append(clusters[target].Parents, clusters[cId].Parents...)
+			clusters[target].Childs = append(clusters[target].Childs, clusters[cId].Childs...)
+			clusters[cId].Parents = nil
+			clusters[cId].Childs = nil
+			merged[target] = true
+		}
+	}
+}
+
+// True if the overrides forbid merging clusters a and b
+func (m *StopReclusterer) separated(a *StopCluster, b *StopCluster) bool {
+	if m.Overrides == nil {
+		return false
+	}
+
+	for _, stA := range append(append([]*gtfs.Stop{}, a.Parents...), a.Childs...) {
+		for _, stB := range append(append([]*gtfs.Stop{}, b.Parents...), b.Childs...) {
+			if !m.Overrides.mayMerge(stA, stB) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Record a merged cluster in the review
+func (m *StopReclusterer) addToReview(cl *StopCluster, parent *gtfs.Stop, score float32) {
+	members := append(append([]*gtfs.Stop{}, cl.Parents...), cl.Childs...)
+
+	// lowest name similarity between any two named members
+	nameSimi := math.NaN()
+	for i, a := range members {
+		vecA, nTokA := m.getTokenVec(a)
+		if nTokA == 0 {
+			continue
+		}
+		for _, b := range members[i+1:] {
+			vecB, nTokB := m.getTokenVec(b)
+			if nTokB == 0 {
+				continue
+			}
+			simi := m.nameSimi(a, vecA, b, vecB)
+			if math.IsNaN(nameSimi) || simi < nameSimi {
+				nameSimi = simi
+			}
+		}
+	}
+
+	e := m.Review.add("StopReclusterer", parent, members, float64(score), nameSimi)
+
+	// clusters are merged if their score is at least 0.5
+	m.Review.flagLow(e, float64(score), 0.5, 1, "merge score near threshold")
+	m.Review.flagLow(e, nameSimi, m.NameSimiThreshold, 1, "name similarity near threshold")
+	m.Review.flagHigh(e, e.diameter, m.DistThreshold, m.DistThreshold, "distance near threshold")
+}
+
 // Create a parent for clusters without explicit parent stop
 func (m *StopReclusterer) createParent(stops []*gtfs.Stop, feed *gtfsparser.Feed) *gtfs.Stop {
 	if len(stops) == 0 {
@@ -420,6 +555,10 @@ func (m *StopReclusterer) getNearestClusters(cId int, clusters []*StopCluster) [
 }
 
 func (m *StopReclusterer) clusterSimi(a *StopCluster, b *StopCluster) float32 {
+	if m.separated(a, b) {
+		return 0
+	}
+
 	ret := float32(0.0)
 	c := 0
 	for _, stA := range a.Childs {
@@ -468,7 +607,7 @@ func (m *StopReclusterer) stopSimi(a *gtfs.Stop, b *gtfs.Stop) float32 {
 		return float32(geosimi)
 	}
 
-	namesimi := cosSimi(vecA, vecB)
+	namesimi := m.nameSimi(a, vecA, b, vecB)
 
 	if namesimi > m.NameSimiThreshold { // this is the threshold value
 		namesimi = 0.5 + (namesimi-m.NameSimiThreshold)/(2*(1-m.NameSimiThreshold))
@@ -479,7 +618,20 @@ func (m *StopReclusterer) stopSimi(a *gtfs.Stop, b *gtfs.Stop) float32 {
 	return float32(geosimi * namesimi)
 }
 
+// Name similarity of stops a and b, given their token vectors
+func (m *StopReclusterer) nameSimi(a *gtfs.Stop, vecA map[int]float64, b *gtfs.Stop, vecB map[int]float64) float64 {
+	simi := cosSimi(vecA, vecB)
+
+	if m.NameSimi != nil && m.NameSimi.JaroWinklerWeight > 0 {
+		w := m.NameSimi.JaroWinklerWeight
+		simi = (1-w)*simi + w*jaroWinkler(m.names[a], m.names[b])
+	}
+
+	return simi
+}
+
 func (m *StopReclusterer) buildTfIdfScores(stops map[string]*gtfs.Stop) {
+	m.names = make(map[*gtfs.Stop]string)
 	m.wordmap = make(map[string]int)
 	m.vecs = make(map[*gtfs.Stop]map[int]float64)
 	m.tokens = make(map[*gtfs.Stop]map[string]int)
@@ -520,17 +672,34 @@ func (m *StopReclusterer) getTokenVec(stop *gtfs.Stop) (map[int]float64, int) {
 	m.vecs[stop] = ret
 	m.tokens[stop] = tokens
 
+	// normalized name with sorted tokens, for the edit distance
+	sorted := make([]string, 0, len(tokens))
+	for token, count := range tokens {
+		for i := 0; i < count; i++ {
+			sorted = append(sorted, token)
+		}
+	}
+	sort.Strings(sorted)
+	m.names[stop] = strings.Join(sorted, " ")
+
 	return ret, len(tokens)
 }
 
 func (m *StopReclusterer) tokenize(s string) map[string]int {
 	ret := make(map[string]int)
 	s = strings.ToUpper(s)
-	tokens := m.splitregex.Split(s, -1)
-	for _, tok := range tokens {
-		if tok == "" {
-			continue
+	tokens := make([]string, 0)
+	for _, tok := range m.splitregex.Split(s, -1) {
+		if tok != "" {
+			tokens = append(tokens, tok)
 		}
+	}
+
+	if m.NameSimi != nil {
+		tokens = m.NameSimi.apply(tokens)
+	}
+
+	for _, tok := range tokens {
 		if _, ok := ret[tok]; ok {
 			ret[tok] = ret[tok] + 1
 		} else {
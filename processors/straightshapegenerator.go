@@ -0,0 +1,135 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+)
+
+// StraightShapeGenerator creates straight-line shapes through the stop
+// coordinates for trips without a shape. Trips with the same stop sequence
+// share a shape. If MaxSegLength is > 0, legs longer than MaxSegLength (in
+// meters) are densified along the great circle, which is useful for long
+// ferry or air legs.
+type StraightShapeGenerator struct {
+	MaxSegLength float64
+}
+
+// Run this StraightShapeGenerator on some feed
+func (ssg StraightShapeGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating straight-line shapes... ")
+
+	patterns := make(map[string][]*gtfs.Trip)
+
+	for _, t := range feed.Trips {
+		if t.Shape != nil || len(t.StopTimes) < 2 {
+			continue
+		}
+
+		key := stopPatternKey(t)
+		patterns[key] = append(patterns[key], t)
+	}
+
+	// sort patterns for deterministic shape IDs
+	pats := make([][]*gtfs.Trip, 0, len(patterns))
+	for _, trips := range patterns {
+		sort.Slice(trips, func(i, j int) bool {
+			return trips[i].Id < trips[j].Id
+		})
+		pats = append(pats, trips)
+	}
+
+	sort.Slice(pats, func(i, j int) bool {
+		return pats[i][0].Id < pats[j][0].Id
+	})
+
+	geoms := make(map[string]*gtfs.Shape)
+	newShapes := 0
+	numTrips := 0
+
+	for _, trips := range pats {
+		pts, idxs := ssg.getPoints(trips[0])
+
+		key := geomKey(pts)
+		shp, ok := geoms[key]
+		var measures []float64
+
+		if !ok {
+			shp, measures = newMeasuredShape(freeShapeId("str", trips[0].Id, feed), pts)
+			feed.Shapes[shp.Id] = shp
+			geoms[key] = shp
+			newShapes++
+		} else {
+			_, measures = newMeasuredShape(shp.Id, pts)
+		}
+
+		for _, t := range trips {
+			t.Shape = shp
+			for i := range t.StopTimes {
+				t.StopTimes[i].SetShape_dist_traveled(float32(measures[idxs[i]]))
+			}
+			numTrips++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d shapes for %d trips)\n", newShapes, numTrips)
+}
+
+// Get the (lat, lon) points of a straight-line shape through the stops of t,
+// and for each stop time the index of its point
+func (ssg StraightShapeGenerator) getPoints(t *gtfs.Trip) ([][2]float64, []int) {
+	pts := make([][2]float64, 0, len(t.StopTimes))
+	idxs := make([]int, len(t.StopTimes))
+
+	for i := range t.StopTimes {
+		lat, lon := getStopLatLon(t.StopTimes[i].Stop())
+		p := [2]float64{float64(lat), float64(lon)}
+
+		if i > 0 && ssg.MaxSegLength > 0 {
+			prev := pts[len(pts)-1]
+			d := haversine(prev[0], prev[1], p[0], p[1])
+			n := int(math.Ceil(d / ssg.MaxSegLength))
+
+			for k := 1; k < n; k++ {
+				pts = append(pts, greatCircleInterpolate(prev, p, float64(k)/float64(n)))
+			}
+		}
+
+		pts = append(pts, p)
+		idxs[i] = len(pts) - 1
+	}
+
+	return pts, idxs
+}
+
+// Interpolate between the (lat, lon) points a and b along the great circle,
+// at fraction f of the way
+func greatCircleInterpolate(a, b [2]float64, f float64) [2]float64 {
+	lat1, lon1 := a[0]*DEG_TO_RAD, a[1]*DEG_TO_RAD
+	lat2, lon2 := b[0]*DEG_TO_RAD, b[1]*DEG_TO_RAD
+
+	// angular distance
+	d := 2 * math.Asin(math.Sqrt(math.Pow(math.Sin((lat2-lat1)/2), 2)+math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon2-lon1)/2), 2)))
+
+	if d == 0 {
+		return a
+	}
+
+	fa := math.Sin((1-f)*d) / math.Sin(d)
+	fb := math.Sin(f*d) / math.Sin(d)
+
+	x := fa*math.Cos(lat1)*math.Cos(lon1) + fb*math.Cos(lat2)*math.Cos(lon2)
+	y := fa*math.Cos(lat1)*math.Sin(lon1) + fb*math.Cos(lat2)*math.Sin(lon2)
+	z := fa*math.Sin(lat1) + fb*math.Sin(lat2)
+
+	return [2]float64{math.Atan2(z, math.Sqrt(x*x+y*y)) / DEG_TO_RAD, math.Atan2(y, x) / DEG_TO_RAD}
+}
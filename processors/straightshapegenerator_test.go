@@ -0,0 +1,69 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"testing"
+)
+
+func TestStraightShapeGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	a := &gtfs.Stop{Id: "a", Lat: 54.0, Lon: 10.0}
+	b := &gtfs.Stop{Id: "b", Lat: 54.0, Lon: 10.1}
+	c := &gtfs.Stop{Id: "c", Lat: 55.0, Lon: 12.0}
+
+	addTrip := func(id string, stops ...*gtfs.Stop) *gtfs.Trip {
+		trip := addTestTrip(feed, id, stops...)
+		for i := range trip.StopTimes {
+			trip.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+		}
+		return trip
+	}
+
+	t1 := addTrip("t1", a, b, c)
+	t2 := addTrip("t2", a, b, c)
+	t3 := addTrip("t3", c, b)
+
+	proc := StraightShapeGenerator{MaxSegLength: 10000}
+	proc.Run(feed)
+
+	if len(feed.Shapes) != 2 {
+		t.Error(feed.Shapes)
+	}
+
+	if t1.Shape == nil || t1.Shape != t2.Shape || t3.Shape == t1.Shape {
+		t.Error("wrong shape assignment")
+	}
+
+	// a-b is ~6.5 km, b-c ~ 160 km and densified
+	if len(t1.Shape.Points) < 18 {
+		t.Error(len(t1.Shape.Points))
+	}
+
+	if t1.StopTimes[0].Shape_dist_traveled() != 0 {
+		t.Error(t1.StopTimes[0].Shape_dist_traveled())
+	}
+
+	if math.Abs(float64(t1.StopTimes[1].Shape_dist_traveled())-haversine(54, 10, 54, 10.1)) > 1 {
+		t.Error(t1.StopTimes[1].Shape_dist_traveled())
+	}
+
+	last := t1.Shape.Points[len(t1.Shape.Points)-1]
+	if last.Dist_traveled != t1.StopTimes[2].Shape_dist_traveled() || last.Lat != 55 || last.Lon != 12 {
+		t.Error(last, t1.StopTimes[2].Shape_dist_traveled())
+	}
+
+	// densified points lie on the great circle, north of the rhumb line
+	mid := t1.Shape.Points[len(t1.Shape.Points)/2+1]
+	if float64(mid.Lat) <= 54.0+(float64(mid.Lon)-10.1)/1.9 {
+		t.Error(mid)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// Add a trip with one stop time per stop to feed
+func addTestTrip(feed *gtfsparser.Feed, id string, stops ...*gtfs.Stop) *gtfs.Trip {
+	trip := &gtfs.Trip{Id: id}
+	for _, s := range stops {
+		st := gtfs.StopTime{}
+		st.SetStop(s)
+		trip.StopTimes = append(trip.StopTimes, st)
+	}
+	feed.Trips[id] = trip
+	return trip
+}
+
+// Add a trip from stop from to stop to to feed, departing at dep and
+// arriving at arr (in minutes after midnight)
+func addTestTimedTrip(feed *gtfsparser.Feed, id string, from *gtfs.Stop, dep int, to *gtfs.Stop, arr int) *gtfs.Trip {
+	trip := addTestTrip(feed, id, from, to)
+	for i, m := range []int{dep, arr} {
+		trip.StopTimes[i].SetArrival_time(gtfs.Time{Hour: int16(m / 60), Minute: int8(m % 60)})
+		trip.StopTimes[i].SetDeparture_time(trip.StopTimes[i].Arrival_time())
+	}
+	return trip
+}
+
+// Get the stops of feed with the given IDs
+func testStops(feed *gtfsparser.Feed, ids ...string) []*gtfs.Stop {
+	ret := make([]*gtfs.Stop, len(ids))
+	for i, id := range ids {
+		ret[i] = feed.Stops[id]
+	}
+	return ret
+}
@@ -0,0 +1,282 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"os"
+	"sort"
+)
+
+// TransferGenerator creates transfers (transfer_type=2) between served stops
+// which share a parent station or are at most MaxWalkDist meters apart. The
+// min_transfer_time is the walking time at WalkSpeed (in m/s), or, where the
+// stops are connected by pathways, the traversal time along the pathways.
+// Existing transfers are never changed, and at most MaxPerStop transfers are
+// generated from each stop (the fastest ones). If SkipSameRoutes is set,
+// transfers between stops served by exactly the same routes are skipped.
+type TransferGenerator struct {
+	MaxWalkDist    float64
+	WalkSpeed      float64
+	MaxPerStop     int
+	SkipSameRoutes bool
+}
+
+type transferCand struct {
+	to   *gtfs.Stop
+	time float64
+}
+
+// Run this TransferGenerator on some feed
+func (tg TransferGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating transfers... ")
+
+	routes := make(map[*gtfs.Stop]map[*gtfs.Route]bool)
+	for _, t := range feed.Trips {
+		for i := range t.StopTimes {
+			s := t.StopTimes[i].Stop()
+			if routes[s] == nil {
+				routes[s] = make(map[*gtfs.Route]bool)
+			}
+			routes[s][t.Route] = true
+		}
+	}
+
+	stops := make([]*gtfs.Stop, 0, len(routes))
+	for s := range routes {
+		if s.Location_type == 0 {
+			stops = append(stops, s)
+		}
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Id < stops[j].Id
+	})
+
+	// stop pairs with existing transfers
+	existing := make(map[[2]*gtfs.Stop]bool)
+	for tk := range feed.Transfers {
+		existing[[2]*gtfs.Stop{tk.From_stop, tk.To_stop}] = true
+	}
+
+	// served stops by parent station
+	siblings := make(map[*gtfs.Stop][]int)
+	for i, s := range stops {
+		if s.Parent_station != nil {
+			siblings[s.Parent_station] = append(siblings[s.Parent_station], i)
+		}
+	}
+
+	clusters := make([]*StopCluster, len(stops))
+	for i, s := range stops {
+		clusters[i] = NewStopCluster(s)
+	}
+
+	idx := NewStopClusterIdx(clusters, 10000, 10000)
+	graph := tg.buildPathwayGraph(feed)
+
+	n := 0
+
+	for i, from := range stops {
+		cands := make(map[*gtfs.Stop]float64)
+
+		add := func(to *gtfs.Stop, time float64) {
+			if to == from || to.Location_type != 0 || routes[to] == nil || existing[[2]*gtfs.Stop{from, to}] || (tg.SkipSameRoutes && sameRoutes(routes[from], routes[to])) {
+				return
+			}
+			if cur, ok := cands[to]; !ok || time < cur {
+				cands[to] = time
+			}
+		}
+
+		// walking distance
+		lat, _ := getStopLatLon(from)
+		mercDist := tg.MaxWalkDist / math.Max(0.01, math.Cos(float64(lat)*DEG_TO_RAD))
+		for j := range idx.GetNeighbors(i, clusters[i], mercDist) {
+			if d := stopDist(from, stops[j]); d <= tg.MaxWalkDist {
+				add(stops[j], d/tg.WalkSpeed)
+			}
+		}
+
+		if from.Parent_station != nil {
+			for _, j := range siblings[from.Parent_station] {
+				add(stops[j], stopDist(from, stops[j])/tg.WalkSpeed)
+			}
+		}
+
+		// pathways take precedence over the straight-line estimate
+		for to, time := range graph.reachable(from) {
+			if _, ok := cands[to]; ok {
+				cands[to] = time
+			} else {
+				add(to, time)
+			}
+		}
+
+		sorted := make([]transferCand, 0, len(cands))
+		for to, time := range cands {
+			sorted = append(sorted, transferCand{to, time})
+		}
+
+		sort.Slice(sorted, func(a, b int) bool {
+			if sorted[a].time != sorted[b].time {
+				return sorted[a].time < sorted[b].time
+			}
+			return sorted[a].to.Id < sorted[b].to.Id
+		})
+
+		if tg.MaxPerStop > 0 && len(sorted) > tg.MaxPerStop {
+			sorted = sorted[:tg.MaxPerStop]
+		}
+
+		for _, c := range sorted {
+			tk := gtfs.TransferKey{From_stop: from, To_stop: c.to}
+			feed.Transfers[tk] = gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: int(math.Ceil(c.time))}
+			n++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d transfers)\n", n)
+}
+
+// Distance in meters between two stops
+func stopDist(a, b *gtfs.Stop) float64 {
+	latA, lonA := getStopLatLon(a)
+	latB, lonB := getStopLatLon(b)
+	return haversine(float64(latA), float64(lonA), float64(latB), float64(lonB))
+}
+
+// True if both route sets are equal
+func sameRoutes(a, b map[*gtfs.Route]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for r := range a {
+		if !b[r] {
+			return false
+		}
+	}
+	return true
+}
+
+type pathwayEdge struct {
+	to   int
+	time float64
+}
+
+type pathwayGraph struct {
+	ids   map[*gtfs.Stop]int
+	stops []*gtfs.Stop
+	adj   [][]pathwayEdge
+}
+
+// Build the graph of pathways, with traversal times in seconds. Platforms
+// are connected to their boarding areas at no cost.
+func (tg TransferGenerator) buildPathwayGraph(feed *gtfsparser.Feed) *pathwayGraph {
+	g := &pathwayGraph{ids: make(map[*gtfs.Stop]int)}
+
+	node := func(s *gtfs.Stop) int {
+		if id, ok := g.ids[s]; ok {
+			return id
+		}
+		g.ids[s] = len(g.stops)
+		g.stops = append(g.stops, s)
+		g.adj = append(g.adj, nil)
+		return g.ids[s]
+	}
+
+	pws := make([]*gtfs.Pathway, 0, len(feed.Pathways))
+	for _, p := range feed.Pathways {
+		pws = append(pws, p)
+	}
+
+	sort.Slice(pws, func(i, j int) bool {
+		return pws[i].Id < pws[j].Id
+	})
+
+	for _, p := range pws {
+		if p.From_stop == nil || p.To_stop == nil {
+			continue
+		}
+
+		time := float64(p.Traversal_time)
+		if p.Traversal_time < 0 {
+			length := float64(p.Length)
+			if math.IsNaN(length) || length <= 0 {
+				length = stopDist(p.From_stop, p.To_stop)
+			}
+			time = length / tg.WalkSpeed
+		}
+
+		a, b := node(p.From_stop), node(p.To_stop)
+		g.adj[a] = append(g.adj[a], pathwayEdge{b, time})
+		if p.Is_bidirectional {
+			g.adj[b] = append(g.adj[b], pathwayEdge{a, time})
+		}
+	}
+
+	if len(g.stops) == 0 {
+		return g
+	}
+
+	for _, s := range feed.Stops {
+		if s.Location_type != 4 || s.Parent_station == nil {
+			continue
+		}
+		if _, ok := g.ids[s]; !ok {
+			continue
+		}
+		a, b := node(s), node(s.Parent_station)
+		g.adj[a] = append(g.adj[a], pathwayEdge{b, 0})
+		g.adj[b] = append(g.adj[b], pathwayEdge{a, 0})
+	}
+
+	return g
+}
+
+// Get the traversal times from stop s to all stops reachable via pathways
+func (g *pathwayGraph) reachable(s *gtfs.Stop) map[*gtfs.Stop]float64 {
+	ret := make(map[*gtfs.Stop]float64)
+
+	src, ok := g.ids[s]
+	if !ok {
+		return ret
+	}
+
+	dists := make([]float64, len(g.stops))
+	for i := range dists {
+		dists[i] = math.Inf(1)
+	}
+	dists[src] = 0
+
+	pq := &distQueue{}
+	heap.Push(pq, distItem{src, 0})
+
+	for pq.Len() > 0 {
+		cur := heap.Pop(pq).(distItem)
+		if cur.dist > dists[cur.node] {
+			continue
+		}
+
+		if cur.node != src {
+			ret[g.stops[cur.node]] = cur.dist
+		}
+
+		for _, e := range g.adj[cur.node] {
+			if d := cur.dist + e.time; d < dists[e.to] {
+				dists[e.to] = d
+				heap.Push(pq, distItem{e.to, d})
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestTransferGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	station := &gtfs.Stop{Id: "S", Lat: 48, Lon: 7.8, Location_type: 1}
+	stops := map[string]*gtfs.Stop{
+		"S":  station,
+		"a":  {Id: "a", Lat: 48, Lon: 7.8},
+		"b":  {Id: "b", Lat: 48, Lon: 7.801},  // ~74 m from a
+		"c":  {Id: "c", Lat: 48, Lon: 7.81},   // ~740 m from a
+		"d":  {Id: "d", Lat: 48, Lon: 7.8005}, // same routes as a
+		"p1": {Id: "p1", Lat: 48.01, Lon: 7.8, Parent_station: station},
+		"p2": {Id: "p2", Lat: 48.0101, Lon: 7.801, Parent_station: station},
+		"p3": {Id: "p3", Lat: 48.01, Lon: 7.803, Parent_station: station},
+	}
+	for id, s := range stops {
+		feed.Stops[id] = s
+	}
+
+	r1 := &gtfs.Route{Id: "r1"}
+	r2 := &gtfs.Route{Id: "r2"}
+	r3 := &gtfs.Route{Id: "r3"}
+
+	addTrip := func(id string, r *gtfs.Route, ids ...string) {
+		addTestTrip(feed, id, testStops(feed, ids...)...).Route = r
+	}
+
+	addTrip("t1", r1, "a", "d", "p1")
+	addTrip("t2", r2, "b", "c", "p2")
+	addTrip("t3", r3, "p3", "p3")
+
+	// pathway between the platforms p1 and p2, p3 is only in the same station
+	feed.Pathways["pw"] = &gtfs.Pathway{Id: "pw", From_stop: stops["p1"], To_stop: stops["p2"], Mode: 1, Is_bidirectional: true, Traversal_time: 120}
+
+	// existing transfer must not be changed
+	existing := gtfs.TransferKey{From_stop: stops["b"], To_stop: stops["a"]}
+	feed.Transfers[existing] = gtfs.TransferVal{Transfer_type: 3}
+
+	TransferGenerator{MaxWalkDist: 200, WalkSpeed: 1, MaxPerStop: 10}.Run(feed)
+
+	get := func(from, to string) (gtfs.TransferVal, bool) {
+		v, ok := feed.Transfers[gtfs.TransferKey{From_stop: stops[from], To_stop: stops[to]}]
+		return v, ok
+	}
+
+	if v, ok := get("a", "b"); !ok || v.Transfer_type != 2 || v.Min_transfer_time < 70 || v.Min_transfer_time > 80 {
+		t.Error("expected transfer a -> b", v)
+	}
+
+	if v, _ := get("b", "a"); v.Transfer_type != 3 {
+		t.Error("existing transfer b -> a was changed")
+	}
+
+	if _, ok := get("a", "c"); ok {
+		t.Error("c is too far away from a")
+	}
+
+	if _, ok := get("a", "d"); !ok {
+		t.Error("expected transfer a -> d")
+	}
+
+	if v, ok := get("p2", "p1"); !ok || v.Min_transfer_time != 120 {
+		t.Error("expected pathway traversal time for p2 -> p1", v)
+	}
+
+	// p1 and p3 share a parent, but are ~220 m apart
+	if v, ok := get("p1", "p3"); !ok || v.Min_transfer_time < 200 || v.Min_transfer_time > 240 {
+		t.Error("expected transfer p1 -> p3 via shared parent", v)
+	}
+
+	// stops served by the same routes
+	feed.Transfers = make(map[gtfs.TransferKey]gtfs.TransferVal)
+	TransferGenerator{MaxWalkDist: 200, WalkSpeed: 1, MaxPerStop: 10, SkipSameRoutes: true}.Run(feed)
+
+	if _, ok := get("a", "d"); ok {
+		t.Error("a and d are served by the same routes")
+	}
+
+	if _, ok := get("a", "b"); !ok {
+		t.Error("expected transfer a -> b")
+	}
+
+	// caps
+	feed.Transfers = make(map[gtfs.TransferKey]gtfs.TransferVal)
+	TransferGenerator{MaxWalkDist: 200, WalkSpeed: 1, MaxPerStop: 1}.Run(feed)
+
+	if _, ok := get("p1", "p3"); ok {
+		t.Error("only the fastest transfer from p1 should be generated")
+	}
+
+	if _, ok := get("p1", "p2"); !ok {
+		t.Error("expected transfer p1 -> p2")
+	}
+}
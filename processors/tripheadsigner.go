@@ -9,18 +9,44 @@ package processors
 import (
 	"fmt"
 	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
 	"os"
+	"sort"
 )
 
 // TripHeadsigner assigns trips without a headsign a headsign based
-// on the last stop
+// on the last stop. Loop trips get the stop farthest away from their origin
+// as headsign. If Via is set, generated headsigns are extended to
+// "Destination via X", where X is the intermediate station which best
+// distinguishes the trip from other trips of its route to the same
+// destination. If StopHeadsigns is set, loop trips which got a generated
+// headsign and have no stop_headsigns get stop_headsigns for the outbound
+// and the inbound part. Other trips are not changed, even if their
+// destination changes along the way. If ElideStopHeadsigns is set,
+// stop_headsigns equal to the trip headsign are removed.
 type TripHeadsigner struct {
+	Via                bool
+	StopHeadsigns      bool
+	ElideStopHeadsigns bool
+}
+
+// a trip with a generated headsign
+type headsignedTrip struct {
+	trip *gtfs.Trip
+	dest string
+	loop bool
 }
 
 // Run this TripHeadsigner on some feed
 func (sdr TripHeadsigner) Run(feed *gtfsparser.Feed) {
 	fmt.Fprintf(os.Stdout, "Adding missing headsigns to all trips... ")
 
+	generated := make([]headsignedTrip, 0)
+	added := 0
+	vias := 0
+	stopAdded := 0
+	elided := 0
+
 	for _, t := range feed.Trips {
 		if len(t.StopTimes) == 0 {
 			continue
@@ -29,23 +55,262 @@ func (sdr TripHeadsigner) Run(feed *gtfsparser.Feed) {
 			// first, check if first stoptime has a headsign
 			if t.StopTimes[0].Headsign() != nil && len(*t.StopTimes[0].Headsign()) != 0 {
 				t.Headsign = t.StopTimes[0].Headsign()
+				added++
 				continue
 			}
 
-			// next, check if the last station has a parent station with a non-empty name
-			if t.StopTimes[len(t.StopTimes) - 1].Stop().Parent_station != nil && len(t.StopTimes[len(t.StopTimes) - 1].Stop().Parent_station.Name) != 0 {
+			if ht, ok := sdr.destination(t); ok {
+				hs := ht.dest
+				t.Headsign = &hs
+				generated = append(generated, ht)
+				added++
+			}
+		}
+	}
+
+	if sdr.Via {
+		vias = sdr.addVias(feed, generated)
+	}
+
+	if sdr.StopHeadsigns {
+		for _, ht := range generated {
+			stopAdded += sdr.fillStopHeadsigns(ht)
+		}
+	}
+
+	if sdr.ElideStopHeadsigns {
+		for _, t := range feed.Trips {
+			elided += sdr.elideStopHeadsigns(t)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d trip headsigns, %d with via, +%d stop headsigns, -%d redundant stop headsigns)\n", added, vias, stopAdded, elided)
+}
+
+// Get the name of the station of a stop, or of the stop itself
+func stationName(s *gtfs.Stop) string {
+	if s.Parent_station != nil && len(s.Parent_station.Name) != 0 {
+		return s.Parent_station.Name
+	}
+	return s.Name
+}
+
+// Get the station of a stop, or the stop itself
+func stationOrSelf(s *gtfs.Stop) *gtfs.Stop {
+	if s.Parent_station != nil {
+		return s.Parent_station
+	}
+	return s
+}
+
+// Generate the headsign of a trip from its stops. For loops, this is the
+// stop farthest away from the origin.
+func (sdr TripHeadsigner) destination(t *gtfs.Trip) (headsignedTrip, bool) {
+	first := t.StopTimes[0].Stop()
+	last := t.StopTimes[len(t.StopTimes)-1].Stop()
+
+	if len(t.StopTimes) > 2 && (stationOrSelf(first) == stationOrSelf(last) || (len(first.Name) != 0 && stationName(first) == stationName(last))) {
+		if far := farthestStop(t); far != nil && len(stationName(far)) != 0 {
+			return headsignedTrip{t, stationName(far), true}, true
+		}
+	}
+
+	// the name of the last station, or, as a fallback, the name of the last stop
+	if len(stationName(last)) != 0 {
+		return headsignedTrip{t, stationName(last), false}, true
+	}
+
+	return headsignedTrip{}, false
+}
+
+// Get the stop of a trip farthest away from its first stop, or nil if there
+// is none with a different name
+func farthestStop(t *gtfs.Trip) *gtfs.Stop {
+	first := t.StopTimes[0].Stop()
+
+	var ret *gtfs.Stop
+	maxD := -1.0
+
+	for i := 1; i < len(t.StopTimes)-1; i++ {
+		s := t.StopTimes[i].Stop()
+		if stationName(s) == stationName(first) {
+			continue
+		}
+		if d := stopDist(first, s); d > maxD {
+			maxD = d
+			ret = s
+		}
+	}
+
+	return ret
+}
+
+// Extend generated headsigns by the most distinctive intermediate station,
+// returns the number of headsigns extended
+func (sdr TripHeadsigner) addVias(feed *gtfsparser.Feed, trips []headsignedTrip) int {
+	// number of routes serving each station
+	routes := make(map[*gtfs.Stop]map[*gtfs.Route]bool)
+	for _, t := range feed.Trips {
+		for i := range t.StopTimes {
+			st := stationOrSelf(t.StopTimes[i].Stop())
+			if routes[st] == nil {
+				routes[st] = make(map[*gtfs.Route]bool)
+			}
+			routes[st][t.Route] = true
+		}
+	}
+
+	type groupKey struct {
+		route *gtfs.Route
+		dest  string
+	}
+
+	groups := make(map[groupKey][]headsignedTrip)
+	for _, ht := range trips {
+		k := groupKey{ht.trip.Route, ht.dest}
+		groups[k] = append(groups[k], ht)
+	}
+
+	n := 0
+
+	for _, group := range groups {
+		// number of trips of the group passing each station name
+		passing := make(map[string]int)
+		for _, ht := range group {
+			for name := range sdr.viaCands(ht) {
+				passing[name]++
+			}
+		}
+
+		for _, ht := range group {
+			cands := sdr.viaCands(ht)
+
+			names := make([]string, 0, len(cands))
+			for name := range cands {
+				names = append(names, name)
+			}
+
+			// prefer stations passed by few trips of the group, then
+			// stations served by many routes
+			sort.Slice(names, func(i, j int) bool {
+				if passing[names[i]] != passing[names[j]] {
+					return passing[names[i]] < passing[names[j]]
+				}
+				ri, rj := len(routes[cands[names[i]]]), len(routes[cands[names[j]]])
+				if ri != rj {
+					return ri > rj
+				}
+				return names[i] < names[j]
+			})
 
-				t.Headsign = &t.StopTimes[len(t.StopTimes) - 1].Stop().Parent_station.Name
+			if len(names) == 0 {
 				continue
 			}
 
-			// as a fallback, use the name of the last stop, if non-empty
-			if len(t.StopTimes[len(t.StopTimes) - 1].Stop().Name) != 0 {
-				t.Headsign = &t.StopTimes[len(t.StopTimes) - 1].Stop().Name
+			best := names[0]
+
+			// a station passed by all trips of the group is only worth
+			// mentioning if it is an interchange
+			if passing[best] == len(group) && len(routes[cands[best]]) < 2 {
 				continue
 			}
+
+			hs := ht.dest + " via " + best
+			if ht.loop {
+				hs = stationName(ht.trip.StopTimes[0].Stop()) + " via " + ht.dest
+				if best != ht.dest {
+					hs = hs + ", " + best
+				}
+			}
+
+			ht.trip.Headsign = &hs
+			n++
+		}
+	}
+
+	return n
+}
+
+// Get the intermediate stations of a trip which may be used as via, by name
+func (sdr TripHeadsigner) viaCands(ht headsignedTrip) map[string]*gtfs.Stop {
+	ret := make(map[string]*gtfs.Stop)
+
+	first := stationName(ht.trip.StopTimes[0].Stop())
+	last := stationName(ht.trip.StopTimes[len(ht.trip.StopTimes)-1].Stop())
+
+	for i := 1; i < len(ht.trip.StopTimes)-1; i++ {
+		s := ht.trip.StopTimes[i].Stop()
+		name := stationName(s)
+		if len(name) == 0 || name == first || name == last || (name == ht.dest && !ht.loop) {
+			continue
+		}
+		if _, ok := ret[name]; !ok {
+			ret[name] = stationOrSelf(s)
+		}
+	}
+
+	return ret
+}
+
+// Fill in stop_headsigns on a loop trip with a generated headsign: stops
+// before the farthest stop get its name, and stops after it the name of
+// the origin. Trips which already have stop_headsigns are left alone, as
+// an empty stop_headsign there means the trip headsign. Returns the number
+// of stop_headsigns added.
+func (sdr TripHeadsigner) fillStopHeadsigns(ht headsignedTrip) int {
+	t := ht.trip
+
+	if !ht.loop || len(t.StopTimes) < 2 {
+		return 0
+	}
+
+	for i := range t.StopTimes {
+		if hs := t.StopTimes[i].Headsign(); hs != nil && len(*hs) != 0 {
+			return 0
+		}
+	}
+
+	n := 0
+
+	far := farthestStop(t)
+	outbound := stationName(far)
+	inbound := stationName(t.StopTimes[0].Stop())
+
+	// the last stop needs no headsign
+	passed := false
+	for i := 0; i < len(t.StopTimes)-1; i++ {
+		if t.StopTimes[i].Stop() == far {
+			passed = true
+		}
+		if passed {
+			t.StopTimes[i].SetHeadsign(&inbound)
+		} else {
+			t.StopTimes[i].SetHeadsign(&outbound)
+		}
+		n++
+	}
+
+	return n
+}
+
+// Remove stop_headsigns equal to the trip headsign, returns the number of
+// stop_headsigns removed
+func (sdr TripHeadsigner) elideStopHeadsigns(t *gtfs.Trip) int {
+	if t.Headsign == nil || len(*t.Headsign) == 0 {
+		return 0
+	}
+
+	n := 0
+
+	// the writer expects a stop_headsign, use an empty one
+	empty := ""
+
+	for i := range t.StopTimes {
+		if hs := t.StopTimes[i].Headsign(); hs != nil && len(*hs) != 0 && *hs == *t.Headsign {
+			t.StopTimes[i].SetHeadsign(&empty)
+			n++
 		}
 	}
 
-	fmt.Fprintf(os.Stdout, "done.\n")
+	return n
 }
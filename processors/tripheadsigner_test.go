@@ -0,0 +1,155 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"github.com/patrickbr/gtfswriter"
+	"os"
+	"testing"
+)
+
+func headsignTestFeed() (*gtfsparser.Feed, func(id string, r *gtfs.Route, ids ...string) *gtfs.Trip) {
+	feed := gtfsparser.NewFeed()
+
+	hbf := &gtfs.Stop{Id: "hbf", Name: "Hbf", Lat: 48, Lon: 7.8, Location_type: 1}
+	stops := map[string]*gtfs.Stop{
+		"hbf": hbf,
+		"a1":  {Id: "a1", Name: "Hbf Gleis 1", Lat: 48, Lon: 7.8, Parent_station: hbf},
+		"a2":  {Id: "a2", Name: "Hbf Gleis 2", Lat: 48, Lon: 7.8, Parent_station: hbf},
+		"b":   {Id: "b", Name: "B", Lat: 48, Lon: 7.81},
+		"c":   {Id: "c", Name: "C", Lat: 48, Lon: 7.82},
+		"d":   {Id: "d", Name: "D", Lat: 48, Lon: 7.83},
+		"e":   {Id: "e", Name: "E", Lat: 48.01, Lon: 7.815},
+		"f":   {Id: "f", Name: "F", Lat: 48.01, Lon: 7.82},
+	}
+	for id, s := range stops {
+		feed.Stops[id] = s
+	}
+
+	return feed, func(id string, r *gtfs.Route, ids ...string) *gtfs.Trip {
+		trip := addTestTrip(feed, id, testStops(feed, ids...)...)
+		trip.Route = r
+		return trip
+	}
+}
+
+func TestTripHeadsigner(t *testing.T) {
+	feed, addTrip := headsignTestFeed()
+	r := &gtfs.Route{Id: "r"}
+
+	t1 := addTrip("t1", r, "a1", "b", "c", "d")
+	loop := addTrip("loop", r, "a1", "b", "f", "e", "a2")
+
+	// a loop with a producer headsign gets no stop headsigns
+	ploop := addTrip("ploop", r, "a1", "b", "f", "e", "a2")
+	z := "Z"
+	ploop.Headsign = &z
+
+	// changing stop headsigns
+	sh := addTrip("sh", r, "a1", "b", "c", "d", "f")
+	x, y := "X", "Y"
+	sh.StopTimes[0].SetHeadsign(&x)
+	sh.StopTimes[2].SetHeadsign(&y)
+	sh.Headsign = &y
+
+	TripHeadsigner{StopHeadsigns: true, ElideStopHeadsigns: true}.Run(feed)
+
+	if t1.Headsign == nil || *t1.Headsign != "D" {
+		t.Errorf("expected headsign D for t1, got %v", t1.Headsign)
+	}
+
+	// the farthest stop from the origin, not the origin itself
+	if loop.Headsign == nil || *loop.Headsign != "F" {
+		t.Errorf("expected headsign F for the loop, got %v", loop.Headsign)
+	}
+
+	for i := range ploop.StopTimes {
+		if ploop.StopTimes[i].Headsign() != nil {
+			t.Errorf("ploop stop %d: expected no stop headsign", i)
+		}
+	}
+
+	exp := []string{"", "", "Hbf", "Hbf", ""}
+	for i, e := range exp {
+		hs := loop.StopTimes[i].Headsign()
+		if (hs == nil && len(e) != 0) || (hs != nil && *hs != e) {
+			t.Errorf("loop stop %d: expected stop headsign '%s', got %v", i, e, hs)
+		}
+	}
+
+	// trips with a producer headsign keep their stop headsigns, only the
+	// one equal to the trip headsign is removed
+	exp = []string{"X", "", "", "", ""}
+	for i, e := range exp {
+		hs := sh.StopTimes[i].Headsign()
+		if (hs == nil && len(e) != 0) || (hs != nil && *hs != e) {
+			t.Errorf("stop %d: expected stop headsign '%s', got %v", i, e, hs)
+		}
+	}
+}
+
+func TestTripHeadsignerVia(t *testing.T) {
+	feed, addTrip := headsignTestFeed()
+	r := &gtfs.Route{Id: "r"}
+	r2 := &gtfs.Route{Id: "r2"}
+
+	v1 := addTrip("v1", r, "a1", "b", "c", "d")
+	v2 := addTrip("v2", r, "a1", "e", "d")
+
+	// C is an interchange
+	addTrip("other", r2, "c", "f")
+
+	// only one pattern to this destination, and no interchange
+	v3 := addTrip("v3", r, "d", "e", "a1")
+
+	TripHeadsigner{Via: true}.Run(feed)
+
+	if *v1.Headsign != "D via C" {
+		t.Errorf("expected 'D via C', got '%s'", *v1.Headsign)
+	}
+
+	if *v2.Headsign != "D via E" {
+		t.Errorf("expected 'D via E', got '%s'", *v2.Headsign)
+	}
+
+	if *v3.Headsign != "Hbf" {
+		t.Errorf("expected 'Hbf', got '%s'", *v3.Headsign)
+	}
+}
+
+func TestTripHeadsignerElideWrite(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	if e := feed.Parse("./testfeed"); e != nil {
+		t.Fatal(e)
+	}
+
+	// an empty trip headsign, generated from the first stop headsign
+	trip := feed.Trips["STBA"]
+	empty := ""
+	two := "Two"
+	trip.Headsign = &empty
+	trip.StopTimes[0].SetHeadsign(&two)
+
+	TripHeadsigner{ElideStopHeadsigns: true}.Run(feed)
+
+	if hs := trip.StopTimes[0].Headsign(); hs == nil || len(*hs) != 0 {
+		t.Errorf("expected empty stop headsign, got %v", hs)
+	}
+
+	dir, err := os.MkdirTemp("", "tripheadsigner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := gtfswriter.Writer{Sorted: true}
+	if e := w.Write(feed, dir); e != nil {
+		t.Error(e)
+	}
+}
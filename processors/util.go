@@ -8,7 +8,10 @@ package processors
 
 import (
 	"math"
+	"strconv"
+	"strings"
 
+	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
 )
 
@@ -309,3 +312,101 @@ func boolsToBytes(t []bool) []byte {
 	}
 	return b
 }
+
+// Get a key identifying the stop sequence of a trip
+func stopPatternKey(t *gtfs.Trip) string {
+	var b strings.Builder
+	for i := range t.StopTimes {
+		b.WriteString(t.StopTimes[i].Stop().Id)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// Get a key identifying the exact geometry of a list of (lat, lon) points
+func geomKey(pts [][2]float64) string {
+	var b strings.Builder
+	for _, p := range pts {
+		b.WriteString(strconv.FormatUint(uint64(math.Float32bits(float32(p[0]))), 16))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatUint(uint64(math.Float32bits(float32(p[1]))), 16))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// Get an unused shape ID of the form "<prefix>::<id>"
+func freeShapeId(prefix string, id string, feed *gtfsparser.Feed) string {
+	for try := 0; ; try++ {
+		ret := prefix + "::" + id
+		if try > 0 {
+			ret = prefix + strconv.Itoa(try) + "::" + id
+		}
+		if _, ok := feed.Shapes[ret]; !ok {
+			return ret
+		}
+	}
+}
+
+// Build a shape from a list of (lat, lon) points, measured in meters. Returns
+// the shape and the measure of each input point. Consecutive duplicate points
+// are only written once.
+func newMeasuredShape(id string, pts [][2]float64) (*gtfs.Shape, []float64) {
+	shp := &gtfs.Shape{Id: id, Points: make(gtfs.ShapePoints, 0, len(pts))}
+	measures := make([]float64, len(pts))
+
+	d := 0.0
+	for i, p := range pts {
+		if i > 0 {
+			d += haversine(pts[i-1][0], pts[i-1][1], p[0], p[1])
+		}
+		measures[i] = d
+
+		if i > 0 && float32(p[0]) == float32(pts[i-1][0]) && float32(p[1]) == float32(pts[i-1][1]) {
+			continue
+		}
+
+		shp.Points = append(shp.Points, gtfs.ShapePoint{
+			Lat:           float32(p[0]),
+			Lon:           float32(p[1]),
+			Sequence:      uint32(len(shp.Points)),
+			Dist_traveled: float32(d),
+		})
+	}
+
+	return shp, measures
+}
+
+// Re-measure the stop times of trip t, which were measured along the points
+// pts, using new measures for each of these points
+func remapStopTimeMeasures(t *gtfs.Trip, pts gtfs.ShapePoints, measures []float64) {
+	j := 0
+	for i := range t.StopTimes {
+		st := &t.StopTimes[i]
+		if !st.HasDistanceTraveled() {
+			continue
+		}
+
+		m := st.Shape_dist_traveled()
+
+		for j < len(pts)-2 && pts[j+1].Dist_traveled < m {
+			j++
+		}
+
+		if j == 0 && m <= pts[0].Dist_traveled {
+			st.SetShape_dist_traveled(float32(measures[0]))
+			continue
+		}
+
+		pa := pts[j].Dist_traveled
+		pb := pts[j+1].Dist_traveled
+
+		if m >= pb || pb == pa {
+			st.SetShape_dist_traveled(float32(measures[j+1]))
+			continue
+		}
+
+		progr := float64(m-pa) / float64(pb-pa)
+		st.SetShape_dist_traveled(float32(measures[j] + progr*(measures[j+1]-measures[j])))
+	}
+}